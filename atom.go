@@ -0,0 +1,111 @@
+package gowen
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// AtomNode is a mutable reference to an (immutable) node. Its value is changed atomically by Swap and Reset -
+// it is safe to use from multiple goroutines. Atoms are equal only to themselves.
+type AtomNode struct{ *atom }
+
+type atom struct {
+	value     atomic.Value // *atomValue - boxed so that values that are not comparable can be compared and swapped
+	mu        sync.Mutex   // guards validator and watches
+	validator func(v Node, env *Env) bool
+	watches   []atomWatch
+}
+
+type atomValue struct{ Node }
+
+type atomWatch struct {
+	key Node
+	fn  func(key Node, a AtomNode, old, new Node, env *Env)
+}
+
+// NewAtom returns an atom with the initial value v.
+func NewAtom(v Node) AtomNode {
+	a := AtomNode{&atom{}}
+	a.value.Store(&atomValue{v})
+	return a
+}
+
+func (a AtomNode) Deref() Node { return a.value.Load().(*atomValue).Node }
+
+// Swap sets the value of a to f(value) and returns it. If the value was changed by another goroutine
+// in the meantime f is called again with the new value - f should be free of side effects.
+// The validator and watches of a are called in env - the env of the caller.
+func (a AtomNode) Swap(f func(Node) Node, env *Env) Node {
+	for {
+		old := a.value.Load().(*atomValue)
+		v := &atomValue{f(old.Node)}
+		a.validate(v.Node, env)
+		if a.value.CompareAndSwap(old, v) {
+			a.notify(old.Node, v.Node, env)
+			return v.Node
+		}
+	}
+}
+
+// Reset sets the value of a to v regardless of its current value - see Swap.
+func (a AtomNode) Reset(v Node, env *Env) Node {
+	a.validate(v, env)
+	old := a.value.Swap(&atomValue{v}).(*atomValue)
+	a.notify(old.Node, v, env)
+	return v
+}
+
+// SetValidator sets the fn that is called with each new value before it is set. Values it rejects are not set
+// and cause a panic instead. The current value must be valid.
+func (a AtomNode) SetValidator(f func(v Node, env *Env) bool, env *Env) {
+	a.mu.Lock()
+	a.validator = f
+	a.mu.Unlock()
+	a.validate(a.Deref(), env)
+}
+
+// AddWatch registers f to be called with the old and new value after each change - it replaces
+// the watch registered under the same key.
+func (a AtomNode) AddWatch(key Node, f func(key Node, a AtomNode, old, new Node, env *Env)) {
+	a.RemoveWatch(key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.watches = append(a.watches, atomWatch{key, f})
+}
+
+func (a AtomNode) RemoveWatch(key Node) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	watches := []atomWatch{}
+	for _, w := range a.watches {
+		if !w.key.Equal(key) {
+			watches = append(watches, w)
+		}
+	}
+	a.watches = watches
+}
+
+func (a AtomNode) validate(v Node, env *Env) {
+	a.mu.Lock()
+	validator := a.validator
+	a.mu.Unlock()
+	assert(validator == nil || validator(v, env), "invalid reference state: %s", v)
+}
+
+func (a AtomNode) notify(old, new Node, env *Env) {
+	a.mu.Lock()
+	watches := a.watches
+	a.mu.Unlock()
+	for _, w := range watches {
+		w.fn(w.key, a, old, new, env)
+	}
+}
+
+func (a AtomNode) Seq() []Node       { panic(errorf("seq on AtomNode %v", a)) }
+func (a AtomNode) Conj(_ Node) Node  { panic(errorf("conj on AtomNode %v", a)) }
+func (a AtomNode) Get(_ Node) Node   { panic(errorf("get on AtomNode %v", a)) }
+func (a AtomNode) String() string    { return "#<atom " + a.Deref().String() + ">" }
+func (a AtomNode) ToGo() Any         { return a }
+func (a AtomNode) Equal(x Node) bool { a2, ok := x.(AtomNode); return ok && a2.atom == a.atom }
+func (a AtomNode) Hash() uint32      { return hashString(fmt.Sprintf("atom:%p", a.atom)) }
@@ -0,0 +1,731 @@
+package gowen
+
+// Bytecode is the alternative to compiling forms to closures (see compile.go): forms are assembled into protos - an
+// instruction stream per level (the root of a form, a fn arity or a lazy-seq) with a constant pool and the slots of
+// its locals - that are evaluated by the vm in vm.go. Unlike closures protos are plain data: they can be disassembled
+// and serialized (see Module).
+// Bytecode shares the evaluation model of compiled closures: protos run with the locals of their level, calls in
+// tail position return a tailCall and fns created by them are ordinary closures. Loops and lets do not get levels of
+// their own - their bindings are slots of the enclosing level.
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+type op uint8
+
+const (
+	opConst     op = iota // push consts[arg]
+	opLocal               // push the local in slot arg
+	opOuter               // push the local in slot arg&0xffff of the level arg>>16 levels up
+	opGlobal              // push the value of globals[arg]
+	opStore               // pop into the local in slot arg
+	opBind                // pop and bind the value via binders[arg]
+	opPop                 // pop
+	opJump                // continue at arg
+	opJumpIfNot           // pop and continue at arg if the value is falsy
+	opCheck               // evaluate lates[arg] if the callee on top of the stack is a macro or special form
+	opCall                // call calls[arg]: pop the callee and args, push the result
+	opTailCall            // call calls[arg] in tail position: return the tail call
+	opReturn              // return the top of the stack
+	opRecur               // rebind the locals of recurs[arg] to the values on top of the stack and continue at its start
+	opFn                  // push a closure of fns[arg]
+	opLazySeq             // push a lazy seq of protos[arg]
+	opVector              // replace the top arg values with a vector of them
+	opSet                 // replace the top arg values with a set of them
+	opMap                 // replace the top arg values with a map of them (key value pairs)
+	opDef                 // pop and define the symbol consts[arg] (with its metadata) to the value - push nil
+	opTry                 // evaluate the body of tries[arg] up to opLeave - catching errors
+	opLeave               // return the top of the stack from the body of a try
+	opLate                // push the result of evaluating lates[arg]
+	opSite                // set the cursor to sites[arg]
+	opFail                // fail with fails[arg]
+)
+
+var opNames = [...]string{"const", "local", "outer", "global", "store", "bind", "pop", "jump", "jump-if-not", "check",
+	"call", "tail-call", "return", "recur", "fn", "lazy-seq", "vector", "set", "map", "def", "try", "leave", "late",
+	"site", "fail"}
+
+func (o op) String() string { return opNames[o] }
+
+// instr is an instruction: its op in the lowest 8 bits, its argument in the others.
+type instr uint32
+
+func newInstr(o op, arg int) instr {
+	assert(arg >= 0 && arg < 1<<24, "bytecode argument out of range: %d", arg)
+	return instr(arg<<8) | instr(o)
+}
+
+func (i instr) op() op   { return op(i & 0xff) }
+func (i instr) arg() int { return int(i >> 8) }
+
+// proto is the bytecode of a level. The tables hold the operands of instructions that do not fit into arg.
+type proto struct {
+	name    string // of the fn - for disassembly
+	level   *level
+	stack   int // the maximum depth of the operand stack
+	code    []instr
+	consts  []Node
+	globals []*global
+	sites   []*site
+	calls   []callInfo
+	lates   []*lateCall
+	binders []*binder
+	recurs  []recurInfo
+	tries   []tryInfo
+	fns     []*fnProto
+	protos  []*proto // of lazy seqs
+	fails   []failure
+	names   []string       // of the slots - for disassembly
+	arity   *compiledArity // set for fn arities - see opRecur
+	eval    code
+}
+
+type callInfo struct {
+	argc    int
+	site    *site
+	restore *site // the site of the form the call is an argument of - the cursor is reset to it afterwards
+}
+
+// lateCall is a call that is evaluated by eval - the call to a macro or special form only known at runtime
+// or to a special form the assembler does not know (e.g. ns). It is evaluated in an env that holds the locals.
+type lateCall struct {
+	form     ListNode
+	locals   []lateLocal
+	site     *site
+	call     int // the index of the call in calls - -1 for opLate
+	end      int // the pc after the call - see opCheck
+	topLevel bool
+}
+
+type lateLocal struct {
+	name        string
+	depth, slot int
+}
+
+type recurInfo struct {
+	count, pc, sp int   // recur continues at pc with the operand stack reset to sp
+	fn            bool  // whether recur rebinds the params of the arity of the proto - or binders (loops)
+	binders       []int // the indices of the binders of the bindings of a loop
+	variadic      bool  // set for fns with a variadic arity
+	site          *site
+}
+
+type tryInfo struct {
+	catch, end, slot int
+	site             *site
+}
+
+type failure struct {
+	err  Any
+	site *site
+}
+
+// fnProto is a fn form. Closures of it are created with a template for the env they are created in.
+type fnProto struct {
+	name     string
+	isMacro  bool
+	arities  []*compiledArity
+	template atomic.Pointer[fnTemplate] // the template of the last env - see instantiate
+}
+
+type assembler struct {
+	env      *Env
+	scope    lexScope
+	topLevel bool
+	p        *proto
+	sp       int // the depth of the operand stack at the current position
+	label    int // the last pc jumped to - see arg
+	recur    *recurInfo
+}
+
+// assemble returns the proto of n - the root level of n.
+func assemble(n Node, env *Env) *proto {
+	a := &assembler{env: env, scope: lexScope{level: &level{}}, topLevel: env.IsTopLevel()}
+	a.p = newProto("", a.scope.level)
+	a.compile(n, nil, true)
+	return a.p
+}
+
+func newProto(name string, lvl *level) *proto {
+	p := &proto{name: name, level: lvl}
+	p.eval = func(l *locals) (Node, *tailCall) { return p.exec(l, 0) }
+	return p
+}
+
+func (a *assembler) emit(o op, arg, delta int) int {
+	a.p.code = append(a.p.code, newInstr(o, arg))
+	a.sp += delta
+	a.p.stack = max(a.p.stack, a.sp)
+	return len(a.p.code) - 1
+}
+
+// patch sets the target of the jump at pc to the current position.
+func (a *assembler) patch(pc int) {
+	a.p.code[pc] = newInstr(a.p.code[pc].op(), len(a.p.code))
+	a.label = len(a.p.code)
+}
+
+func (a *assembler) site(n Node, path []Frame, isCall bool) *site {
+	s := &site{node: n, path: path, isCall: isCall}
+	a.p.sites = append(a.p.sites, s)
+	return s
+}
+
+func (a *assembler) constant(n Node) int {
+	a.p.consts = append(a.p.consts, n)
+	return len(a.p.consts) - 1
+}
+
+// compile emits the code of n - which leaves the value of n on the stack or, in tail position, returns it.
+func (a *assembler) compile(n Node, path []Frame, tail bool) {
+	switch n := n.(type) {
+	case LiteralNode, KeywordNode, AtomNode:
+		a.emit(opConst, a.constant(n), 1)
+	case SymbolNode:
+		a.compileSymbol(n)
+	case VectorNode:
+		a.compileCollection(n, n.Seq(), path, opVector)
+	case ArrayMapNode:
+		a.compileCollection(n, n.Nodes, path, opMap)
+	case MapNode:
+		kvs := []Node{}
+		n.each(func(k, v Node) { kvs = append(kvs, k, v) })
+		a.compileCollection(n, kvs, path, opMap)
+	case SetNode:
+		a.compileCollection(n, n.Seq(), path, opSet)
+	case LazySeqNode: // e.g. returned by a macro
+		a.compile(ListNode{Nodes: n.Seq()}, path, tail)
+		return
+	case ListNode:
+		if len(n.Nodes) != 0 {
+			a.compileList(n, path, tail)
+			return
+		}
+		a.emit(opConst, a.constant(n), 1)
+	default:
+		a.fail(errorf("cannot eval node %s", n), a.site(n, path, false))
+	}
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+// arg emits the code of n in non-tail position at s - the cursor is reset to s afterwards.
+func (a *assembler) arg(n Node, s *site) {
+	var path []Frame
+	if s != nil {
+		path = s.path
+	}
+	a.compile(n, path, false)
+	switch n.(type) {
+	case LiteralNode, KeywordNode, AtomNode, SymbolNode:
+		return
+	}
+	if pc := len(a.p.code) - 1; a.p.code[pc].op() == opCall && a.label != len(a.p.code) {
+		a.p.calls[a.p.code[pc].arg()].restore = s // the call is the only way out of n
+	} else {
+		a.emit(opSite, a.siteIndex(s), 0)
+	}
+}
+
+// siteIndex returns the index of s in the sites of the proto - nil is added as a site as well.
+func (a *assembler) siteIndex(s *site) int {
+	for i := len(a.p.sites) - 1; i >= 0; i-- { // sites are usually referenced right after they were created
+		if a.p.sites[i] == s {
+			return i
+		}
+	}
+	a.p.sites = append(a.p.sites, s)
+	return len(a.p.sites) - 1
+}
+
+// body emits the code of the forms ns: all but the last one are evaluated for side effects.
+func (a *assembler) body(ns []Node, s *site, path []Frame, tail bool) {
+	if len(ns) == 0 {
+		a.compile(LiteralNode{nil}, path, tail)
+		return
+	}
+	for _, n := range ns[:len(ns)-1] {
+		a.arg(n, s)
+		a.emit(opPop, 0, -1)
+	}
+	a.compile(ns[len(ns)-1], path, tail)
+}
+
+func (a *assembler) compileSymbol(sn SymbolNode) {
+	if len(sn.Value) > 1 && sn.Value[0] == '.' {
+		a.emit(opConst, a.constant(LiteralNode{sn}), 1)
+	} else if depth, slot, ok := a.scope.resolve(sn.Value); !ok {
+		a.emit(opGlobal, a.global(sn), 1)
+	} else if depth == 0 {
+		a.emit(opLocal, slot, 1)
+	} else {
+		assert(depth < 1<<8 && slot < 1<<16, "too many nested levels or locals: %s", sn)
+		a.emit(opOuter, depth<<16|slot, 1)
+	}
+}
+
+func (a *assembler) global(sn SymbolNode) int {
+	for i, g := range a.p.globals {
+		if g.symbol.Value == sn.Value {
+			return i
+		}
+	}
+	a.p.globals = append(a.p.globals, &global{symbol: sn})
+	return len(a.p.globals) - 1
+}
+
+func (a *assembler) compileCollection(n Node, ns []Node, path []Frame, o op) {
+	s := a.site(n, path, false)
+	a.emit(opSite, a.siteIndex(s), 0)
+	for _, n := range ns {
+		a.arg(n, s)
+	}
+	a.emit(o, len(ns), 1-len(ns))
+}
+
+// compileList emits calls. Like compileList of the closure compiler it expands macros, compiles special forms
+// and turns compile errors into code that fails.
+func (a *assembler) compileList(n ListNode, path []Frame, tail bool) {
+	errPath, saved, mark := path, *a, len(a.p.code)
+	defer func() {
+		if err := recover(); err != nil {
+			if IsAborted(asError(err)) {
+				panic(err)
+			}
+			*a = saved
+			a.p.code = a.p.code[:mark]
+			a.fail(err, a.site(n, errPath, false))
+			if tail {
+				a.emit(opReturn, 0, -1)
+			}
+		}
+	}()
+	sn, isSymbol := n.Nodes[0].(SymbolNode)
+	if _, _, isLocal := a.scope.resolve(sn.Value); !isSymbol || isLocal {
+		a.compileCall(n, path, tail)
+		return
+	}
+	vn, _ := a.env.Get(sn.Value)
+	ln, _ := vn.(LiteralNode)
+	switch f := ln.Value.(type) {
+	case SpecialFn:
+		errPath = pathWith(path, newFrame(n, f))
+		if compile, ok := bytecodeForm(f); ok {
+			compile(a, n, errPath, tail)
+		} else {
+			a.emit(opLate, a.late(n, a.site(n, path, false), -1), 1)
+			if tail {
+				a.emit(opReturn, 0, -1)
+			}
+		}
+		return
+	case MacroFn:
+		errPath = pathWith(path, newFrame(n, f))
+		a.compile(f(n.Nodes[1:], a.env), errPath, tail)
+		return
+	}
+	a.compileCall(n, path, tail)
+}
+
+// bytecodeForm returns the assembler of the special form f - see specialForm.
+func bytecodeForm(f SpecialFn) (func(*assembler, ListNode, []Frame, bool), bool) {
+	switch fnPointer(f) {
+	case fnPointer(fi):
+		return (*assembler).compileIf, true
+	case fnPointer(def):
+		return (*assembler).compileDef, true
+	case fnPointer(newFn), fnPointer(newMacro):
+		return (*assembler).compileFn, true
+	case fnPointer(try):
+		return (*assembler).compileTry, true
+	case fnPointer(quote):
+		return (*assembler).compileQuote, true
+	case fnPointer(do):
+		return (*assembler).compileDo, true
+	case fnPointer(let):
+		return (*assembler).compileLet, true
+	case fnPointer(loop):
+		return (*assembler).compileLoop, true
+	case fnPointer(recur):
+		return (*assembler).compileRecur, true
+	case fnPointer(newLazySeq):
+		return (*assembler).compileLazySeq, true
+	}
+	return nil, false
+}
+
+func (a *assembler) compileCall(n ListNode, path []Frame, tail bool) {
+	argsSite, callSite := a.site(n, path, false), a.site(n, path, true)
+	a.arg(n.Nodes[0], argsSite)
+	call := len(a.p.calls)
+	a.p.calls = append(a.p.calls, callInfo{argc: len(n.Nodes) - 1, site: callSite})
+	late := a.late(n, argsSite, call)
+	a.emit(opCheck, late, 0)
+	for _, arg := range n.Nodes[1:] {
+		a.arg(arg, argsSite)
+	}
+	if tail {
+		a.emit(opTailCall, call, -len(n.Nodes)+1)
+		a.p.lates[late].end = len(a.p.code)
+		a.emit(opReturn, 0, -1)
+	} else {
+		a.emit(opCall, call, -len(n.Nodes)+1)
+		a.p.lates[late].end = len(a.p.code)
+	}
+}
+
+// late returns the index of a lateCall of n - with the locals that are visible at the current position.
+func (a *assembler) late(n ListNode, s *site, call int) int {
+	root := a.topLevel && a.scope.level.outer.level == nil
+	c, seen := &lateCall{form: n, site: s, call: call, topLevel: root}, map[string]bool{}
+	for s, depth := a.scope, 0; s.level != nil; s, depth = s.level.outer, depth+1 {
+		for b := s.bindings; b != nil; b = b.next {
+			if !seen[b.name] {
+				seen[b.name] = true
+				c.locals = append(c.locals, lateLocal{b.name, depth, b.slot})
+			}
+		}
+	}
+	a.p.lates = append(a.p.lates, c)
+	return len(a.p.lates) - 1
+}
+
+func (a *assembler) fail(err Any, s *site) {
+	a.p.fails = append(a.p.fails, failure{err, s})
+	a.siteIndex(s)
+	a.emit(opFail, len(a.p.fails)-1, 1)
+}
+
+func (a *assembler) compileIf(n ListNode, path []Frame, tail bool) {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 2, "wrong number of arguments for if")
+	defer a.enter()()
+	a.arg(nodes[0], a.site(n, path, false))
+	jumpIfNot := a.emit(opJumpIfNot, 0, -1)
+	a.compile(nodes[1], path, tail)
+	jump := -1
+	if !tail {
+		jump = a.emit(opJump, 0, 0)
+		a.sp--
+	}
+	a.patch(jumpIfNot)
+	if len(nodes) == 3 {
+		a.compile(nodes[2], path, tail)
+	} else {
+		a.compile(LiteralNode{nil}, path, tail)
+	}
+	if jump >= 0 {
+		a.patch(jump)
+	}
+}
+
+func (a *assembler) compileDef(n ListNode, path []Frame, tail bool) {
+	assert(a.topLevel, "def must only be called from top level")
+	sn, value, meta := parseDef(n)
+	s := a.site(n, path, false)
+	a.emit(opSite, a.siteIndex(s), 0)
+	a.arg(value, s)
+	a.emit(opSite, a.siteIndex(s), 0)
+	a.emit(opDef, a.constant(withMeta(sn, meta)), 0)
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+// compileFn assembles each arity of a fn (or macro) form as a proto of its own.
+func (a *assembler) compileFn(n ListNode, path []Frame, tail bool) {
+	name, arities := parseFn(n.Nodes[1:])
+	for _, ar := range arities {
+		checkRecur(ar.body, a.env)
+	}
+	a.scope.markCaptures()
+	defer a.enter()()
+	f, outer := &fnProto{name: name, isMacro: callTo(n) == "macro"}, a.scope
+	for _, ar := range arities {
+		a.scope = lexScope{level: &level{outer: outer}}
+		a.scope.declare(name)
+		ca := &compiledArity{arity: ar}
+		ca.params, ca.bind = a.scope.declareParams(ar)
+		p := a.nested(name, func() {
+			a.recur = &recurInfo{count: ar.required, fn: true, variadic: ar.variadic}
+			if ar.variadic {
+				a.recur.count++
+			}
+			a.body(ar.body, nil, nil, true)
+		})
+		ca.level, ca.code, ca.proto, p.arity = p.level, p.eval, p, ca
+		f.arities = append(f.arities, ca)
+	}
+	a.scope = outer
+	a.p.fns = append(a.p.fns, f)
+	a.emit(opFn, len(a.p.fns)-1, 1)
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+// nested assembles the proto of the level of the current scope by calling body.
+func (a *assembler) nested(name string, body func()) *proto {
+	p, sp, label, recur := a.p, a.sp, a.label, a.recur
+	a.p, a.sp, a.label, a.recur = newProto(name, a.scope.level), 0, 0, nil
+	a.declared()
+	body()
+	nested := a.p
+	a.p, a.sp, a.label, a.recur = p, sp, label, recur
+	return nested
+}
+
+// declared records the names of the slots declared in the current scope - for disassembly.
+func (a *assembler) declared() {
+	for b := a.scope.bindings; b != nil; b = b.next {
+		for len(a.p.names) <= b.slot {
+			a.p.names = append(a.p.names, "")
+		}
+		if a.p.names[b.slot] == "" {
+			a.p.names[b.slot] = b.name
+		}
+	}
+}
+
+// args emits the code of the forms ns as args at s: all but the last one are evaluated for side effects.
+func (a *assembler) args(ns []Node, s *site) {
+	if len(ns) == 0 {
+		a.compile(LiteralNode{nil}, nil, false)
+	}
+	for i, n := range ns {
+		if a.arg(n, s); i != len(ns)-1 {
+			a.emit(opPop, 0, -1)
+		}
+	}
+}
+
+func (a *assembler) compileTry(n ListNode, path []Frame, tail bool) {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 1, "wrong number of arguments for try")
+	catch, ok := nodes[len(nodes)-1].(ListNode)
+	assert(ok && callTo(catch) == "catch", "last form of try must be a catch clause")
+	assert(len(catch.Nodes) >= 2, "invalid catch clause (inside try)")
+	sn, ok := catch.Nodes[1].(SymbolNode)
+	assert(ok, "catch clause must have symbol as first element")
+	defer a.enter()()
+	s, outer := a.site(n, path, false), a.scope
+	a.emit(opSite, a.siteIndex(s), 0)
+	t := a.emit(opTry, len(a.p.tries), 0)
+	a.p.tries = append(a.p.tries, tryInfo{site: s})
+	sp := a.sp
+	a.sp = 0 // the body is evaluated with an operand stack of its own
+	a.args(nodes[:len(nodes)-1], s)
+	a.emit(opLeave, 0, -1)
+	a.sp = sp
+	info := &a.p.tries[a.p.code[t].arg()]
+	info.catch, info.slot = len(a.p.code), a.scope.declare(sn.Value)
+	a.declared()
+	a.args(catch.Nodes[2:], s)
+	a.p.tries[a.p.code[t].arg()].end = len(a.p.code)
+	a.label = len(a.p.code)
+	a.scope = outer
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+func (a *assembler) compileQuote(n ListNode, path []Frame, tail bool) {
+	assert(len(n.Nodes) == 2, "wrong number of arguments for quote")
+	a.emit(opConst, a.constant(withoutPosition(n.Nodes[1])), 1)
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+func (a *assembler) compileDo(n ListNode, path []Frame, tail bool) {
+	s := a.site(n, path, false)
+	if len(n.Nodes) > 2 {
+		a.emit(opSite, a.siteIndex(s), 0)
+	}
+	a.body(n.Nodes[1:], s, path, tail)
+}
+
+func (a *assembler) compileLet(n ListNode, path []Frame, tail bool) {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 1, "wrong number of arguments for let")
+	bindings, ok := nodes[0].(VectorNode)
+	assert(ok && bindings.Count()%2 == 0, "let requires a vector with an even number of bindings")
+	defer a.enter()()
+	outer, s := a.scope, a.site(n, path, false)
+	a.emit(opSite, a.siteIndex(s), 0)
+	for bs := bindings.Seq(); len(bs) != 0; bs = bs[2:] {
+		a.arg(bs[1], s)
+		a.bind(a.scope.binder(bs[0]))
+	}
+	a.body(nodes[1:], s, path, tail)
+	a.scope = outer
+}
+
+// bind emits the code that pops a value and binds it via b - and returns the index of b in the binders.
+func (a *assembler) bind(b *binder) int {
+	a.declared()
+	a.p.binders = append(a.p.binders, b)
+	switch {
+	case b.pattern != nil:
+		a.emit(opBind, len(a.p.binders)-1, -1)
+	case b.slot >= 0:
+		a.emit(opStore, b.slot, -1)
+	default:
+		a.emit(opPop, 0, -1)
+	}
+	return len(a.p.binders) - 1
+}
+
+// compileLoop binds the bindings of the loop to slots of the current level. recur in its body rebinds them
+// and continues at the start of the body.
+func (a *assembler) compileLoop(n ListNode, path []Frame, tail bool) {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 1, "wrong number of arguments for loop")
+	bindings, ok := nodes[0].(VectorNode)
+	assert(ok && bindings.Count()%2 == 0, "loop requires a vector with an even number of bindings")
+	checkRecur(nodes[1:], a.env)
+	defer a.enter()()
+	outer, recur, s := a.scope, a.recur, a.site(n, path, false)
+	a.emit(opSite, a.siteIndex(s), 0)
+	binders := []int{}
+	for bs := bindings.Seq(); len(bs) != 0; bs = bs[2:] {
+		a.arg(bs[1], s)
+		binders = append(binders, a.bind(a.scope.binder(bs[0])))
+	}
+	a.recur = &recurInfo{count: len(binders), pc: len(a.p.code), sp: a.sp, binders: binders}
+	a.label = len(a.p.code)
+	a.body(nodes[1:], s, path, tail)
+	a.scope, a.recur = outer, recur
+}
+
+func (a *assembler) compileRecur(n ListNode, path []Frame, tail bool) {
+	target := a.recur
+	assert(target != nil, "recur must be called from inside loop or fn")
+	assert(len(n.Nodes)-1 == target.count, "wrong number of arguments for recur: expected %d, got %d", target.count, len(n.Nodes)-1)
+	s := a.site(n, path, false)
+	a.emit(opSite, a.siteIndex(s), 0)
+	for _, arg := range n.Nodes[1:] {
+		a.arg(arg, s)
+	}
+	info := *target
+	info.site = s
+	a.p.recurs = append(a.p.recurs, info)
+	a.emit(opRecur, len(a.p.recurs)-1, 1-target.count)
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+func (a *assembler) compileLazySeq(n ListNode, path []Frame, tail bool) {
+	a.scope.markCaptures()
+	defer a.enter()()
+	outer := a.scope
+	a.scope = lexScope{level: &level{outer: outer}}
+	p := a.nested("lazy-seq", func() { a.body(n.Nodes[1:], nil, nil, true) })
+	a.scope = outer
+	a.p.protos = append(a.p.protos, p)
+	a.emit(opLazySeq, len(a.p.protos)-1, 1)
+	if tail {
+		a.emit(opReturn, 0, -1)
+	}
+}
+
+func (a *assembler) enter() func() {
+	topLevel := a.topLevel
+	a.topLevel = false
+	return func() { a.topLevel = topLevel }
+}
+
+// disassemble returns a listing of the instructions of p and the protos nested in it.
+func (p *proto) disassemble() string {
+	w := &strings.Builder{}
+	p.disassembleTo(w, "")
+	return w.String()
+}
+
+func (p *proto) disassembleTo(w *strings.Builder, indent string) {
+	fmt.Fprintf(w, "%s%s (slots %d, stack %d)\n", indent, p.header(), p.level.size, p.stack)
+	for pc, in := range p.code {
+		fmt.Fprintf(w, "%s%4d  %-11s %4d", indent, pc, in.op(), in.arg())
+		if comment := p.comment(in); comment != "" {
+			fmt.Fprintf(w, "  ; %s", comment)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, f := range p.fns {
+		for _, a := range f.arities {
+			a.proto.disassembleTo(w, indent+"  ")
+		}
+	}
+	for _, p := range p.protos {
+		p.disassembleTo(w, indent+"  ")
+	}
+}
+
+func (p *proto) header() string {
+	switch {
+	case p.arity != nil:
+		return fmt.Sprintf("fn %s %s", p.name, p.arity.arity.params)
+	case p.name != "":
+		return p.name
+	default:
+		return "form"
+	}
+}
+
+func (p *proto) comment(in instr) string {
+	switch arg := in.arg(); in.op() {
+	case opConst, opDef:
+		return p.consts[arg].String()
+	case opLocal, opStore:
+		if arg < len(p.names) {
+			return p.names[arg]
+		}
+	case opOuter:
+		return fmt.Sprintf("depth %d slot %d", arg>>16, arg&0xffff)
+	case opGlobal:
+		return p.globals[arg].symbol.Value
+	case opBind:
+		if b := p.binders[arg]; b.pattern != nil {
+			return b.pattern.String()
+		}
+	case opCall, opTailCall:
+		return fmt.Sprintf("%d args", p.calls[arg].argc)
+	case opCheck, opLate:
+		return p.lates[arg].form.String()
+	case opRecur:
+		return fmt.Sprintf("%d values -> %d", p.recurs[arg].count, p.recurs[arg].pc)
+	case opFn:
+		return p.fns[arg].name
+	case opTry:
+		return fmt.Sprintf("catch %d", p.tries[arg].catch)
+	case opFail:
+		return fmt.Sprint(p.fails[arg].err)
+	}
+	return ""
+}
+
+// disassemble returns the bytecode of the fn f. Fns compiled to closures are assembled for the listing - locals
+// of enclosing fns are listed as globals.
+func disassemble(f Node, env *Env) string {
+	fln, _ := f.(LiteralNode)
+	cl, ok := fln.Value.(Closure)
+	assert(ok && cl.fn != nil, "cannot disassemble %s", f)
+	w := &strings.Builder{}
+	for _, ca := range cl.fn.arities {
+		p := ca.proto
+		if p == nil {
+			a := &assembler{env: env, scope: lexScope{level: &level{}}}
+			a.scope.declare(cl.fn.name)
+			a.scope.declareParams(ca.arity)
+			p = a.nested(cl.fn.name, func() { a.body(ca.body, nil, nil, true) })
+			p.arity = ca
+		}
+		p.disassembleTo(w, "")
+	}
+	return w.String()
+}
@@ -13,11 +13,18 @@ import (
 func main() {
 	log.SetFlags(0) // do not prefix log with timestamp
 
-	var in string
+	var in, loadPath, out string
 	flag.StringVar(&in, "eval", "", "Evaluate the input")
 	flag.StringVar(&in, "e", "", "Evaluate the input")
+	flag.StringVar(&loadPath, "path", ".", "Load path for namespaces (list separated by os.PathListSeparator)")
+	flag.StringVar(&out, "compile", "", "Compile the .gow files to a module file (.gowc) instead of evaluating them")
 	flag.Parse()
+	gowen.DefaultRuntime.LoadPath = filepath.SplitList(loadPath)
 	env := gowen.NewEnv(false)
+	if out != "" {
+		compileFiles(flag.Args(), out, env)
+		return
+	}
 	evalFiles(flag.Args(), env)
 
 	if in != "" {
@@ -27,7 +34,7 @@ func main() {
 		}
 		results, err := gowen.EvalMultiple(nodes, env)
 		if err != nil {
-			log.Fatal("ERROR:", err)
+			log.Fatal("ERROR:", err, "\n", stackTrace(err))
 		}
 
 		if len(results) != 0 {
@@ -38,20 +45,90 @@ func main() {
 	repl()
 }
 
+// evalFiles evaluates the files in the order they are passed. The forms of consecutive .gow files are sorted
+// topologically (see gowen.EvalTopological) - modules (.gowc) and files that start with an ns form are not.
 func evalFiles(paths []string, env *gowen.Env) {
-	input := ""
+	nodes := []gowen.Node{}
 	for _, path := range paths {
-		if filepath.Ext(path) == ".gow" {
+		if filepath.Ext(path) == ".gowc" {
+			evalTopological(nodes, env)
+			nodes = nil
+			evalModule(path, env)
+		} else if filepath.Ext(path) == ".gow" {
 			b, err := ioutil.ReadFile(path)
 			if err != nil {
 				panic(err)
 			}
-			input += string(b)
+			fileNodes, err := gowen.ParseFile(path, string(b))
+			if err != nil {
+				log.Fatal("ERROR:", err)
+			}
+			if len(fileNodes) != 0 && isNsForm(fileNodes[0]) {
+				evalTopological(fileNodes, gowen.NewEnv(false))
+				continue
+			}
+			nodes = append(nodes, fileNodes...)
+		}
+	}
+	evalTopological(nodes, env)
+}
+
+func evalTopological(nodes []gowen.Node, env *gowen.Env) {
+	if _, err := gowen.EvalTopological(nodes, env); err != nil {
+		log.Fatal("ERROR:", err, "\n", stackTrace(err))
+	}
+}
+
+func evalModule(path string, env *gowen.Env) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	m := &gowen.Module{}
+	if err := m.UnmarshalBinary(b); err != nil {
+		log.Fatal("ERROR:", err)
+	}
+	if _, err := m.Eval(env); err != nil {
+		log.Fatal("ERROR:", err, "\n", stackTrace(err))
+	}
+}
+
+// compileFiles compiles the forms of the .gow files (in the order they are passed) to the module file out.
+func compileFiles(paths []string, out string, env *gowen.Env) {
+	nodes := []gowen.Node{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			panic(err)
 		}
+		fileNodes, err := gowen.ParseFile(path, string(b))
+		if err != nil {
+			log.Fatal("ERROR:", err)
+		}
+		nodes = append(nodes, fileNodes...)
 	}
-	nodes, err := gowen.Parse(input)
+	m, err := gowen.CompileModule(nodes, env)
 	if err != nil {
+		log.Fatal("ERROR:", err, "\n", stackTrace(err))
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		log.Fatal("ERROR:", err)
+	}
+	if err := ioutil.WriteFile(out, b, 0644); err != nil {
 		log.Fatal("ERROR:", err)
 	}
-	gowen.EvalTopological(nodes, env)
+}
+
+// Files that declare a namespace are evaluated in their own env - all other files share env.
+func isNsForm(n gowen.Node) bool {
+	ln, ok := n.(gowen.ListNode)
+	return ok && len(ln.Nodes) != 0 && ln.Nodes[0].String() == "ns"
+}
+
+func stackTrace(err error) string {
+	if gerr, ok := err.(gowen.Error); ok {
+		return gerr.StackTrace()
+	}
+	return ""
 }
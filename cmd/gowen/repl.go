@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/niklasfasching/gowen"
@@ -22,28 +23,24 @@ func repl() {
 		f.Close()
 	}
 
-	env := gowen.NewEnv(true)
-	for in := ""; true; {
-		if in == "" {
-			fmt.Printf("> ")
-		}
-		if line, err := l.Prompt(""); err == nil {
-			in += line
-		} else if err == liner.ErrPromptAborted {
-			in = ""
+	env, lines := gowen.NewEnv(true), &lineReader{liner: l}
+	for reader := gowen.NewReader("", lines); ; {
+		lines.newForm = true
+		node, err := reader.Read()
+		if err == liner.ErrPromptAborted {
+			reader = gowen.NewReader("", lines) // drop the input of the aborted form
+			continue
 		} else if err == io.EOF {
 			log.Print("Exit")
 			break
-		} else {
+		} else if _, ok := err.(gowen.Error); ok {
+			fmt.Printf("ERROR: %s\n", err)
+			continue
+		} else if err != nil {
 			log.Print("Error reading line", err)
 			break
 		}
-
-		if isReady(in) {
-			l.AppendHistory(in)
-			evalPrint(in, env)
-			in = ""
-		}
+		evalPrint(node, env)
 	}
 
 	if f, err := os.Create(historyFile); err != nil {
@@ -54,46 +51,41 @@ func repl() {
 	}
 }
 
-func evalPrint(expression string, env *gowen.Env) {
-	results := make(chan gowen.Node)
-	errors := make(chan error)
-	go func() {
-		node, err := gowen.ParseAndEval(expression, env)
-		if err != nil {
-			errors <- err
-			return
-		}
-		results <- node
-	}()
-	select {
-	case err := <-errors:
-		fmt.Printf("ERROR: %s\n", err)
-	case node := <-results:
-		fmt.Printf("%v\n", node)
-	case <-time.After(3 * time.Second):
-		env.Interrupt()
-		err := <-errors
-		fmt.Printf("ERROR: Timeout evaling %s - %s\n", expression, err)
+func evalPrint(node gowen.Node, env *gowen.Env) {
+	// the context is only cancelled if the form times out - go blocks and callbacks it created keep running
+	ctx, cancel := context.WithCancel(context.Background())
+	timeout := time.AfterFunc(3*time.Second, cancel)
+	result, err := gowen.EvalContext(ctx, node, env)
+	timeout.Stop()
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("ERROR: Timeout evaling %s - %s\n", node, err)
+	} else if err != nil {
+		fmt.Printf("ERROR: %s\n%s", err, stackTrace(err))
+	} else {
+		fmt.Printf("%v\n", result)
 	}
 }
 
-func isReady(expression string) bool {
-	parens, squares, braces := 0, 0, 0
-	for _, c := range expression {
-		switch c {
-		case '(':
-			parens++
-		case ')':
-			parens--
-		case '[':
-			squares++
-		case ']':
-			squares--
-		case '{':
-			braces++
-		case '}':
-			braces--
+// lineReader reads the lines entered in the REPL - the prompt is only shown for the first line of a form.
+type lineReader struct {
+	liner   *liner.State
+	newForm bool
+	line    []byte // the rest of the last line
+}
+
+func (r *lineReader) Read(bs []byte) (int, error) {
+	if len(r.line) == 0 {
+		if r.newForm {
+			fmt.Printf("> ")
+		}
+		line, err := r.liner.Prompt("")
+		if err != nil {
+			return 0, err
 		}
+		r.liner.AppendHistory(line)
+		r.line, r.newForm = []byte(line+"\n"), false
 	}
-	return parens == 0 && squares == 0 && braces == 0 && strings.TrimSpace(expression) != ""
+	n := copy(bs, r.line)
+	r.line = r.line[n:]
+	return n, nil
 }
@@ -0,0 +1,776 @@
+package gowen
+
+// Forms are compiled into Go closures (code) before they are evaluated: Macros are expanded, special forms are
+// turned into plain control flow and local symbols are resolved to slots in the locals of the fn (or loop) that
+// binds them. Globals are looked up when the code runs - forms can refer to globals that are only defined later -
+// and cached until the next definition in the runtime.
+// Errors found while compiling (e.g. recur outside of tail position) are reported when the form that contains
+// them is evaluated - just like they would be by an interpreter.
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// code is a compiled form. It returns the value of the form - or the call the form ends in if that is a call to
+// a closure (or recur). Such tail calls are evaluated by run (or the enclosing loop) instead - see eval.go.
+type code func(l *locals) (Node, *tailCall)
+
+type compiler struct {
+	env      *Env
+	scope    lexScope
+	recur    *recurTarget // target of recur - set while compiling the body of a loop or fn
+	topLevel bool         // whether def is allowed - not inside of fn, let, if & co
+}
+
+// lexScope is the lexical scope at a position in a form. Scopes are immutable - snapshots are taken for
+// call sites whose callee is only known at runtime (see compileLate).
+type lexScope struct {
+	level    *level
+	bindings *binding // locals of level visible at this position - innermost first
+}
+
+type binding struct {
+	name string
+	slot int
+	next *binding
+}
+
+// level is a fn, loop, lazy-seq (or the root of a form) - each evaluation of it gets its own locals.
+type level struct {
+	outer    lexScope
+	size     int
+	captures atomic.Bool // whether closures created inside refer to the locals - see recurTarget
+}
+
+// recurTarget is the loop or fn arity recur rebinds. If closures are created in it, each iteration gets
+// fresh locals so that the closures of previous iterations keep seeing their bindings.
+type recurTarget struct {
+	level *level
+	count int
+	arity *compiledArity // set for fns
+	binds []*binder      // set for loops
+}
+
+// specialForm returns the compiler of the special form f. ok is false for special forms that are not part
+// of the compiler (e.g. ns) - they are called with an env instead, see compileLate.
+func specialForm(f SpecialFn) (compile func(*compiler, ListNode, []Frame) code, ok bool) {
+	switch fnPointer(f) {
+	case fnPointer(fi):
+		return (*compiler).compileIf, true
+	case fnPointer(def):
+		return (*compiler).compileDef, true
+	case fnPointer(newFn), fnPointer(newMacro):
+		return (*compiler).compileFn, true
+	case fnPointer(try):
+		return (*compiler).compileTry, true
+	case fnPointer(quote):
+		return (*compiler).compileQuote, true
+	case fnPointer(do):
+		return (*compiler).compileDo, true
+	case fnPointer(let):
+		return (*compiler).compileLet, true
+	case fnPointer(loop):
+		return (*compiler).compileLoop, true
+	case fnPointer(recur):
+		return (*compiler).compileRecur, true
+	case fnPointer(newLazySeq):
+		return (*compiler).compileLazySeq, true
+	}
+	return nil, false
+}
+
+// The fns of the special forms evaluate their form - they are only called when a special form is called
+// indirectly (e.g. from Go). Calls by name are compiled.
+func fi(ns []Node, env *Env) (Node, *Env, bool)         { return evalSpecial("if", ns, env) }
+func def(ns []Node, env *Env) (Node, *Env, bool)        { return evalSpecial("def", ns, env) }
+func newFn(ns []Node, env *Env) (Node, *Env, bool)      { return evalSpecial("fn", ns, env) }
+func newMacro(ns []Node, env *Env) (Node, *Env, bool)   { return evalSpecial("macro", ns, env) }
+func try(ns []Node, env *Env) (Node, *Env, bool)        { return evalSpecial("try", ns, env) }
+func quote(ns []Node, env *Env) (Node, *Env, bool)      { return evalSpecial("quote", ns, env) }
+func do(ns []Node, env *Env) (Node, *Env, bool)         { return evalSpecial("do", ns, env) }
+func let(ns []Node, env *Env) (Node, *Env, bool)        { return evalSpecial("let", ns, env) }
+func loop(ns []Node, env *Env) (Node, *Env, bool)       { return evalSpecial("loop", ns, env) }
+func recur(ns []Node, env *Env) (Node, *Env, bool)      { return evalSpecial("recur", ns, env) }
+func newLazySeq(ns []Node, env *Env) (Node, *Env, bool) { return evalSpecial("lazy-seq", ns, env) }
+
+func evalSpecial(name string, ns []Node, env *Env) (Node, *Env, bool) {
+	return eval(wrapInCall(name, ns), env), env, true
+}
+
+func fnPointer(f Any) uintptr { return reflect.ValueOf(f).Pointer() }
+
+func newCompiler(env *Env) *compiler {
+	return &compiler{env: env, scope: lexScope{level: &level{}}, topLevel: env.IsTopLevel()}
+}
+
+// compile returns the code of n. path holds the frames of the forms n is nested in (in tail position) -
+// they are reported for errors in n.
+func (c *compiler) compile(n Node, path []Frame) code {
+	switch n := n.(type) {
+	case LiteralNode, KeywordNode, AtomNode:
+		return constant(n)
+	case SymbolNode:
+		return c.compileSymbol(n)
+	case VectorNode:
+		return c.compileCollection(n, n.Seq(), path, func(ns []Node) Node { return NewVector(ns...) })
+	case ArrayMapNode:
+		return c.compileCollection(n, n.Nodes, path, func(ns []Node) Node { return NewMap(ns...) })
+	case MapNode:
+		kvs := []Node{}
+		n.each(func(k, v Node) { kvs = append(kvs, k, v) })
+		return c.compileCollection(n, kvs, path, func(ns []Node) Node {
+			m := MapNode{}
+			for i := 0; i < len(ns); i += 2 {
+				m = m.Assoc(ns[i], ns[i+1])
+			}
+			return m
+		})
+	case SetNode:
+		return c.compileCollection(n, n.Seq(), path, func(ns []Node) Node { return NewSet(ns...) })
+	case LazySeqNode: // e.g. returned by a macro
+		return c.compile(ListNode{Nodes: n.Seq()}, path)
+	case ListNode:
+		if len(n.Nodes) == 0 {
+			return constant(n)
+		}
+		return c.compileList(n, path)
+	default:
+		return failed(errorf("cannot eval node %s", n), &site{node: n, path: path})
+	}
+}
+
+// compileArg returns the code of n evaluated in non-tail position at s: n is compiled with the path of s and
+// tail calls it returns are evaluated by a separate run. The cursor is reset to s afterwards.
+func (c *compiler) compileArg(n Node, s *site) code {
+	switch n.(type) {
+	case LiteralNode, KeywordNode, AtomNode, SymbolNode:
+		return c.compile(n, nil)
+	}
+	var path []Frame
+	if s != nil {
+		path = s.path
+	}
+	body := c.compile(n, path)
+	return func(l *locals) (Node, *tailCall) {
+		v, tc := body(l)
+		if tc != nil {
+			l.site = s
+			v = resume(nil, tc, l)
+		}
+		l.site = s
+		return v, nil
+	}
+}
+
+func (c *compiler) compileArgs(ns []Node, s *site) []code {
+	codes := make([]code, len(ns))
+	for i, n := range ns {
+		codes[i] = c.compileArg(n, s)
+	}
+	return codes
+}
+
+// compileBody returns the code of a body: all but the last form are evaluated for side effects.
+// Errors in them are reported at s (if it is not nil).
+func (c *compiler) compileBody(ns []Node, s *site, path []Frame) code {
+	if len(ns) == 0 {
+		return constant(LiteralNode{nil})
+	}
+	init, last := c.compileArgs(ns[:len(ns)-1], s), c.compile(ns[len(ns)-1], path)
+	if len(init) == 0 {
+		return last
+	}
+	return func(l *locals) (Node, *tailCall) {
+		if s != nil {
+			l.site = s
+		}
+		for _, c := range init {
+			c(l)
+		}
+		return last(l)
+	}
+}
+
+func (c *compiler) compileSymbol(sn SymbolNode) code {
+	if len(sn.Value) > 1 && sn.Value[0] == '.' {
+		return constant(LiteralNode{sn})
+	}
+	if depth, slot, ok := c.scope.resolve(sn.Value); ok {
+		return localCode(depth, slot)
+	}
+	g := &global{symbol: sn, env: c.env}
+	return g.get
+}
+
+func (c *compiler) compileCollection(n Node, ns []Node, path []Frame, build func([]Node) Node) code {
+	s := &site{node: n, path: path}
+	elements := c.compileArgs(ns, s)
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		values := make([]Node, len(elements))
+		for i, e := range elements {
+			values[i], _ = e(l)
+		}
+		n := build(values)
+		l.env.state.alloc(n)
+		return n, nil
+	}
+}
+
+// compileList compiles calls. Special forms are compiled by their compiler and macros are expanded -
+// unless the callee is a local or is not defined yet. Compile errors are returned as code that fails.
+func (c *compiler) compileList(n ListNode, path []Frame) (result code) {
+	errPath, scope, recur, topLevel := path, c.scope, c.recur, c.topLevel
+	defer func() {
+		if err := recover(); err != nil {
+			if IsAborted(asError(err)) {
+				panic(err)
+			}
+			c.scope, c.recur, c.topLevel = scope, recur, topLevel
+			result = failed(err, &site{node: n, path: errPath})
+		}
+	}()
+	sn, isSymbol := n.Nodes[0].(SymbolNode)
+	if _, _, isLocal := c.scope.resolve(sn.Value); !isSymbol || isLocal {
+		return c.compileCall(n, path)
+	}
+	vn, _ := c.env.Get(sn.Value)
+	ln, _ := vn.(LiteralNode)
+	switch f := ln.Value.(type) {
+	case SpecialFn:
+		errPath = pathWith(path, newFrame(n, f))
+		if compile, ok := specialForm(f); ok {
+			return compile(c, n, errPath)
+		}
+	case MacroFn:
+		errPath = pathWith(path, newFrame(n, f))
+		return c.compile(f(n.Nodes[1:], c.env), errPath)
+	}
+	return c.compileCall(n, path)
+}
+
+func (c *compiler) compileCall(n ListNode, path []Frame) code {
+	argsSite, callSite := &site{node: n, path: path}, &site{node: n, path: path, isCall: true}
+	head, args := c.compileArg(n.Nodes[0], argsSite), c.compileArgs(n.Nodes[1:], argsSite)
+	late := c.snapshot()
+	return func(l *locals) (Node, *tailCall) {
+		l.site = argsSite
+		fn, _ := head(l)
+		fln, ok := fn.(LiteralNode)
+		assert(ok, "cannot use %s as a function", n.Nodes[0])
+		switch f := fln.Value.(type) {
+		case MacroFn, SpecialFn:
+			return late.snapshot().compileLate(n, f, l)(l)
+		}
+		if cl, ok := fln.Value.(Closure); ok && cl.fn != nil {
+			buffer := [4]Node{} // the args are bound to the slots of the callee - they do not outlive the call
+			values := buffer[:0]
+			for _, arg := range args {
+				v, _ := arg(l)
+				values = append(values, v)
+			}
+			l.site, l.callee = callSite, fln.Value
+			return nil, l.call(cl.fn, values, callSite)
+		}
+		if f, ok := fln.Value.(BorrowingFn); ok && len(args) <= len(l.args)-l.sp {
+			return l.borrow(f, args, callSite), nil
+		}
+		values := make([]Node, len(args))
+		for i, arg := range args {
+			values[i], _ = arg(l)
+		}
+		l.site, l.callee = callSite, fln.Value
+		return Apply(fn, values, l.env), nil
+	}
+}
+
+// borrow calls f with the values of args - evaluated into the args buffer of the cursor of l.
+func (l *locals) borrow(f BorrowingFn, args []code, s *site) Node {
+	base := l.sp
+	for i, arg := range args {
+		v, _ := arg(l)
+		l.args[base+i], l.sp = v, base+i+1 // not l.sp++ - nested calls that failed (and were caught) did not pop
+	}
+	l.site, l.callee = s, f
+	values := l.args[base:l.sp]
+	n := f(values, l.env)
+	l.env.state.alloc(n)
+	clear(values)
+	l.sp = base
+	return n
+}
+
+// snapshot returns a copy of c for compiling forms later.
+func (c *compiler) snapshot() *compiler {
+	snapshot := *c
+	return &snapshot
+}
+
+// compileLate compiles the call n to the special form or macro f that was only known at runtime (e.g. as it
+// was defined after n was compiled). The form is compiled as a level of its own: the locals of l were allocated
+// already. Special forms unknown to the compiler are called with an env that contains the locals of l.
+func (c *compiler) compileLate(n ListNode, f Any, l *locals) code {
+	switch f := f.(type) {
+	case MacroFn:
+		return c.compileLevel(f(n.Nodes[1:], l.env), n)
+	case SpecialFn:
+		if _, ok := specialForm(f); ok {
+			return c.compileLevel(n, n)
+		}
+		env := c.materialize(l)
+		return func(l *locals) (Node, *tailCall) {
+			n, env, isFinal := f(n.Nodes[1:], env)
+			if !isFinal {
+				n = eval(n, env)
+			}
+			return n, nil
+		}
+	}
+	panic(errorf("cannot compile %s", n))
+}
+
+func (c *compiler) compileLevel(n Node, form ListNode) code {
+	outer := c.scope
+	c.scope = lexScope{level: &level{outer: outer}}
+	body := c.compile(n, nil)
+	lvl := c.scope.level
+	c.scope = outer
+	return func(l *locals) (Node, *tailCall) { return body(newLocals(lvl, l, l.env)) }
+}
+
+// materialize returns an env that contains the locals visible to c - read from l.
+func (c *compiler) materialize(l *locals) *Env {
+	if c.scope.level.outer.level == nil && c.scope.bindings == nil && c.topLevel {
+		return l.env
+	}
+	env := ChildEnv(l.env)
+	for s, depth := c.scope, 0; s.level != nil; s, depth = s.level.outer, depth+1 {
+		for b := s.bindings; b != nil; b = b.next {
+			if _, exists := env.lookup(b.name); !exists {
+				v, _ := localCode(depth, b.slot)(l)
+				env.Set(b.name, v)
+			}
+		}
+	}
+	return env
+}
+
+func (c *compiler) compileIf(n ListNode, path []Frame) code {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 2, "wrong number of arguments for if")
+	defer c.enter()()
+	s := &site{node: n, path: path}
+	test, then, otherwise := c.compileArg(nodes[0], s), c.compile(nodes[1], path), constant(LiteralNode{nil})
+	if len(nodes) == 3 {
+		otherwise = c.compile(nodes[2], path)
+	}
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		if v, _ := test(l); isTruthy(v) {
+			return then(l)
+		}
+		return otherwise(l)
+	}
+}
+
+func (c *compiler) compileDef(n ListNode, path []Frame) code {
+	assert(c.topLevel, "def must only be called from top level")
+	sn, valueForm, meta := parseDef(n)
+	s := &site{node: n, path: path}
+	value, d := c.compileArg(valueForm, s), definition{meta: meta, form: s.topLevelForm()}
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		v, _ := value(l)
+		l.env.define(sn.Value, v, d)
+		return LiteralNode{nil}, nil
+	}
+}
+
+// compileFn compiles fn and macro forms. Each arity is a level of its own - its slot 0 holds the fn itself
+// if the fn is named.
+func (c *compiler) compileFn(n ListNode, path []Frame) code {
+	name, arities := parseFn(n.Nodes[1:])
+	for _, a := range arities {
+		checkRecur(a.body, c.env)
+	}
+	c.scope.markCaptures()
+	defer c.enter()()
+	outer, outerRecur := c.scope, c.recur
+	t := &fnTemplate{name: name, env: c.env, isMacro: callTo(n) == "macro"}
+	for _, a := range arities {
+		c.scope = lexScope{level: &level{outer: outer}}
+		c.scope.declare(name)
+		ca := &compiledArity{arity: a}
+		ca.params, ca.bind = c.scope.declareParams(a)
+		c.recur = &recurTarget{level: c.scope.level, count: a.required, arity: ca}
+		if a.variadic {
+			c.recur.count++
+		}
+		ca.code, ca.level = c.compileBody(a.body, nil, nil), c.scope.level
+		t.arities = append(t.arities, ca)
+	}
+	c.scope, c.recur = outer, outerRecur
+	return func(l *locals) (Node, *tailCall) {
+		return newFunction(t, l).self, nil
+	}
+}
+
+// declareParams declares the params of a. If they are all symbols, their slots are returned - otherwise a binder
+// that destructures the args (as a vector) to them.
+func (s *lexScope) declareParams(a arity) ([]int, *binder) {
+	params := a.params.Seq()
+	for i, param := range params {
+		if sn, ok := param.(SymbolNode); !ok || (sn.Value == "&" && (i != len(params)-2 || !isSymbol(params[i+1]))) {
+			return nil, s.binder(a.params)
+		}
+	}
+	slots := make([]int, 0, len(params))
+	for _, param := range params {
+		if sn := param.(SymbolNode); sn.Value != "&" {
+			slots = append(slots, s.declare(sn.Value))
+		}
+	}
+	return slots, nil
+}
+
+func (c *compiler) compileTry(n ListNode, path []Frame) code {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 1, "wrong number of arguments for try")
+	catch, ok := nodes[len(nodes)-1].(ListNode)
+	assert(ok && callTo(catch) == "catch", "last form of try must be a catch clause")
+	assert(len(catch.Nodes) >= 2, "invalid catch clause (inside try)")
+	sn, ok := catch.Nodes[1].(SymbolNode)
+	assert(ok, "catch clause must have symbol as first element")
+	defer c.enter()()
+	s := &site{node: n, path: path}
+	body, outer := c.compileArgs(nodes[:len(nodes)-1], s), c.scope
+	slot := c.scope.declare(sn.Value)
+	catchBody := c.compileArgs(catch.Nodes[2:], s)
+	c.scope = outer
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		n, err := evalTry(body, l, s)
+		if err == nil {
+			return n, nil
+		} else if slot >= 0 {
+			l.slots[slot] = ToNode(*err)
+		}
+		for _, c := range catchBody {
+			n, _ = c(l)
+		}
+		return n, nil
+	}
+}
+
+// evalTry evaluates body and returns the error it panicked with - unless the evaluation was aborted.
+func evalTry(body []code, l *locals, s *site) (n Node, err *Error) {
+	defer func() {
+		if x := recover(); x != nil {
+			n, err = nil, caught(x, l, s)
+		}
+	}()
+	n = LiteralNode{nil}
+	for _, c := range body {
+		n, _ = c(l)
+	}
+	return n, nil
+}
+
+// caught returns x as caught by the try form at s - it panics again if the evaluation was aborted.
+// The error is reported at the cursor like by run - without the frames of s (the try form) and its path.
+func caught(x Any, l *locals, s *site) *Error {
+	if IsAborted(asError(x)) {
+		panic(x)
+	}
+	e := errorAt(x, l.cursor, nil, nil)
+	e.Stack = e.Stack[:max(0, len(e.Stack)-len(s.path))]
+	return &e
+}
+
+// Quoted forms are data - their source positions are dropped so that equal forms compare equal.
+func (c *compiler) compileQuote(n ListNode, path []Frame) code {
+	assert(len(n.Nodes) == 2, "wrong number of arguments for quote")
+	return constant(withoutPosition(n.Nodes[1]))
+}
+
+func (c *compiler) compileDo(n ListNode, path []Frame) code {
+	return c.compileBody(n.Nodes[1:], &site{node: n, path: path}, path)
+}
+
+func (c *compiler) compileLet(n ListNode, path []Frame) code {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 1, "wrong number of arguments for let")
+	bindings, ok := nodes[0].(VectorNode)
+	assert(ok && bindings.Count()%2 == 0, "let requires a vector with an even number of bindings")
+	defer c.enter()()
+	outer, s := c.scope, &site{node: n, path: path}
+	values, binds := []code{}, []*binder{}
+	for bs := bindings.Seq(); len(bs) != 0; bs = bs[2:] {
+		values = append(values, c.compileArg(bs[1], s))
+		binds = append(binds, c.scope.binder(bs[0]))
+	}
+	body := c.compileBody(nodes[1:], s, path)
+	c.scope = outer
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		for i, value := range values {
+			v, _ := value(l)
+			binds[i].bind(l, v)
+		}
+		return body(l)
+	}
+}
+
+// compileLoop compiles loop as a level of its own. recur in its body rebinds the bindings and returns
+// to the loop - the loop runs in constant stack space.
+func (c *compiler) compileLoop(n ListNode, path []Frame) code {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) >= 1, "wrong number of arguments for loop")
+	bindings, ok := nodes[0].(VectorNode)
+	assert(ok && bindings.Count()%2 == 0, "loop requires a vector with an even number of bindings")
+	checkRecur(nodes[1:], c.env)
+	defer c.enter()()
+	outer, outerRecur := c.scope, c.recur
+	c.scope = lexScope{level: &level{outer: outer}}
+	lvl, s := c.scope.level, &site{node: n, path: path}
+	values, binds := []code{}, []*binder{}
+	for bs := bindings.Seq(); len(bs) != 0; bs = bs[2:] {
+		values = append(values, c.compileArg(bs[1], s))
+		binds = append(binds, c.scope.binder(bs[0]))
+	}
+	c.recur = &recurTarget{level: lvl, count: len(binds), binds: binds}
+	body := c.compileBody(nodes[1:], s, path)
+	c.scope, c.recur = outer, outerRecur
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		l = newLocals(lvl, l, l.env)
+		for i, value := range values {
+			v, _ := value(l)
+			binds[i].bind(l, v)
+		}
+		return evalLoop(body, l)
+	}
+}
+
+// evalLoop evaluates body until it returns something other than a recur.
+func evalLoop(body code, l *locals) (Node, *tailCall) {
+	for {
+		n, tc := body(l)
+		if tc == nil || tc.fn != nil {
+			return n, tc
+		} else if tc.next != nil {
+			l = tc.next
+		}
+		l.env.state.step()
+	}
+}
+
+func (c *compiler) compileRecur(n ListNode, path []Frame) code {
+	target := c.recur
+	assert(target != nil, "recur must be called from inside loop or fn")
+	assert(len(n.Nodes)-1 == target.count, "wrong number of arguments for recur: expected %d, got %d", target.count, len(n.Nodes)-1)
+	depth := 0
+	for lvl := c.scope.level; lvl != target.level; lvl = lvl.outer.level {
+		depth++
+	}
+	s := &site{node: n, path: path}
+	args := c.compileArgs(n.Nodes[1:], s)
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		buffer := [4]Node{}
+		values := buffer[:0]
+		for _, arg := range args {
+			v, _ := arg(l)
+			values = append(values, v)
+		}
+		if target.arity != nil && target.arity.variadic {
+			values = append(values[:target.count-1], values[target.count-1].Seq()...)
+		}
+		tl := l
+		for i := 0; i < depth; i++ {
+			tl = tl.parent
+		}
+		if !target.level.captures.Load() {
+			target.bind(tl, values)
+			return nil, recurInPlace
+		}
+		next := tl.clone()
+		target.bind(next, values)
+		l.tail = tailCall{next: next}
+		return nil, &l.tail
+	}
+}
+
+// bind rebinds the locals of the target to values.
+func (t *recurTarget) bind(l *locals, values []Node) {
+	if t.arity != nil {
+		t.arity.bindArgs(l, values)
+		return
+	}
+	for i, b := range t.binds {
+		b.bind(l, values[i])
+	}
+}
+
+func (c *compiler) compileLazySeq(n ListNode, path []Frame) code {
+	c.scope.markCaptures()
+	defer c.enter()()
+	outer, outerRecur := c.scope, c.recur
+	c.scope, c.recur = lexScope{level: &level{outer: outer}}, nil
+	body, lvl := c.compileBody(n.Nodes[1:], nil, nil), c.scope.level
+	c.scope, c.recur = outer, outerRecur
+	return func(l *locals) (Node, *tailCall) {
+		return NewLazySeq(l.env, func() Node { return run(body, newActivation(lvl, l, l.env)) }), nil
+	}
+}
+
+// enter marks the start of a form that creates a scope - def is not allowed inside of it.
+// It returns a func that marks the end of it.
+func (c *compiler) enter() func() {
+	topLevel := c.topLevel
+	c.topLevel = false
+	return func() { c.topLevel = topLevel }
+}
+
+// markCaptures marks all levels of s as captured by a closure.
+func (s lexScope) markCaptures() {
+	for lvl := s.level; lvl != nil; lvl = lvl.outer.level {
+		lvl.captures.Store(true)
+	}
+}
+
+// declare allocates a slot for the local name and returns it - -1 for _ (which is never bound).
+func (s *lexScope) declare(name string) int {
+	if name == "_" {
+		return -1
+	}
+	slot := s.level.size
+	s.level.size++
+	s.bindings = &binding{name, slot, s.bindings}
+	return slot
+}
+
+// binder binds values to locals: to slot - or, if pattern is set, destructured to the slots of its symbols.
+type binder struct {
+	slot    int
+	pattern Node
+	slots   map[string]int
+}
+
+// binder declares the symbols of pattern and returns the binder for them.
+func (s *lexScope) binder(pattern Node) *binder {
+	if sn, ok := pattern.(SymbolNode); ok {
+		return &binder{slot: s.declare(sn.Value)}
+	}
+	b := &binder{slot: -1, pattern: pattern, slots: map[string]int{}}
+	destructureWith(pattern, VectorNode{}, func(name string, _ Node) { b.slots[name] = s.declare(name) })
+	return b
+}
+
+func (b *binder) bind(l *locals, v Node) {
+	if b.pattern == nil {
+		if b.slot >= 0 {
+			l.slots[b.slot] = v
+		}
+		return
+	}
+	destructureWith(b.pattern, v, func(name string, v Node) {
+		if slot := b.slots[name]; slot >= 0 {
+			l.slots[slot] = v
+		}
+	})
+}
+
+// resolve returns the slot of the local name and the number of levels between s and the level of the slot.
+func (s lexScope) resolve(name string) (depth, slot int, ok bool) {
+	for ; s.level != nil; s, depth = s.level.outer, depth+1 {
+		for b := s.bindings; b != nil; b = b.next {
+			if b.name == name {
+				return depth, b.slot, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func localCode(depth, slot int) code {
+	switch depth {
+	case 0:
+		return func(l *locals) (Node, *tailCall) { return l.slots[slot], nil }
+	case 1:
+		return func(l *locals) (Node, *tailCall) { return l.parent.slots[slot], nil }
+	default:
+		return func(l *locals) (Node, *tailCall) {
+			for i := 0; i < depth; i++ {
+				l = l.parent
+			}
+			return l.slots[slot], nil
+		}
+	}
+}
+
+// global is a reference to a global symbol. Its value is cached until the next definition in the runtime.
+type global struct {
+	symbol SymbolNode
+	env    *Env // the env of the compiled code - nil for bytecode, see lookup
+	cache  atomic.Pointer[cachedGlobal]
+}
+
+type cachedGlobal struct {
+	generation uint64
+	scope      *scope
+	value      Node
+}
+
+func (g *global) get(l *locals) (Node, *tailCall) { return g.lookup(g.env), nil }
+
+// lookup returns the value of g in env. Bytecode can be evaluated in any env - the cache is only used for
+// the env it was filled for.
+func (g *global) lookup(env *Env) Node {
+	generation := env.runtime.generation.Load()
+	if cached := g.cache.Load(); cached != nil && cached.generation == generation && cached.scope == env.scope {
+		return cached.value
+	}
+	v, exists := env.Get(g.symbol.Value)
+	if !exists {
+		panic(wrapError(errorf("could not lookup symbol %q", g.symbol.Value), g.symbol))
+	} else if ln, ok := v.(LiteralNode); ok {
+		if _, ok := ln.Value.(notPermitted); ok {
+			defer func() { panic(wrapError(recover(), g.symbol)) }()
+			checkPermitted(v)
+		}
+	}
+	g.cache.Store(&cachedGlobal{generation, env.scope, v})
+	return v
+}
+
+func constant(n Node) code {
+	return func(*locals) (Node, *tailCall) { return n, nil }
+}
+
+// failed returns code that fails with err at s.
+func failed(err Any, s *site) code {
+	return func(l *locals) (Node, *tailCall) {
+		l.site = s
+		if e, ok := err.(Error); ok {
+			e.Stack = append([]Frame(nil), e.Stack...)
+			panic(e)
+		}
+		panic(err)
+	}
+}
+
+func pathWith(path []Frame, f Frame) []Frame {
+	return append(path[:len(path):len(path)], f)
+}
+
+func isSymbol(n Node) bool {
+	_, ok := n.(SymbolNode)
+	return ok
+}
+
+func isTruthy(n Node) bool {
+	ln, ok := n.(LiteralNode)
+	return !ok || (ln.Value != false && ln.Value != nil)
+}
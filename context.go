@@ -0,0 +1,188 @@
+package gowen
+
+// Evaluations started via the *Context functions stop as soon as their context is done or their budget
+// (see WithBudget) runs out. The state of such an evaluation is attached to the env it runs in, inherited by
+// child envs and passed on from caller to callee on fn calls - closures always run with the state of their caller.
+// The state ends with its evaluation: values that keep the env (e.g. lazy seqs or closures stored in Go values)
+// are not stopped by it once the evaluation returned. Go fns that call back into gowen from other goroutines or
+// later on (e.g. go blocks or reified interfaces) should use Env.Detach rather than the env they were called with -
+// such callbacks outlive the evaluation but keep its context and budget.
+// Blocking Go calls (e.g. time/sleep) cannot be interrupted - the evaluation stops once they return.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Budget limits the resources of an evaluation. Steps counts the steps of the evaluator (fn calls & loop iterations),
+// Nodes the elements of the collections built by it (literals and results of builtin / Go fns).
+// Zero means unlimited. Depth limits the nesting of fn calls - zero means DefaultDepth as deeper recursion
+// would overflow the Go stack, which crashes the process rather than failing the evaluation.
+type Budget struct {
+	Steps int64
+	Nodes int64
+	Depth int64
+}
+
+// DefaultDepth limits the nesting of fn calls of evaluations whose budget does not set Depth.
+const DefaultDepth = 10000
+
+// BudgetError is returned when an evaluation exceeds its budget.
+type BudgetError struct {
+	Resource string // "steps", "nodes" or "depth"
+	Limit    int64
+}
+
+type budgetKey struct{}
+
+type evalState struct {
+	ctx    context.Context
+	done   <-chan struct{}
+	budget Budget
+	usage  *usage      // shared with the states derived from this one - see derive
+	depth  int64       // nesting of fn calls - see enter
+	ended  atomic.Bool // set once the evaluation returned - see end
+}
+
+type usage struct {
+	steps int64
+	nodes int64
+}
+
+func (e BudgetError) Error() string {
+	return fmt.Sprintf("evaluation exceeded budget of %d %s", e.Limit, e.Resource)
+}
+
+// WithBudget returns a copy of ctx that limits evaluations started with it to budget.
+func WithBudget(ctx context.Context, budget Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+func ParseAndEvalContext(ctx context.Context, input string, env *Env) (n Node, err error) {
+	defer handleError(&err)
+	s := newEvalState(ctx)
+	defer s.end()
+	env = env.withState(s)
+	nodes := evalMultiple(parse(input), env)
+	return nodes[len(nodes)-1], nil
+}
+
+func EvalContext(ctx context.Context, node Node, env *Env) (n Node, err error) {
+	defer handleError(&err)
+	s := newEvalState(ctx)
+	defer s.end()
+	env = env.withState(s)
+	return eval(node, env), nil
+}
+
+func EvalMultipleContext(ctx context.Context, nodes []Node, env *Env) (results []Node, err error) {
+	defer handleError(&err)
+	s := newEvalState(ctx)
+	defer s.end()
+	env = env.withState(s)
+	return evalMultiple(nodes, env), nil
+}
+
+// IsAborted reports whether err was caused by a cancelled context, a passed deadline or an exceeded budget.
+// Such errors cannot be caught by try.
+func IsAborted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &BudgetError{})
+}
+
+func newEvalState(ctx context.Context) *evalState {
+	budget, _ := ctx.Value(budgetKey{}).(Budget)
+	if budget.Depth == 0 {
+		budget.Depth = DefaultDepth
+	}
+	return &evalState{ctx: ctx, done: ctx.Done(), budget: budget, usage: &usage{}}
+}
+
+// derive returns a state that is not ended with s but stops with its context and counts against its budget.
+// Calls evaluated with it count as nested in the ones running in s when it was derived.
+func (s *evalState) derive() *evalState {
+	return &evalState{ctx: s.ctx, done: s.done, budget: s.budget, usage: s.usage, depth: atomic.LoadInt64(&s.depth)}
+}
+
+// Context returns the context of the evaluation running in e - context.Background() if it was not started
+// via one of the *Context functions or already returned. Blocking Go fns should stop once it is done.
+func (e *Env) Context() context.Context {
+	if e.state == nil || e.state.ended.Load() {
+		return context.Background()
+	}
+	return e.state.ctx
+}
+
+// Detach returns a view of e that is not ended with the evaluation running in it. Go fns that keep their env
+// to call back into gowen from other goroutines or after the evaluation returned should keep a detached one -
+// and detach it again for calls that may run concurrently. Such calls still stop once the context of the
+// evaluation that created them is done and count against its budget.
+func (e *Env) Detach() *Env {
+	if e.state == nil {
+		return e
+	}
+	return e.withState(e.state.derive())
+}
+
+// withState returns a view of e that evaluates with the state s. The view shares the values of e -
+// definitions in it are definitions in e - but not its state, so e can be used by other evaluations concurrently.
+func (e *Env) withState(s *evalState) *Env {
+	return &Env{scope: e.scope, parent: e.parent, allowRedefine: e.allowRedefine, runtime: e.runtime, state: s}
+}
+
+// end ends s - evaluations with an ended state are no longer stopped or counted.
+func (s *evalState) end() { s.ended.Store(true) }
+
+func (s *evalState) step() {
+	if s == nil || s.ended.Load() {
+		return
+	}
+	select {
+	case <-s.done:
+		panic(s.ctx.Err())
+	default:
+	}
+	if steps := atomic.AddInt64(&s.usage.steps, 1); s.budget.Steps != 0 && steps > s.budget.Steps {
+		panic(BudgetError{"steps", s.budget.Steps})
+	}
+}
+
+// enter is the step into a nested call - it must be followed by a call of leave once the call returned.
+func (s *evalState) enter() {
+	if s == nil {
+		return
+	}
+	if depth := atomic.AddInt64(&s.depth, 1); depth > s.budget.Depth && !s.ended.Load() {
+		panic(BudgetError{"depth", s.budget.Depth})
+	}
+	s.step()
+}
+
+func (s *evalState) leave() {
+	if s != nil {
+		atomic.AddInt64(&s.depth, -1)
+	}
+}
+
+func (s *evalState) alloc(n Node) {
+	if s == nil || s.ended.Load() {
+		return
+	}
+	size := 0
+	switch n := n.(type) {
+	case ListNode:
+		size = len(n.Nodes)
+	case VectorNode:
+		size = n.Count()
+	case MapNode:
+		size = n.Count()
+	case SetNode:
+		size = n.Count()
+	default:
+		return
+	}
+	if nodes := atomic.AddInt64(&s.usage.nodes, int64(size)); s.budget.Nodes != 0 && nodes > s.budget.Nodes {
+		panic(BudgetError{"nodes", s.budget.Nodes})
+	}
+}
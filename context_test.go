@@ -0,0 +1,157 @@
+package gowen_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var contextTests = []struct {
+	name   string
+	budget gowen.Budget
+	input  string
+	err    error
+}{
+	{"within budget", gowen.Budget{Steps: 1000, Nodes: 100}, `(loop [i 0] (if (< i 10) (recur (+ i 1)) [i]))`, nil},
+	{"steps", gowen.Budget{Steps: 1000}, `(loop [i 0] (recur (+ i 1)))`, gowen.BudgetError{Resource: "steps", Limit: 1000}},
+	{"nodes", gowen.Budget{Nodes: 100}, `(loop [xs []] (recur (conj xs 1)))`, gowen.BudgetError{Resource: "nodes", Limit: 100}},
+	{"nodes literal", gowen.Budget{Nodes: 2}, `[1 2 3]`, gowen.BudgetError{Resource: "nodes", Limit: 2}},
+	{"try cannot catch", gowen.Budget{Steps: 1000}, `(try (loop [] (recur)) (catch err :caught))`, gowen.BudgetError{Resource: "steps", Limit: 1000}},
+	{"infinite lazy seq", gowen.Budget{Steps: 1000}, `(count (range))`, gowen.BudgetError{Resource: "steps", Limit: 1000}},
+	{"lazy seq nodes", gowen.Budget{Nodes: 100}, `(count (take 1000 (range)))`, gowen.BudgetError{Resource: "nodes", Limit: 100}},
+	{"fn defined outside", gowen.Budget{Steps: 1000}, `(forever)`, gowen.BudgetError{Resource: "steps", Limit: 1000}},
+	{"depth", gowen.Budget{Depth: 100}, `((fn f [] (+ 1 (f))))`, gowen.BudgetError{Resource: "depth", Limit: 100}},
+	{"default depth", gowen.Budget{}, `((fn f [] (+ 1 (f))))`, gowen.BudgetError{Resource: "depth", Limit: gowen.DefaultDepth}},
+	{"tail calls are not nested", gowen.Budget{Depth: 100}, `((fn f [i] (if (< i 1000) (f (+ i 1)) i)) 0)`, nil},
+}
+
+func TestEvalContext(t *testing.T) {
+	env := gowen.NewEnv(false)
+	if _, err := gowen.ParseAndEval(`(defn forever [] (recur))`, env); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range contextTests {
+		ctx := gowen.WithBudget(context.Background(), test.budget)
+		_, err := gowen.ParseAndEvalContext(ctx, test.input, env)
+		var budgetErr gowen.BudgetError
+		if test.err == nil && err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+		} else if test.err != nil && (!errors.As(err, &budgetErr) || budgetErr != test.err || !gowen.IsAborted(err)) {
+			t.Errorf("%s: got %v expected %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestEvalContextConcurrent(t *testing.T) {
+	env := gowen.NewEnv(false)
+	if _, err := gowen.ParseAndEval(`(defn forever [] (recur))`, env); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				ctx := gowen.WithBudget(context.Background(), gowen.Budget{Steps: 1000})
+				if _, err := gowen.ParseAndEvalContext(ctx, `(forever)`, env); !gowen.IsAborted(err) {
+					t.Errorf("expected budget error, got %v", err)
+				}
+			} else {
+				input := fmt.Sprintf(`(def x%d (count (take 5000 (range)))) x%d`, i, i)
+				if n, err := gowen.ParseAndEvalContext(context.Background(), input, env); err != nil || n.ToGo() != int64(5000) {
+					t.Errorf("expected unlimited evaluation to succeed, got %v %v", n, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := gowen.ParseAndEvalContext(ctx, `(loop [] (recur))`, gowen.NewEnv(false))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = gowen.ParseAndEvalContext(ctx, `(<! (chan))`, gowen.NewEnv(false))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected blocked receive to stop with deadline exceeded error, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = gowen.ParseAndEvalContext(ctx, `(+ 1 2)`, gowen.NewEnv(false))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+}
+
+func TestEvalContextEnded(t *testing.T) {
+	env := gowen.NewEnv(false)
+	eval := func(input string) (gowen.Node, error) {
+		ctx, cancel := context.WithCancel(gowen.WithBudget(context.Background(), gowen.Budget{Steps: 100}))
+		defer cancel()
+		return gowen.ParseAndEvalContext(ctx, input, env)
+	}
+	if _, err := eval(`(def xs (map (fn [x] (* x 2)) (range))) (def ys (lazy-seq [1 2])) (def f (fn [] (count (take 200 xs))))`); err != nil {
+		t.Fatal(err)
+	}
+	// the values keep the env of the evaluation that created them - it is cancelled and its budget would be exceeded
+	for input, expected := range map[string]string{"(count (take 300 xs))": "300", "ys": "(1 2)", "(f)": "200"} {
+		n, err := gowen.ParseAndEval(input, env)
+		if err != nil || n.String() != expected {
+			t.Errorf("%s: got %v (%v) expected %s", input, n, err, expected)
+		}
+	}
+}
+
+func TestEvalContextCallbacks(t *testing.T) {
+	env, callback := gowen.NewEnv(false), (func() int64)(nil)
+	env.Set("keep", func(f func() int64) { callback = f })
+	call := func() (n int64, err error) {
+		defer func() {
+			if x := recover(); x != nil {
+				err = x.(error)
+			}
+		}()
+		return callback(), nil
+	}
+	ctx, cancel := context.WithCancel(gowen.WithBudget(context.Background(), gowen.Budget{Steps: 1000}))
+	defer cancel()
+	if _, err := gowen.ParseAndEvalContext(ctx, `(keep (fn [] (loop [i 0] (if (< i 100) (recur (+ i 1)) i))))`, env); err != nil {
+		t.Fatal(err)
+	}
+	// the callback outlives the evaluation that created it - but not its budget or context
+	if n, err := call(); err != nil || n != 100 {
+		t.Errorf("expected the callback to outlive the evaluation: got %v (%v)", n, err)
+	}
+	err, budgetErr := error(nil), gowen.BudgetError{}
+	for i := 0; i < 20 && err == nil; i++ {
+		_, err = call()
+	}
+	if !errors.As(err, &budgetErr) || budgetErr.Resource != "steps" {
+		t.Errorf("expected the callback to exceed the budget of the evaluation: got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := gowen.ParseAndEvalContext(ctx, `(keep (fn [] 1))`, env); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := call(); err != nil || n != 1 {
+		t.Errorf("expected the callback to outlive the evaluation: got %v (%v)", n, err)
+	}
+	cancel()
+	if _, err := call(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the callback to stop with the context of the evaluation: got %v", err)
+	}
+}
@@ -3,6 +3,11 @@ package gowen
 import "reflect"
 
 func destructure(binding Node, value Node, env *Env) {
+	destructureWith(binding, value, func(name string, v Node) { env.Set(name, v) })
+}
+
+// destructureWith binds value to binding by calling set for each symbol of binding.
+func destructureWith(binding Node, value Node, set func(string, Node)) {
 	defer func() {
 		if err := recover(); err != nil {
 			panic(errorf("could not destructure %s to %s: %s", binding, value, err))
@@ -10,51 +15,50 @@ func destructure(binding Node, value Node, env *Env) {
 	}()
 	switch binding := binding.(type) {
 	case SymbolNode:
-		env.Set(binding.Value, value)
+		set(binding.Value, value)
 	case VectorNode, ListNode:
-		destructureSeq(binding, value, env)
+		destructureSeq(binding, value, set)
 	case MapNode, ArrayMapNode:
-		destructureMap(binding, value, env)
+		destructureMap(binding, value, set)
 	default:
 		panic(errorf("bad node for param %s %s", binding, value))
 	}
 }
 
-func destructureSeq(binding Node, value Node, env *Env) {
+// destructureSeq only realizes as many elements of lazy sequences as there are bindings.
+func destructureSeq(binding Node, value Node, set func(string, Node)) {
 	cbs := binding.Seq()
-	cvs := value.Seq()
+	rest := toSeqNode(value)
 	for i := 0; i < len(cbs); i++ {
 		cb := cbs[i]
 		if kn, _ := cb.(KeywordNode); kn.Value == "as" {
-			env.Set(cbs[i+1].(SymbolNode).Value, value)
+			set(cbs[i+1].(SymbolNode).Value, value)
 			i++
 		} else if sn, _ := cb.(SymbolNode); sn.Value == "&" {
-			ln := ListNode{}
-			if len(cvs) >= i {
-				ln.Nodes = cvs[i:]
-			}
-			destructure(cbs[i+1], ln, env)
+			destructureWith(cbs[i+1], rest, set)
 			i++
 		} else {
-			destructure(cb, VectorNode{cvs}.Get(LiteralNode{i}), env)
+			var first Node
+			first, rest = uncons(rest)
+			destructureWith(cb, first, set)
 		}
 	}
 }
 
-func destructureMap(binding Node, value Node, env *Env) {
+func destructureMap(binding Node, value Node, set func(string, Node)) {
 	vm := toMapNode(value)
 	for _, vn := range binding.Seq() {
-		vns := vn.(VectorNode).Nodes
+		vns := vn.Seq()
 		k, v := vns[0], vns[1]
 		if kn, ok := k.(KeywordNode); ok && kn.Value == "as" {
-			env.Set(v.(SymbolNode).Value, vm)
+			set(v.(SymbolNode).Value, vm)
 		} else if ok && kn.Value == "keys" {
-			for _, n := range v.(VectorNode).Nodes {
+			for _, n := range v.(VectorNode).Seq() {
 				symbol := n.(SymbolNode).Value
-				env.Set(symbol, vm.Get(KeywordNode{symbol}))
+				set(symbol, vm.Get(KeywordNode{symbol}))
 			}
 		} else {
-			destructure(k, vm.Get(v), env)
+			destructureWith(k, vm.Get(v), set)
 		}
 	}
 }
@@ -67,16 +71,16 @@ func toMapNode(n Node) Node {
 	case MapNode, ArrayMapNode:
 		return n
 	case ListNode, VectorNode:
-		return ArrayMapNode{n.Seq()}
+		return ArrayMapNode{Nodes: n.Seq()}
 	case LiteralNode:
 		if v := reflect.ValueOf(n.Value); v.Kind() == reflect.Map {
 			ns := []Node{}
 			for _, vn := range n.Seq() {
 				ns = append(ns, vn.Seq()...)
 			}
-			return ArrayMapNode{ns}
+			return ArrayMapNode{Nodes: ns}
 		}
-		return ArrayMapNode{n.Seq()}
+		return ArrayMapNode{Nodes: n.Seq()}
 	default:
 		panic(errorf("cannot use %s as MapNode", n))
 	}
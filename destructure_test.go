@@ -15,14 +15,14 @@ type destructureTest struct {
 var destructureTests = []destructureTest{
 	{"sequential vector", "[x y z]", "[1 2 3]", `{"x" 1 "y" 2 "z" 3}`},
 	{"sequential list", "[x y z]", "(1 2 3)", `{"x" 1 "y" 2 "z" 3}`},
-	{"sequential string", "[x y z]", `"abc"`, `{"x" "a" "y" "b" "z" "c"}`},
+	{"sequential string", "[x y z]", `"abc"`, `{"x" \a "y" \b "z" \c}`},
 	{"sequential shorter", "[x y z]", "[1]", `{"x" 1 "y" nil "z" nil}`},
 	{"sequential longer", "[x y z]", "[1 2 3 4]", `{"x" 1 "y" 2 "z" 3}`},
 	{"sequential & rest", "[x & xs]", "[1 2 3]", `{"x" 1 "xs" '(2 3)}`},
 	{"sequential ignore", "[x _ z]", "[1 2 3]", `{"x" 1 "z" 3}`},
 	{"sequential vector :all", "[x :as xs]", "[1 2 3]", `{"x" 1 "xs" [1 2 3]}`},
 	{"sequential list :all", "[x :as xs]", "(1 2 3)", `{"x" 1 "xs" '(1 2 3)}`},
-	{"sequential string :all", "[x :as xs]", `"abc"`, `{"x" "a" "xs" "abc"}`},
+	{"sequential string :all", "[x :as xs]", `"abc"`, `{"x" \a "xs" "abc"}`},
 	{"sequential ignore & :all", "[x _ & xs :as all-xs]", "[1 2 3]", `{"x" 1 "xs" '(3) "all-xs" [1 2 3]}`},
 	{"sequential nested", "[[x] [y z]]", "[[1] [2 3]]", `{"x" 1 "y" 2 "z" 3}`},
 
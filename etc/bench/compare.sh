@@ -0,0 +1,21 @@
+#!/bin/sh
+# Runs the workloads of etc/bench/main.go in the tree of a git ref (default: the initial commit) and in the working
+# tree and prints their ns/op and the speedup. Usage: etc/bench/compare.sh [ref]
+set -e
+root=$(git rev-parse --show-toplevel)
+ref=${1:-$(git -C "$root" rev-list --max-parents=0 HEAD)}
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+mkdir "$tmp/old" "$tmp/new"
+git -C "$root" archive "$ref" | tar -x -C "$tmp/old"
+tar -C "$root" --exclude=.git -cf - . | tar -x -C "$tmp/new"
+export GOFLAGS=-mod=mod
+for tree in old new; do
+    cd "$tmp/$tree"
+    mkdir -p etc/bench && cp "$root/etc/bench/main.go" etc/bench/main.go
+    [ -f go.mod ] || printf 'module github.com/niklasfasching/gowen\n\ngo 1.21\n' > go.mod
+    (cd lib/core && go run main.go)
+    go run ./etc/bench > "$tmp/$tree.txt"
+done
+echo "workload $ref working-tree speedup"
+join "$tmp/old.txt" "$tmp/new.txt" | awk '{ printf "%s %d %d %.1fx\n", $1, $2, $3, $2 / $3 }'
@@ -0,0 +1,47 @@
+package main
+
+// bench runs workloads that only use the features of the initial evaluator - so that they can be compared across
+// versions (see compare.sh). The workloads of BenchmarkEval need loop & co.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/niklasfasching/gowen"
+	_ "github.com/niklasfasching/gowen/lib/core"
+)
+
+var ones = "[" + strings.Repeat("1 ", 1000) + "]"
+
+var workloads = []struct{ name, setup, input string }{
+	{"fib", `(defn fib [n] (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))`, `(fib 20)`},
+	{"calls", `(defn id [x] x) (defn calls [i] (if (< i 1) i (calls (id (id (- i 1))))))`, `(calls 1000)`},
+	{"locals", `(defn locals [i] (let [a 1 b 2 c 3] (if (< i 1) [a b c] (locals (- i a)))))`, `(locals 1000)`},
+	{"reduce", "", `(reduce + 0 ` + ones + `)`},
+	{"closures", `(defn adder [x] (fn [y] (+ x y)))`, `(reduce (fn [acc f] (f acc)) 0 (map adder ` + ones + `))`},
+}
+
+func main() {
+	for _, w := range workloads {
+		env := gowen.NewEnv(false)
+		if _, err := gowen.ParseAndEval(w.setup+" nil", env); err != nil {
+			fmt.Fprintln(os.Stderr, w.name, err)
+			os.Exit(1)
+		}
+		nodes, err := gowen.Parse(w.input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, w.name, err)
+			os.Exit(1)
+		}
+		r := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := gowen.EvalMultiple(nodes, env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		fmt.Println(w.name, r.NsPerOp())
+	}
+}
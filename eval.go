@@ -1,78 +1,218 @@
 package gowen
 
-import "strings"
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
 type Fn = func([]Node, *Env) Node
 type MacroFn Fn
 
+// BorrowingFn is a Fn that only borrows the slice of its args: it must not keep it (or sub slices of it) after it
+// returned. Compiled calls pass the args of BorrowingFns in a buffer of the caller rather than in a slice of their
+// own - which saves an allocation per call of cheap builtins like arithmetic.
+type BorrowingFn Fn
+
 type ComplexFn = func([]Node, *Env) (Node, *Env, bool)
 type SpecialFn ComplexFn
 
+// Closure is a fn created by the fn special form. The name is only used for stack traces.
+type Closure struct {
+	Name string
+	Fn   ComplexFn
+	fn   *function // set for compiled fns - calls to them are evaluated by run without going through Fn
+}
+
+// Env is safe for concurrent use: Any number of goroutines may evaluate in (and define values in) the same env
+// or envs sharing a parent at the same time. Each evaluation started via one of the *Context functions has its
+// own state (see context.go) - concurrent evaluations in the same env do not share their context or budget.
+// Values are not copied - mutable Go values (e.g. slices or structs) must be synchronized by their users.
 type Env struct {
+	*scope
 	parent        *Env
-	values        map[string]Any
 	allowRedefine bool
-	interrupted   bool
+	runtime       *Runtime
+	state         *evalState // set by the *Context functions - see context.go
 }
 
-var rootEnv = &Env{
-	values: map[string]Any{
-		"nil":   nil,
-		"true":  true,
-		"false": false,
-	},
+// scope holds the bindings of an env. It is shared between an env and its views (see withState).
+type scope struct {
+	mu      sync.RWMutex // guards values, defs & ns
+	values  map[string]Any
+	defs    map[string]definition // of the values that have metadata or were defined via def - see Var
+	ns      *namespace            // set on top level envs that belong to a namespace or require others
+	loading bool                  // set on the envs of namespaces loaded from files - see loadNamespace
+	origin  *scope                // set on the scopes of namespaces an env switched to - see setNamespace
 }
 
-func NewEnv(allowRedefine bool) *Env { return &Env{rootEnv, nil, allowRedefine, false} }
+// definition holds the metadata of a value and the form that defined it - see Var.
+type definition struct {
+	meta MapNode
+	form Node
+}
 
-func ChildEnv(parent *Env) *Env { return &Env{parent, nil, parent.allowRedefine, false} }
+// NewEnv returns a new top level env of the DefaultRuntime.
+func NewEnv(allowRedefine bool) *Env { return DefaultRuntime.NewEnv(allowRedefine) }
 
-func Register(m map[string]Any, input string) {
-	for k, v := range m {
-		rootEnv.Set(k, v)
-	}
-	EvalMultiple(parse(input), rootEnv)
+func ChildEnv(parent *Env) *Env {
+	return &Env{scope: &scope{}, parent: parent, allowRedefine: parent.allowRedefine, runtime: parent.runtime, state: parent.state}
 }
 
+// Register registers the values of m to the DefaultRuntime and evaluates input in its root env.
+func Register(m map[string]Any, input string) error { return DefaultRuntime.Register(m, input) }
+
+// Get looks up key in e and its parents. Qualified symbols (ns/name) that are not defined
+// as such are looked up in the namespace ns - or the namespace ns is an alias for.
 func (e *Env) Get(key string) (Node, bool) {
-	v, exists := e.values[key]
-	if exists {
-		return ToNode(v), true
+	for env := e; env != nil; env = env.parent {
+		if v, exists := env.lookup(key); exists {
+			return ToNode(v), true
+		}
 	}
-	if !exists && e.parent != nil {
-		return e.parent.Get(key)
+	if i := strings.Index(key, "/"); i > 0 && i < len(key)-1 {
+		return e.getQualified(key[:i], key[i+1:])
 	}
 	return nil, false
 }
 
-func (e *Env) Set(key string, value Any) {
-	if e.values == nil {
-		e.values = map[string]Any{}
+// Var returns the var of name - the binding Get would look up name in.
+func (e *Env) Var(name string) (Var, bool) {
+	for env := e; env != nil; env = env.parent {
+		if _, exists := env.lookup(name); exists {
+			return Var{Name: name, env: env, key: name}, true
+		}
+	}
+	if i := strings.Index(name, "/"); i > 0 && i < len(name)-1 && e.runtime != nil {
+		nsName := name[:i]
+		if target, ok := e.topLevel().alias(nsName); ok {
+			nsName = target
+		}
+		if env, key := e.runtime.qualified(nsName, name[i+1:]); env != nil {
+			if _, exists := env.lookup(key); exists {
+				return Var{Name: name, env: env, key: key}, true
+			}
+		}
 	}
+	return Var{}, false
+}
+
+// Var is the binding of a name in an env - as returned by (var x) or #'x. Besides the value it holds the
+// metadata of the definition (e.g. the docstring of defn) and the form that defined it.
+type Var struct {
+	Name string
+	env  *Env
+	key  string
+}
+
+// Deref returns the current value of v.
+func (v Var) Deref() Node {
+	x, _ := v.env.lookup(v.key)
+	return ToNode(x)
+}
+
+// Meta returns the metadata of v - see def.
+func (v Var) Meta() MapNode { return v.env.definition(v.key).meta }
+
+// Source returns the top level form that defined v - nil for values registered from Go.
+func (v Var) Source() Node { return v.env.definition(v.key).form }
+
+func (v Var) String() string   { return "#'" + v.Name }
+func (v Var) GoString() string { return v.String() }
+
+// Vars returns the vars visible from e - sorted by name.
+func (e *Env) Vars() []Var {
+	vars := map[string]Var{}
+	for env := e; env != nil; env = env.parent {
+		env.each(func(k string, _ Any) {
+			if _, exists := vars[k]; !exists {
+				vars[k] = Var{Name: k, env: env, key: k}
+			}
+		})
+	}
+	out := make([]Var, 0, len(vars))
+	for _, v := range vars {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (e *Env) Set(key string, value Any) { e.define(key, value, definition{}) }
+
+// define sets key to value like Set and records d as its definition - replacing the previous one.
+func (e *Env) define(key string, value Any, d definition) {
 	if key == "_" {
 		return
 	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.values == nil {
+		e.values = map[string]Any{}
+	}
 	_, exists := e.values[key]
 	assert(e.allowRedefine || !exists, "must not redefine %s (%s)", key, value)
 	e.values[key] = value
+	if d.meta.Count() != 0 || d.form != nil {
+		if e.defs == nil {
+			e.defs = map[string]definition{}
+		}
+		e.defs[key] = d
+	} else {
+		delete(e.defs, key)
+	}
+	e.runtime.define()
 }
 
-func (e *Env) IsTopLevel() bool {
-	return e == rootEnv || e.parent == rootEnv
+// setMeta replaces the metadata of the value of key in e.
+func (e *Env) setMeta(key string, m MapNode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.defs == nil {
+		e.defs = map[string]definition{}
+	}
+	d := e.defs[key]
+	d.meta = m
+	e.defs[key] = d
 }
 
-func (e *Env) Interrupt() {
-	e.interrupted = true
+func (e *Env) definition(key string) definition {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.defs[key]
 }
 
-func (e *Env) CheckInterrupted() {
-	if e.interrupted {
-		e.interrupted = false
-		panic("interrupted!")
+// lookup returns the value of key in e - parents are not considered.
+func (e *Env) lookup(key string) (Any, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	v, exists := e.values[key]
+	return v, exists
+}
+
+// each calls f for a snapshot of the values of e - parents are not considered.
+func (e *Env) each(f func(key string, value Any)) {
+	e.mu.RLock()
+	values := make(map[string]Any, len(e.values))
+	for k, v := range e.values {
+		values[k] = v
+	}
+	e.mu.RUnlock()
+	for k, v := range values {
+		f(k, v)
 	}
-	if e.parent != nil {
-		e.parent.CheckInterrupted()
+}
+
+func (e *Env) IsTopLevel() bool {
+	return e.parent == nil || e.parent.parent == nil
+}
+
+func (e *Env) topLevel() *Env {
+	for !e.IsTopLevel() {
+		e = e.parent
 	}
+	return e
 }
 
 func ParseAndEval(input string, env *Env) (n Node, err error) {
@@ -99,78 +239,320 @@ func evalMultiple(nodes []Node, env *Env) []Node {
 	return results
 }
 
+// eval compiles node and runs the resulting code - see compile.go.
 func eval(node Node, env *Env) Node {
+	c := newCompiler(env)
+	code := c.compile(node, nil)
+	return run(code, newActivation(c.scope.level, nil, env))
+}
+
+// locals are the slots of the locals of an evaluation of a level (see compile.go) - and those of the
+// levels it is nested in via parent. env holds the globals (and state) the evaluation runs with.
+type locals struct {
+	slots  []Node
+	parent *locals
+	env    *Env
+	level  *level // set for activations - see release
+	*cursor
+	buffer [4]Node // holds the slots of small levels - saves an allocation per call
+	own    cursor
+}
+
+// cursor is the form a run (see run) is evaluating - errors are reported at it.
+// Locals of nested levels share the cursor of the run they are evaluated by.
+type cursor struct {
+	site   *site
+	callee Any
+	tail   tailCall // the tail call returned last - see call
+	args   [6]Node  // the args of calls to BorrowingFns - the ones of nested calls are pushed on top (up to sp)
+	sp     int
+}
+
+// site is a form that can fail. path holds the frames of the special forms and macros it is nested in.
+// If isCall is set, errors at the site are errors of its callee - which are reported with a frame of their own.
+type site struct {
+	node   Node
+	path   []Frame
+	isCall bool
+}
+
+// topLevelForm returns the outermost form of s - e.g. the defn form a def was expanded from.
+func (s *site) topLevelForm() Node {
+	if len(s.path) != 0 {
+		return s.path[0].Form
+	}
+	return s.node
+}
+
+// tailCall is a call in tail position: a call to the compiled fn fn (i.e. its code with the locals next)
+// or a recur (fn == nil). next is set for recurs that continue with fresh locals - see recurTarget.
+type tailCall struct {
+	fn   *function
+	code code
+	next *locals
+	site *site
+}
+
+var recurInPlace = &tailCall{}
+
+// newActivation returns the locals of a new evaluation of lvl - e.g. a call of a fn.
+func newActivation(lvl *level, parent *locals, env *Env) *locals {
+	l := activations.Get().(*locals)
+	l.level, l.parent, l.env = lvl, parent, env
+	l.slots, l.cursor = l.allocate(lvl.size), &l.own
+	return l
+}
+
+// Activations are reused once their run is done with them - unless closures or lazy seqs created in them refer to
+// them (see markCaptures).
+var activations = sync.Pool{New: func() any { return &locals{} }}
+
+// release returns the activation l for reuse. l must not be used afterwards.
+func (l *locals) release() {
+	if !l.level.captures.Load() {
+		*l = locals{}
+		activations.Put(l)
+	}
+}
+
+// newLocals returns the locals of the level lvl nested in the level of parent - e.g. a loop.
+func newLocals(lvl *level, parent *locals, env *Env) *locals {
+	l := &locals{parent: parent, env: env, cursor: parent.cursor}
+	l.slots = l.allocate(lvl.size)
+	return l
+}
+
+// clone returns a copy of l that can be modified without affecting l.
+func (l *locals) clone() *locals {
+	c := &locals{parent: l.parent, env: l.env, cursor: l.cursor}
+	c.slots = append(c.allocate(len(l.slots))[:0], l.slots...)
+	return c
+}
+
+// call returns the tail call of f with args. args are not retained.
+func (l *locals) call(f *function, args []Node, s *site) *tailCall {
+	a := f.arity(len(args))
+	l.tail = tailCall{fn: f, code: a.code, next: f.activate(a, args, l.env.state), site: s}
+	return &l.tail
+}
+
+func (l *locals) allocate(size int) []Node {
+	if size <= len(l.buffer) {
+		return l.buffer[:size:size]
+	}
+	return make([]Node, size)
+}
+
+// Tail calls are evaluated by the loop in run rather than by recursion. To keep stack traces useful
+// run remembers the last maxTailFrames calls it evaluated.
+const maxTailFrames = 16
+
+type hop struct {
+	site *site
+	fn   *function
+}
+
+// run evaluates c in l. Calls in tail position are evaluated in place: The code of their body
+// replaces c and their locals replace l. Activations are released once the run is done with them.
+func run(c code, l *locals) Node { return resume(c, nil, l) }
+
+// resume is run for code that has already been evaluated up to the tail call tc (if tc is not nil).
+// Errors are reported with the site of l - which is cleared afterwards so that enclosing runs on the
+// same cursor do not report it again.
+func resume(c code, tc *tailCall, l *locals) Node {
+	cur, state := l.cursor, l.env.state
+	owned := tc == nil // whether l is an activation of this run rather than the locals of the caller
+	if owned {
+		cur = nil // not shared with enclosing runs - and l may be released before the run returns
+	}
+	hops, n := [maxTailFrames]hop{}, 0
 	defer func() {
-		if err := recover(); err != nil {
-			panic(Error{node, errorf("%s", err)})
+		state.leave()
+		if x := recover(); x != nil {
+			frames, node := []Frame{}, Node(nil)
+			for i := max(0, n-maxTailFrames); i < n; i++ {
+				h := hops[i%maxTailFrames]
+				frames = append(append(frames, h.site.path...), newFrame(h.site.node.(ListNode), h.fn.self.(LiteralNode).Value))
+				node = h.site.node
+			}
+			err := errorAt(x, l.cursor, node, frames)
+			if cur != nil {
+				cur.site = nil
+			}
+			panic(err)
 		}
 	}()
-
+	state.enter()
 	for {
-		env.CheckInterrupted()
-		switch n := node.(type) {
-		case LiteralNode, KeywordNode:
-			return n
-		case SymbolNode:
-			if strings.HasPrefix(n.Value, ".") {
-				return LiteralNode{n}
-			}
-			vn, exists := env.Get(n.Value)
-			assert(exists, "could not lookup symbol %q", n.Value)
-			return vn
-		case VectorNode:
-			cns := make([]Node, len(n.Nodes))
-			for i, cn := range n.Nodes {
-				cns[i] = eval(cn, env)
-			}
-			return VectorNode{cns}
-		case ArrayMapNode:
-			m := map[Node]Node{}
-			for i := 0; i < len(n.Nodes); i += 2 {
-				m[eval(n.Nodes[i], env)] = eval(n.Nodes[i+1], env)
-			}
-			return MapNode{m}
-		case MapNode:
-			m := map[Node]Node{}
-			for k, v := range n.Nodes {
-				m[eval(k, env)] = eval(v, env)
-			}
-			return MapNode{m}
-		case ListNode:
-			if len(n.Nodes) == 0 {
-				return n
-			}
-			fln, ok := eval(n.Nodes[0], env).(LiteralNode)
-			assert(ok, "cannot use %s as a function", n.Nodes[0])
-			argns, isFinal := n.Nodes[1:], false
-			switch fn := fln.Value.(type) {
-			case SpecialFn:
-				node, env, isFinal = fn(argns, env)
-			case MacroFn:
-				node = fn(argns, env)
-			default:
-				node, env, isFinal = apply(fln, evalMultiple(argns, env), env)
+		var result Node
+		if tc == nil {
+			result, tc = c(l)
+		}
+		switch {
+		case tc == nil:
+			if owned {
+				l.release()
 			}
-			if isFinal {
-				return node
+			return result
+		case tc.fn == nil:
+			if tc.next != nil {
+				l, owned = tc.next, false // a copy of captured locals - see recurTarget
 			}
 		default:
-			panic(errorf("cannot eval node %s", n))
+			hops[n%maxTailFrames], n = hop{tc.site, tc.fn}, n+1
+			previous := l
+			c, l, tc.next = tc.code, tc.next, nil
+			if owned {
+				previous.release()
+			}
+			owned = true
+		}
+		tc = nil
+		l.env.state.step()
+	}
+}
+
+// errorAt wraps x with the site of cur (after frames). Forms created by macros have no position - the
+// position of the innermost frame that has one is used instead.
+func errorAt(x Any, cur *cursor, node Node, frames []Frame) Error {
+	if s := cur.site; s != nil {
+		node, frames = s.node, append(frames, s.path...)
+		if s.isCall {
+			frames = append(frames, newFrame(s.node.(ListNode), cur.callee))
+		}
+	}
+	err := wrapError(x, node, frames...)
+	for i := 0; i < len(err.Stack) && !err.Pos.IsValid(); i++ {
+		err.Pos = err.Stack[i].Pos
+	}
+	return err
+}
+
+// function is a closure (or macro) created by evaluating a fn form: the compiled form and the locals it closes over.
+type function struct {
+	*fnTemplate
+	parent *locals
+	self   Node
+}
+
+type fnTemplate struct {
+	name    string
+	env     *Env
+	isMacro bool
+	arities []*compiledArity
+	view    atomic.Pointer[Env] // env with the state of the last call - see envFor
+}
+
+type compiledArity struct {
+	arity
+	level  *level
+	params []int   // the slots of the params - unless they are destructured by bind
+	bind   *binder // destructures the args (as a vector) to the params
+	code   code
+	proto  *proto // set for arities assembled to bytecode - see bytecode.go
+}
+
+func newFunction(t *fnTemplate, parent *locals) *function {
+	f := &function{fnTemplate: t, parent: parent}
+	if t.isMacro {
+		f.self = LiteralNode{MacroFn(func(args []Node, env *Env) Node { return f.call(args, env.state) })}
+	} else {
+		f.self = LiteralNode{Closure{Name: t.name, fn: f, Fn: func(args []Node, env *Env) (Node, *Env, bool) {
+			return f.call(args, env.state), env, true
+		}}}
+	}
+	return f
+}
+
+// call calls f with args. The body is evaluated with the state of the caller rather than the one of the definition site.
+func (f *function) call(args []Node, state *evalState) Node {
+	a := f.arity(len(args))
+	return run(a.code, f.activate(a, args, state))
+}
+
+// arity returns the arity of f that accepts n args - fixed arities take precedence over the variadic one.
+func (f *function) arity(n int) *compiledArity {
+	var a *compiledArity
+	for _, a2 := range f.arities {
+		if a2.accepts(n) && (a == nil || !a2.variadic) {
+			a = a2
+		}
+	}
+	if a == nil { // not assert - its args would be boxed on every call
+		panic(errorf("wrong number of args (%d) passed to %s", n, f.name))
+	}
+	return a
+}
+
+func (f *function) activate(a *compiledArity, args []Node, state *evalState) *locals {
+	l := newActivation(a.level, f.parent, f.envFor(state))
+	if f.name != "_" {
+		l.slots[0] = f.self
+	}
+	a.bindArgs(l, args)
+	return l
+}
+
+// bindArgs binds args to the params of a in l. args are not retained.
+func (a *compiledArity) bindArgs(l *locals, args []Node) {
+	if a.bind != nil {
+		a.bind.bind(l, NewVector(args...))
+		return
+	}
+	for i, slot := range a.params {
+		if slot < 0 {
+			continue
+		} else if i == a.required {
+			l.slots[slot] = ListNode{Nodes: append([]Node{}, args[i:]...)}
+		} else {
+			l.slots[slot] = args[i]
 		}
 	}
 }
 
+// envFor returns a view of the env of t that evaluates with state.
+func (t *fnTemplate) envFor(state *evalState) *Env {
+	if t.env.state == state {
+		return t.env
+	} else if view := t.view.Load(); view != nil && view.state == state {
+		return view
+	}
+	view := t.env.withState(state)
+	t.view.Store(view)
+	return view
+}
+
+// Apply calls fn with args and returns the result. It panics on errors and must only be called
+// from Go fns that are called by gowen - e.g. to call fns passed as arguments.
+func Apply(fn Node, args []Node, env *Env) Node {
+	n, env, isFinal := apply(fn, args, env)
+	if !isFinal {
+		n = eval(n, env)
+	}
+	return n
+}
+
 func apply(n Node, argns []Node, env *Env) (Node, *Env, bool) {
 	fln, ok := n.(LiteralNode)
 	assert(ok, "cannot use %s as a function", n)
 	switch fn := fln.Value.(type) {
+	case Closure:
+		return fn.Fn(argns, env)
 	case ComplexFn:
 		n, env, isFinal := fn(argns, env)
 		return n, env, isFinal
 	case Fn:
 		n := fn(argns, env)
+		env.state.alloc(n)
+		return n, env, true
+	case BorrowingFn:
+		n := fn(argns, env)
+		env.state.alloc(n)
 		return n, env, true
 	default:
-		return applyInterop(fln, argns), env, true
+		checkPermitted(fln)
+		n := applyInterop(fln, argns, env)
+		env.state.alloc(n)
+		return n, env, true
 	}
 }
@@ -3,6 +3,7 @@ package gowen_test
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/niklasfasching/gowen"
@@ -24,6 +25,14 @@ var evalTests = []evalTest{
 	{"nested call", "(+ 1 (+ -1 (+ 1 -1)))", "0"},
 	{"fn & nested call", "(def foo (fn [x y] [(+ x 1) (+ y 1)])) (foo 1 2)", "[2 3]"},
 	{"env shadowing", "((fn [x y] ((fn [y z] (+ x (+ y z))) 2 2)) 1 1)", "5"},
+	{"closures of released activations", "(defn make [x] (fn [] x)) (defn call [f] (f)) [(call (make 1)) (call (make 2))]", "[1 2]"},
+	{"borrowed args", "(+ 1 (* 2 (- 10 (+ 1 1 1 1 1 1 1))) (count [1 2]))", "9"},
+	{"borrowed args of caught errors", `(+ 1 (try (+ 1 (throw "boom")) (catch e 2)) 3)`, "6"},
+	{"caught errors", `(defn f [] (throw "boom"))
+                       (try (f) (catch e [(ex-message e) (= (ex-form e) '(throw "boom")) (ex-pos e) (vec (map (fn [f] [(get f :name) (get f :kind)]) (ex-stack e)))]))`,
+		`["boom" true "1:12" [["throw" :interop] ["f" :fn]]]`},
+	{"errors of go blocks", `(let [e (<! (go (strings/repeat "x" -1)))] [(ex-message e) (= (ex-form e) '(strings/repeat "x" -1)) (count (ex-stack e))])`,
+		`["strings: negative Repeat count" true 1]`},
 }
 
 func TestEval(t *testing.T) {
@@ -34,6 +43,13 @@ func TestEval(t *testing.T) {
 	}
 }
 
+func TestCaughtErrorString(t *testing.T) {
+	n, err := gowen.ParseAndEval(`[(try (throw "boom") (catch e e))]`, gowen.NewEnv(false))
+	if expected := `[#<error 1:7: boom: (throw "boom")>]`; err != nil || n.String() != expected {
+		t.Errorf("got %v (%v) expected %s", n, err, expected)
+	}
+}
+
 func compare(input string, expected string) error {
 	env := gowen.NewEnv(false)
 	inputNodes, err := parseAndEval(input, env)
@@ -59,3 +75,138 @@ func parseAndEval(input string, env *gowen.Env) (nodes []gowen.Node, err error)
 	}
 	return gowen.EvalMultiple(nodes, env)
 }
+
+type evalErrorTest struct {
+	name  string
+	input string
+	err   string
+}
+
+var evalErrorTests = []evalErrorTest{
+	{"unknown symbol", "(def x 1)\n(+ x\n   y)", `test.gow:3:4: could not lookup symbol "y": y`},
+	{"nested call", "[1\n (+ 1 (foo))]", `test.gow:2:8: could not lookup symbol "foo": foo`},
+	{"recur in non-tail position", "(loop [i 0]\n  (+ 1 (recur i)))", `test.gow:1:1: recur must be called in tail position: (recur i): (loop [i 0] (+ 1 (recur i)))`},
+	{"recur across try", "(fn [x] (try (recur x) (catch e e)))", `test.gow:1:1: recur must be called in tail position: (recur x): (fn [x] (try (recur x) (catch e e)))`},
+	{"recur outside loop", "(recur 1)", `test.gow:1:1: recur must be called from inside loop or fn: (recur 1)`},
+	{"too many args", "(defn foo [x] x)\n(foo 1 2 3)", `test.gow:2:1: wrong number of args (3) passed to foo: (foo 1 2 3)`},
+	{"too few args", "(defn foo ([x] x) ([x y & zs] x))\n(foo 1 2 3)\n(foo)", `test.gow:3:1: wrong number of args (0) passed to foo: (foo)`},
+	{"ambiguous arities", "(fn ([x] x) ([y] y))", `test.gow:1:1: fn must not have two arities with 1 params: (fn ([x] x) ([y] y))`},
+	{"no position", "(eval (list 'undefined))", `test.gow:1:1: could not lookup symbol "undefined": undefined`},
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, test := range evalErrorTests {
+		nodes, err := gowen.ParseFile("test.gow", test.input)
+		if err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		_, err = gowen.EvalMultiple(nodes, gowen.NewEnv(false))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, err, test.err)
+		}
+	}
+}
+
+type stackTest struct {
+	name   string
+	input  string
+	frames []string
+}
+
+var stackTests = []stackTest{
+	{"fn & interop", `(defn g [x] (throw "boom"))
+(defn f [x] (+ 1 (g x)))
+(f 1)`, []string{
+		"throw [interop] test.gow:1:13",
+		"g [fn] test.gow:2:18",
+		"f [fn] test.gow:3:1",
+	}},
+	{"special & anonymous fn", `(if true
+  ((fn [] (throw "boom"))))`, []string{
+		"throw [interop] test.gow:2:11",
+		"_ [fn] test.gow:2:3",
+		"if [special] test.gow:1:1",
+	}},
+	{"macro", `(defmacro m [] (throw "boom"))
+(m)`, []string{
+		"throw [interop] test.gow:1:16",
+		"m [macro] test.gow:2:1",
+	}},
+}
+
+func TestStack(t *testing.T) {
+	for _, test := range stackTests {
+		nodes, err := gowen.ParseFile("test.gow", test.input)
+		if err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		_, err = gowen.EvalMultiple(nodes, gowen.NewEnv(false))
+		gerr, ok := err.(gowen.Error)
+		if !ok {
+			t.Errorf("%s: got %#v", test.name, err)
+			continue
+		}
+		frames := []string{}
+		for _, f := range gerr.Stack {
+			frames = append(frames, fmt.Sprintf("%s [%s] %s", f.Name, f.Kind, f.Pos))
+		}
+		if !reflect.DeepEqual(frames, test.frames) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, frames, test.frames)
+		}
+	}
+}
+
+func TestEnvConcurrentDefs(t *testing.T) {
+	env := gowen.NewEnv(false)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := fmt.Sprintf(`(def x%d %d) (<! (go (+ x%d 1)))`, i, i, i)
+			if n, err := gowen.ParseAndEval(input, env); err != nil || n.ToGo() != int64(i+1) {
+				t.Errorf("%s: got %v (%v)", input, n, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+type benchmark struct {
+	name  string
+	setup string
+	input string
+}
+
+var benchmarks = []benchmark{
+	{"reduce", "", `(reduce + 0 (repeat 10000 1))`},
+	{"fib", `(defn fib [n] (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))`, `(fib 20)`},
+	{"loop", "", `(loop [i 0 acc 0] (if (< i 10000) (recur (+ i 1) (+ acc i)) acc))`},
+	{"closures", `(defn adder [x] (fn [y] (+ x y)))`, `(reduce (fn [acc f] (f acc)) 0 (map adder (range 1000)))`},
+	{"destructure", `(defn swap [[a b]] [b a])`, `(loop [i 0 v [1 2]] (if (< i 1000) (recur (+ i 1) (swap v)) v))`},
+	{"calls", `(defn id [x] x)`, `(loop [i 0] (if (< i 10000) (recur (id (id (id (+ i 1))))) i))`},
+	{"locals", "", `(let [a 1 b 2 c 3 d 4] (loop [i 0] (let [e a f b g c h d] (if (< i 10000) (recur (+ i e)) [i f g h]))))`},
+}
+
+func BenchmarkEval(b *testing.B) {
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			env := gowen.NewEnv(false)
+			if _, err := gowen.ParseAndEval(bm.setup+" nil", env); err != nil {
+				b.Fatal(err)
+			}
+			nodes, err := gowen.Parse(bm.input)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := gowen.EvalMultiple(nodes, env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package gowen
+
+import "math/bits"
+
+// hamt is a persistent hash array mapped trie (see clojure.lang.PersistentHashMap). Each level consumes 5 bits
+// of the hash of a key - entries whose hashes are equal are kept in a collision node at the bottom.
+// Updates copy the path to the changed entry and share everything else - assoc, dissoc and get are O(log32 n).
+// Nodes are kept in a canonical shape (sub nodes with a single entry are collapsed) - maps with equal entries
+// are structurally equal unless keys with equal hashes were added in different orders.
+type hamt struct {
+	count int
+	root  *hamtNode
+}
+
+type hamtNode struct {
+	bitmap    uint32
+	entries   []hamtEntry // ordered by bit position - or by insertion for collision nodes
+	collision bool
+}
+
+// hamtEntry is either a key value pair or (if node is set) a sub node.
+type hamtEntry struct {
+	key, value Node
+	node       *hamtNode
+}
+
+const hamtBits = 5
+
+func (m *hamt) get(k Node) (Node, bool) {
+	if m == nil {
+		return nil, false
+	}
+	return m.root.get(k, k.Hash(), 0)
+}
+
+func (m *hamt) assoc(k, v Node) *hamt {
+	if m == nil {
+		m = &hamt{root: &hamtNode{}}
+	}
+	root, added := m.root.assoc(k, v, k.Hash(), 0)
+	count := m.count
+	if added {
+		count++
+	}
+	return &hamt{count, root}
+}
+
+func (m *hamt) dissoc(k Node) *hamt {
+	if m == nil {
+		return nil
+	}
+	root, removed := m.root.dissoc(k, k.Hash(), 0)
+	if !removed {
+		return m
+	} else if m.count == 1 {
+		return nil
+	}
+	return &hamt{m.count - 1, root}
+}
+
+func (m *hamt) each(f func(k, v Node)) {
+	if m != nil {
+		m.root.each(f)
+	}
+}
+
+func (node *hamtNode) index(hash uint32, shift uint) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & 31)
+	return bit, bits.OnesCount32(node.bitmap & (bit - 1))
+}
+
+func (node *hamtNode) get(k Node, hash uint32, shift uint) (Node, bool) {
+	if node.collision {
+		for _, e := range node.entries {
+			if e.key.Equal(k) {
+				return e.value, true
+			}
+		}
+		return nil, false
+	}
+	bit, i := node.index(hash, shift)
+	if node.bitmap&bit == 0 {
+		return nil, false
+	} else if e := node.entries[i]; e.node != nil {
+		return e.node.get(k, hash, shift+hamtBits)
+	} else if e.key.Equal(k) {
+		return e.value, true
+	}
+	return nil, false
+}
+
+func (node *hamtNode) assoc(k, v Node, hash uint32, shift uint) (*hamtNode, bool) {
+	if node.collision {
+		for i, e := range node.entries {
+			if e.key.Equal(k) {
+				return node.withEntry(i, hamtEntry{key: k, value: v}), false
+			}
+		}
+		entries := append(node.entries[:len(node.entries):len(node.entries)], hamtEntry{key: k, value: v})
+		return &hamtNode{entries: entries, collision: true}, true
+	}
+	bit, i := node.index(hash, shift)
+	if node.bitmap&bit == 0 {
+		entries := make([]hamtEntry, 0, len(node.entries)+1)
+		entries = append(append(append(entries, node.entries[:i]...), hamtEntry{key: k, value: v}), node.entries[i:]...)
+		return &hamtNode{bitmap: node.bitmap | bit, entries: entries}, true
+	}
+	e := node.entries[i]
+	if e.node != nil {
+		child, added := e.node.assoc(k, v, hash, shift+hamtBits)
+		return node.withEntry(i, hamtEntry{node: child}), added
+	} else if e.key.Equal(k) {
+		return node.withEntry(i, hamtEntry{key: k, value: v}), false
+	}
+	child := newHamtNode(e.key, e.value, e.key.Hash(), k, v, hash, shift+hamtBits)
+	return node.withEntry(i, hamtEntry{node: child}), true
+}
+
+func newHamtNode(k1, v1 Node, hash1 uint32, k2, v2 Node, hash2 uint32, shift uint) *hamtNode {
+	if hash1 == hash2 || shift >= 32 {
+		return &hamtNode{entries: []hamtEntry{{key: k1, value: v1}, {key: k2, value: v2}}, collision: true}
+	}
+	bit1, bit2 := uint32(1)<<((hash1>>shift)&31), uint32(1)<<((hash2>>shift)&31)
+	if bit1 == bit2 {
+		return &hamtNode{bitmap: bit1, entries: []hamtEntry{{node: newHamtNode(k1, v1, hash1, k2, v2, hash2, shift+hamtBits)}}}
+	} else if bit1 > bit2 {
+		k1, v1, k2, v2, bit1, bit2 = k2, v2, k1, v1, bit2, bit1
+	}
+	return &hamtNode{bitmap: bit1 | bit2, entries: []hamtEntry{{key: k1, value: v1}, {key: k2, value: v2}}}
+}
+
+// dissoc returns a copy of node without k. Sub nodes that are left with a single key value pair are replaced by it.
+func (node *hamtNode) dissoc(k Node, hash uint32, shift uint) (*hamtNode, bool) {
+	if node.collision {
+		for i, e := range node.entries {
+			if e.key.Equal(k) {
+				entries := append(append([]hamtEntry(nil), node.entries[:i]...), node.entries[i+1:]...)
+				return &hamtNode{entries: entries, collision: true}, true
+			}
+		}
+		return node, false
+	}
+	bit, i := node.index(hash, shift)
+	if node.bitmap&bit == 0 {
+		return node, false
+	}
+	e := node.entries[i]
+	if e.node == nil {
+		if !e.key.Equal(k) {
+			return node, false
+		}
+		entries := append(append([]hamtEntry(nil), node.entries[:i]...), node.entries[i+1:]...)
+		return &hamtNode{bitmap: node.bitmap &^ bit, entries: entries}, true
+	}
+	child, removed := e.node.dissoc(k, hash, shift+hamtBits)
+	if !removed {
+		return node, false
+	} else if len(child.entries) == 1 && child.entries[0].node == nil {
+		return node.withEntry(i, child.entries[0]), true
+	}
+	return node.withEntry(i, hamtEntry{node: child}), true
+}
+
+func (node *hamtNode) withEntry(i int, e hamtEntry) *hamtNode {
+	entries := append([]hamtEntry(nil), node.entries...)
+	entries[i] = e
+	return &hamtNode{bitmap: node.bitmap, entries: entries, collision: node.collision}
+}
+
+func (node *hamtNode) each(f func(k, v Node)) {
+	for _, e := range node.entries {
+		if e.node != nil {
+			e.node.each(f)
+		} else {
+			f(e.key, e.value)
+		}
+	}
+}
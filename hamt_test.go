@@ -0,0 +1,54 @@
+package gowen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHamt(t *testing.T) {
+	const size = 5000
+	m, ms := MapNode{}, []MapNode{}
+	for i := 0; i < size; i++ {
+		ms = append(ms, m)
+		m = m.Assoc(LiteralNode{int64(i)}, LiteralNode{i})
+	}
+	for i, m2 := range ms {
+		if m2.Count() != i {
+			t.Errorf("assoc modified previous map: %d has %d entries", i, m2.Count())
+		}
+	}
+	for i := 0; i < size; i++ {
+		if v, ok := m.Lookup(LiteralNode{int64(i)}); !ok || v != (LiteralNode{i}) {
+			t.Errorf("lookup %d: got %v %v", i, v, ok)
+		}
+	}
+	if _, ok := m.Lookup(LiteralNode{"missing"}); ok {
+		t.Errorf("lookup of missing key succeeded")
+	}
+	m2 := m
+	for i := size - 1; i >= size/2; i-- {
+		m2 = m2.Dissoc(LiteralNode{int64(i)})
+	}
+	if m.Count() != size || !reflect.DeepEqual(m2, ms[size/2]) {
+		t.Errorf("dissoc: got %d entries - expected map equal to one built with assoc", m2.Count())
+	}
+	if m3 := m.Assoc(LiteralNode{int64(0)}, LiteralNode{"x"}); m3.Count() != size || m3.Get(LiteralNode{int64(0)}) != (LiteralNode{"x"}) {
+		t.Errorf("assoc existing key: got %d entries", m3.Count())
+	}
+}
+
+func TestHamtCollisions(t *testing.T) {
+	a, b, c := KeywordNode{"k7194"}, KeywordNode{"k201020"}, KeywordNode{"c"}
+	if a.Hash() != b.Hash() {
+		t.Fatalf("expected hashes of %s and %s to collide", a, b)
+	}
+	m := NewMap(a, LiteralNode{1}, b, LiteralNode{2}, c, LiteralNode{3})
+	for k, v := range map[Node]Node{a: LiteralNode{1}, b: LiteralNode{2}, c: LiteralNode{3}} {
+		if v2, ok := m.Lookup(k); !ok || v2 != v {
+			t.Errorf("lookup %s: got %v %v", k, v2, ok)
+		}
+	}
+	if m2 := m.Dissoc(a); m2.Count() != 2 || !reflect.DeepEqual(m2, NewMap(c, LiteralNode{3}, b, LiteralNode{2})) {
+		t.Errorf("dissoc did not collapse collision node: %v", m2)
+	}
+}
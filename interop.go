@@ -1,21 +1,70 @@
 package gowen
 
+// Go values are called via reflection. The decisions how to convert arguments for the params of a fn type and
+// how to resolve the members of a type are made once and cached as plans. Go fns that have an adapter
+// registered (see RegisterAdapter) are called without reflection.
+
 import (
 	"reflect"
 	"strings"
+	"sync"
 )
 
 type Any = interface{}
 
-func applyInterop(fln LiteralNode, argns []Node) Node {
-	var retvs []reflect.Value
+// funcPlan holds the converters for the args of calls to fns of a type.
+type funcPlan struct {
+	typ      reflect.Type
+	params   []converter
+	variadic converter // converter for the elements of the variadic param - nil for non-variadic fns
+}
+
+// memberPlan holds how the member name of a type is accessed - methods are called via their index in the
+// method set of the type (or of the pointer to it). Fields are read via their index.
+type memberPlan struct {
+	name    string
+	method  int
+	pointer bool
+	field   []int
+	fn      *funcPlan
+}
+
+type memberKey struct {
+	typ  reflect.Type
+	name string
+}
+
+// converter converts an arg to a reflect.Value that can be passed for a param - see reflectArg. gowen fns are
+// converted to Go funcs that call them in env - see reflectFn.
+type converter func(arg Any, env *Env) reflect.Value
+
+var funcPlans, memberPlans sync.Map
+var adapters = map[uintptr]func([]Node) Node{}
+
+// RegisterAdapter registers call to be used instead of reflection for calls of the Go fn f from gowen. call must
+// convert args and results like calls via reflection do - see Arg, Args and Result. Adapters are identified
+// by the code pointer of f, i.e. f must be a top-level fn. RegisterAdapter must only be called from init.
+func RegisterAdapter(f Any, call func(args []Node) Node) {
+	v := reflect.ValueOf(f)
+	assert(v.Kind() == reflect.Func, "cannot register adapter for %T", f)
+	adapters[v.Pointer()] = call
+}
+
+func applyInterop(fln LiteralNode, argns []Node, env *Env) Node {
 	if sn, ok := fln.Value.(SymbolNode); ok {
-		retvs = applyMemberInterop(sn, argns)
-	} else {
-		fnv := reflect.ValueOf(fln.Value)
-		fnt := fnv.Type()
-		retvs = fnv.Call(reflectArgs(fnt, argns))
+		return applyMemberInterop(sn, argns, env)
 	}
+	fnv := reflect.ValueOf(fln.Value)
+	if fnv.Kind() != reflect.Func {
+		panic(errorf("cannot use %s as a function", fln))
+	}
+	if call, ok := adapters[fnv.Pointer()]; ok {
+		return call(argns)
+	}
+	return interopResult(fnv.Call(planFunc(fnv.Type()).args(argns, env)))
+}
+
+func interopResult(retvs []reflect.Value) Node {
 	switch len(retvs) {
 	case 0:
 		return LiteralNode{nil}
@@ -34,52 +83,189 @@ func applyInterop(fln LiteralNode, argns []Node) Node {
 	}
 }
 
-func applyMemberInterop(sn SymbolNode, argns []Node) []reflect.Value {
-	it := reflect.ValueOf(argns[0].(LiteralNode).Value)
-	itPointer := it
-	if it.Kind() == reflect.Ptr {
-		it = it.Elem()
-	} else {
-		itPointer = reflect.New(it.Type())
+func applyMemberInterop(sn SymbolNode, argns []Node, env *Env) Node {
+	if len(argns) == 0 {
+		panic(errorf("member interop: %s needs an object", sn))
+	}
+	ln, ok := argns[0].(LiteralNode)
+	if !ok || ln.Value == nil {
+		panic(errorf("member interop: cannot access %s of %s", sn, argns[0]))
+	}
+	it := reflect.ValueOf(ln.Value)
+	p := planMember(it.Type(), sn.Value)
+	if p.field != nil {
+		return interopResult([]reflect.Value{reflect.Indirect(it).FieldByIndex(p.field)})
+	}
+	if p.pointer && it.Kind() != reflect.Ptr {
+		itPointer := reflect.New(it.Type())
 		itPointer.Elem().Set(it)
+		it = itPointer
 	}
-	argns = argns[1:]
-	name := strings.Title(sn.Value[1:])
-	method := it.MethodByName(name)
-	if !method.IsValid() {
-		method = itPointer.MethodByName(name)
+	return interopResult(it.Method(p.method).Call(p.fn.args(argns[1:], env)))
+}
+
+func planFunc(t reflect.Type) *funcPlan {
+	if p, ok := funcPlans.Load(t); ok {
+		return p.(*funcPlan)
 	}
-	if method.IsValid() {
-		return method.Call(reflectArgs(method.Type(), argns))
+	p, n := &funcPlan{typ: t}, t.NumIn()
+	if t.IsVariadic() {
+		n--
+		p.variadic = converterFor(t.In(n).Elem())
 	}
-	if field := it.FieldByName(name); field.IsValid() {
-		return []reflect.Value{field}
-	} else if field = itPointer.MethodByName(name); field.IsValid() {
-		return []reflect.Value{field}
+	for i := 0; i < n; i++ {
+		p.params = append(p.params, converterFor(t.In(i)))
 	}
-	panic(errorf("member interop: %s is not a member of %s (%s)", name, it.Type(), argns))
+	funcPlans.Store(t, p)
+	return p
 }
 
-func reflectArgs(fnt reflect.Type, argns []Node) []reflect.Value {
+func planMember(t reflect.Type, symbol string) *memberPlan {
+	key := memberKey{t, symbol}
+	if p, ok := memberPlans.Load(key); ok {
+		return p.(*memberPlan)
+	}
+	p := &memberPlan{name: strings.Title(symbol[1:])}
+	elem, pointer := t, reflect.PtrTo(t)
+	if t.Kind() == reflect.Ptr {
+		elem, pointer = t.Elem(), t
+	}
+	if m, ok := elem.MethodByName(p.name); ok && t != pointer {
+		p.method, p.fn = m.Index, planFunc(reflect.Zero(t).Method(m.Index).Type())
+	} else if m, ok := pointer.MethodByName(p.name); ok {
+		p.method, p.pointer, p.fn = m.Index, true, planFunc(reflect.Zero(pointer).Method(m.Index).Type())
+	} else if f, ok := elem.FieldByName(p.name); ok && elem.Kind() == reflect.Struct && f.IsExported() {
+		p.field = f.Index
+	} else {
+		panic(errorf("member interop: %s is not a member of %s", p.name, elem))
+	}
+	memberPlans.Store(key, p)
+	return p
+}
+
+func (p *funcPlan) args(argns []Node, env *Env) []reflect.Value {
+	n := len(p.params)
+	if len(argns) != n && (len(argns) < n || p.variadic == nil) {
+		panic(arityError(len(argns), p.typ))
+	}
 	argvs := make([]reflect.Value, len(argns))
 	for i, argn := range argns {
-		if n := fnt.NumIn(); fnt.IsVariadic() && i >= n-1 {
-			argvs[i] = reflectArg(argn.ToGo(), fnt.In(n-1).Elem())
+		if i < n {
+			argvs[i] = p.params[i](argn.ToGo(), env)
 		} else {
-			argvs[i] = reflectArg(argn.ToGo(), fnt.In(i))
+			argvs[i] = p.variadic(argn.ToGo(), env)
 		}
 	}
 	return argvs
 }
 
+// converterFor returns the converter for paramType. Args of the type of the param are passed as is - and
+// numbers are converted directly if the param is a number.
+func converterFor(paramType reflect.Type) converter {
+	switch k := paramType.Kind(); {
+	case k == reflect.Interface && paramType.NumMethod() == 0:
+		return func(arg Any, _ *Env) reflect.Value {
+			if arg == nil {
+				return reflect.Zero(paramType)
+			}
+			return reflect.ValueOf(arg)
+		}
+	case k >= reflect.Int && k <= reflect.Float64:
+		return func(arg Any, _ *Env) reflect.Value {
+			if v, ok := numberArg(arg, paramType); ok {
+				return v
+			}
+			return reflectArg(arg, paramType)
+		}
+	case k == reflect.Func:
+		return func(arg Any, env *Env) reflect.Value {
+			if isFn(arg) && !reflect.TypeOf(arg).AssignableTo(paramType) {
+				return reflectFn(LiteralNode{arg}, paramType, env)
+			}
+			return reflectArg(arg, paramType)
+		}
+	default:
+		return func(arg Any, _ *Env) reflect.Value {
+			if arg != nil && reflect.TypeOf(arg) == paramType {
+				return reflect.ValueOf(arg)
+			}
+			return reflectArg(arg, paramType)
+		}
+	}
+}
+
+// CheckArgs panics unless args holds n args for fn - or at least n args if fn is variadic. It is used by adapters.
+func CheckArgs(fn Any, args []Node, n int, variadic bool) {
+	if len(args) != n && (len(args) < n || !variadic) {
+		panic(arityError(len(args), reflect.TypeOf(fn)))
+	}
+}
+
+// arityError returns the error for calls of the Go fn of type fnType with n args - calls via adapters and via
+// reflection fail the same way.
+func arityError(n int, fnType reflect.Type) error {
+	return errorf("wrong number of args (%d) passed to %s", n, fnType)
+}
+
+// Arg converts the arg n to T like args of calls via reflection are converted. It is used by adapters.
+func Arg[T any](n Node) T {
+	x := n.ToGo()
+	v, ok := x.(T)
+	if ok {
+		return v
+	}
+	switch p := any(&v).(type) {
+	case *int:
+		if i, ok := x.(int64); ok {
+			*p = int(i)
+			return v
+		}
+	case *float64:
+		if i, ok := x.(int64); ok {
+			*p = float64(i)
+			return v
+		}
+	}
+	t := reflect.TypeOf(&v).Elem()
+	v, ok = reflectArg(x, t).Interface().(T)
+	if !ok && (x != nil || !isNillable(t)) {
+		panic(errorf("cannot use %s as %s", n, t))
+	}
+	return v
+}
+
+// Args converts the args ns to T - see Arg. It is used by adapters for the variadic params of Go fns.
+func Args[T any](ns []Node) []T {
+	xs := make([]T, len(ns))
+	for i, n := range ns {
+		xs[i] = Arg[T](n)
+	}
+	return xs
+}
+
+// Result returns v as a node and panics if err is not nil - like calls via reflection do. It is used by adapters.
+func Result(v Any, err error) Node {
+	assert(err == nil, "call returned err: %s", err)
+	return ToNode(v)
+}
+
+func isNillable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func, reflect.Chan, reflect.Ptr, reflect.Interface:
+		return true
+	}
+	return false
+}
+
 func reflectArg(arg Any, paramType reflect.Type) reflect.Value {
 	if arg == nil {
-		switch paramType.Kind() {
-		case reflect.Slice, reflect.Map, reflect.Func, reflect.Chan, reflect.Ptr, reflect.Interface:
+		if isNillable(paramType) {
 			return reflect.Zero(paramType)
-		default:
-			return reflect.ValueOf((*Any)(nil))
 		}
+		return reflect.ValueOf((*Any)(nil))
+	}
+	if v, ok := numberArg(arg, paramType); ok {
+		return v
 	}
 	argValue := reflect.ValueOf(arg)
 	argType := argValue.Type()
@@ -108,7 +294,7 @@ func reflectArg(arg Any, paramType reflect.Type) reflect.Value {
 		mValueType := paramType.Elem()
 		mKeyType := paramType.Key()
 		for _, k := range argValue.MapKeys() {
-			m.SetMapIndex(k.Elem().Convert(mKeyType), argValue.MapIndex(k).Elem().Convert(mValueType))
+			m.SetMapIndex(interfaceElem(k).Convert(mKeyType), interfaceElem(argValue.MapIndex(k)).Convert(mValueType))
 		}
 		return m
 	default:
@@ -116,6 +302,14 @@ func reflectArg(arg Any, paramType reflect.Type) reflect.Value {
 	}
 }
 
+// interfaceElem returns the value v contains if v is an interface - e.g. the keys of map[Any]Any.
+func interfaceElem(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		return v.Elem()
+	}
+	return v
+}
+
 func ToGo(x Any) Any {
 	switch x := x.(type) {
 	case Node:
@@ -125,11 +319,15 @@ func ToGo(x Any) Any {
 	}
 }
 
+// ToNode wraps x in a LiteralNode - Go sets (map[T]struct{}) are converted to SetNode.
 func ToNode(x Any) Node {
 	switch x := x.(type) {
 	case Node:
 		return x
 	default:
-		return LiteralNode{x}
+		if s, ok := toSet(LiteralNode{x}); ok {
+			return s
+		}
+		return LiteralNode{normalizeNumber(x)}
 	}
 }
@@ -1,8 +1,12 @@
 package gowen
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type applyInteropTest struct {
@@ -86,6 +90,11 @@ var applyInteropTests = []applyInteropTest{
 		[]Any{map[Any]Any{1: "bar"}},
 		map[int]string{1: "bar"},
 	},
+	{"convert (set -> map[string]struct{})",
+		func(xs map[string]struct{}) Any { return xs },
+		[]Any{NewSet(LiteralNode{"foo"})},
+		map[string]struct{}{"foo": {}},
+	},
 }
 
 func TestApplyInterop(t *testing.T) {
@@ -94,7 +103,7 @@ func TestApplyInterop(t *testing.T) {
 		for i, arg := range test.args {
 			argns[i] = ToNode(arg)
 		}
-		output := applyInterop(LiteralNode{test.fn}, argns)
+		output := applyInterop(LiteralNode{test.fn}, argns, NewEnv(false))
 		expected := ToNode(test.output)
 		if !reflect.DeepEqual(output, expected) {
 			t.Errorf("%s: got\n\t%#v\nexpected\n\t%#v", test.name, output, expected)
@@ -121,12 +130,12 @@ var applyMemberInteropTests = []applyMemberInteropTest{
 	{"zero value",
 		LiteralNode{applyMemberExample{}},
 		"[(.valueMethod it 1) (.pointerMethod it 1) (.value it) (.pointer it)]",
-		[]Any{1, 1, "", (*string)(nil)},
+		[]Any{int64(1), int64(1), "", (*string)(nil)},
 	},
 	{"value",
 		LiteralNode{applyMemberExample{"foo", new(string)}},
 		"[(.valueMethod it 1) (.pointerMethod it 1) (.value it) (.pointer it)]",
-		[]Any{1, 1, "foo", new(string)},
+		[]Any{int64(1), int64(1), "foo", new(string)},
 	},
 }
 
@@ -141,3 +150,150 @@ func TestApplyMemberInterop(t *testing.T) {
 		}
 	}
 }
+
+type interopErrorTest struct {
+	name  string
+	input string
+	err   string
+}
+
+var interopErrorTests = []interopErrorTest{
+	{"too few args", "(repeat-reflected \"a\")", "wrong number of args (1) passed to func(string, int) string"},
+	{"too many args", "(repeat-reflected \"a\" 1 2)", "wrong number of args (3) passed to func(string, int) string"},
+	{"not a fn", "(go-value 1)", "cannot use struct {}{} as a function"},
+	{"not a member", "(.missing it)", "member interop: Missing is not a member of gowen.applyMemberExample"},
+	{"member of nil", "(.value nil)", "member interop: cannot access .value of <nil>"},
+	{"adapter: too few args", "(repeat-adapted \"a\")", "wrong number of args (1) passed to func(string, int) string"},
+	{"adapter: invalid arg", "(repeat-adapted \"a\" nil)", "cannot use <nil> as int"},
+	{"callback: panic", "(call (fn [] (fail)))", "boom"},
+	{"callback: invalid result", `(call-int (fn [x] "x") 1)`, `cannot return "x" as int`},
+	{"callback: too few results", `(call-pair (fn [] [1]))`, "cannot return [1] from func() (int, string): expected 2 values"},
+	{"reify: not an interface", "(reify 1 {})", "reify: 1 is not an interface type"},
+}
+
+func TestApplyInteropErrors(t *testing.T) {
+	for _, test := range interopErrorTests {
+		env := NewEnv(false)
+		env.Set("repeat-reflected", LiteralNode{repeatReflected})
+		env.Set("repeat-adapted", LiteralNode{repeatAdapted})
+		env.Set("go-value", LiteralNode{struct{}{}})
+		env.Set("it", LiteralNode{applyMemberExample{}})
+		for k, v := range callbackValues {
+			env.Set(k, LiteralNode{v})
+		}
+		_, err := ParseAndEval(test.input, env)
+		if err == nil || !strings.HasSuffix(err.(Error).Err.Error(), test.err) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, err, test.err)
+		}
+	}
+}
+
+func repeatReflected(s string, n int) string { return strings.Repeat(s, n) }
+func repeatAdapted(s string, n int) string   { return strings.Repeat(s, n) }
+
+var adapterCalls = 0
+
+func init() {
+	RegisterAdapter(repeatAdapted, func(args []Node) Node {
+		adapterCalls++
+		CheckArgs(repeatAdapted, args, 2, false)
+		return ToNode(repeatAdapted(Arg[string](args[0]), Arg[int](args[1])))
+	})
+}
+
+func TestAdapter(t *testing.T) {
+	env := NewEnv(false)
+	env.Set("repeat-adapted", LiteralNode{repeatAdapted})
+	calls := adapterCalls
+	if n, err := ParseAndEval(`(repeat-adapted "a" 3)`, env); err != nil || n.ToGo() != "aaa" || adapterCalls != calls+1 {
+		t.Errorf("expected call via adapter: got %v (%v, %d calls)", n, err, adapterCalls-calls)
+	}
+}
+
+type argTest struct {
+	name     string
+	convert  func(Node) Any
+	input    Any
+	expected Any
+}
+
+var argTests = []argTest{
+	{"string", func(n Node) Any { return Arg[string](n) }, "foo", "foo"},
+	{"int64 -> int", func(n Node) Any { return Arg[int](n) }, 1, 1},
+	{"int64 -> float64", func(n Node) Any { return Arg[float64](n) }, 1, 1.0},
+	{"int64 -> time.Duration", func(n Node) Any { return Arg[time.Duration](n) }, 1, time.Duration(1)},
+	{"nil -> []string", func(n Node) Any { return Arg[[]string](n) }, nil, []string(nil)},
+	{"nil -> error", func(n Node) Any { return Arg[error](n) }, nil, nil},
+	{"[]Any -> []string", func(n Node) Any { return Arg[[]string](n) }, []Any{"a", "b"}, []string{"a", "b"}},
+	{"variadic", func(n Node) Any { return Args[int](n.Seq()) }, NewVector(LiteralNode{1}, LiteralNode{2.0}), []int{1, 2}},
+}
+
+func TestArg(t *testing.T) {
+	for _, test := range argTests {
+		if result := test.convert(ToNode(test.input)); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: got\n\t%#v\nexpected\n\t%#v", test.name, result, test.expected)
+		}
+	}
+}
+
+func BenchmarkInterop(b *testing.B) {
+	for name, f := range map[string]Any{"reflect": repeatReflected, "adapter": repeatAdapted} {
+		b.Run(name, func(b *testing.B) {
+			env := NewEnv(false)
+			env.Set("f", LiteralNode{f})
+			env.Set("it", LiteralNode{applyMemberExample{}})
+			nodes := parse(`(loop [i 0] (if (< i 1000) (do (f "a" 2) (.valueMethod it 1) (recur (+ i 1))) i))`)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := EvalMultiple(nodes, env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type callbackTest struct {
+	name     string
+	input    string
+	expected Any
+}
+
+var callbackTests = []callbackTest{
+	{"args & result", "(call-int (fn [x] (* x 2)) 21)", int64(42)},
+	{"variadic", "(call-variadic (fn [& xs] xs))", []Any{"a", "b"}},
+	{"multiple results", `(call-pair (fn [] [1 "a"]))`, "1 a"},
+	{"error result", "(call-error (fn [] (fail)))", "boom"},
+	{"no error", "(call-error (fn [] nil))", nil},
+	{"builtin fn", "(call-int (get-fn) 21)", int64(21)},
+}
+
+var callbackValues = map[string]Any{
+	"call-int":      func(f func(int) int, x int) int { return f(x) },
+	"call-variadic": func(f func(...string) []Any) []Any { return f("a", "b") },
+	"call-pair":     func(f func() (int, string)) string { x, s := f(); return fmt.Sprint(x, " ", s) },
+	"call-error": func(f func() error) Any {
+		if err := f(); err != nil {
+			return err.(Error).Err.Error()
+		}
+		return nil
+	},
+	"call":   func(f func()) { f() },
+	"fail":   func() { panic(errors.New("boom")) },
+	"get-fn": func() Fn { return func(ns []Node, _ *Env) Node { return ns[0] } },
+}
+
+func TestCallbacks(t *testing.T) {
+	for _, test := range callbackTests {
+		env := NewEnv(false)
+		for k, v := range callbackValues {
+			env.Set(k, LiteralNode{v})
+		}
+		result, err := ParseAndEval(test.input, env)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if result := result.ToGo(); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: got\n\t%#v\nexpected\n\t%#v", test.name, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package gowen
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// LazySeqNode is a sequence whose elements are only realized once they are needed - it can be infinite.
+// It is realized one chunk (a slice of up to chunkSize nodes) at a time: First, rest & co only realize the first
+// chunk - Seq, String, Count & co realize the whole sequence (and never return for infinite ones).
+// Copies of a LazySeqNode share their realized chunks - fn is called at most once.
+type LazySeqNode struct{ *lazySeq }
+
+type lazySeq struct {
+	mu        sync.Mutex
+	realizing atomic.Bool // set while fn is called - see realize
+	fn        func() Node // returns the rest of the sequence (nil or a seqable node) - nil once realized
+	state     *evalState  // state of the evaluation that created the sequence - realizing it counts against its budget
+	chunk     []Node      // realized elements - empty if the sequence is empty
+	more      Node        // the sequence after chunk - may be nil
+}
+
+// chunkSize is the maximum number of elements that are realized at a time - see Chunk.
+const chunkSize = 32
+
+// NewLazySeq returns a sequence that is realized by calling fn once its first element is needed.
+// fn returns the sequence - nil, a collection or another (lazy) sequence.
+func NewLazySeq(env *Env, fn func() Node) LazySeqNode {
+	return LazySeqNode{&lazySeq{fn: fn, state: env.state}}
+}
+
+// ChunkCons returns the (realized) sequence of the nodes of chunk followed by the nodes of more.
+func ChunkCons(chunk []Node, more Node) LazySeqNode {
+	return LazySeqNode{&lazySeq{chunk: chunk, more: more}}
+}
+
+// Chunk returns the first elements of the sequence of n (at most chunkSize) and the sequence after them.
+// ok is false if the sequence is empty. For lazy sequences only the first chunk is realized.
+func Chunk(n Node) (chunk []Node, more Node, ok bool) {
+	switch n := n.(type) {
+	case nil:
+		return nil, nil, false
+	case LazySeqNode:
+		if n.lazySeq == nil {
+			return nil, nil, false
+		}
+		n.realize()
+		return n.chunk, n.more, len(n.chunk) != 0
+	case ListNode:
+		if len(n.Nodes) <= chunkSize {
+			return n.Nodes, nil, len(n.Nodes) != 0
+		}
+		return n.Nodes[:chunkSize], ListNode{Nodes: n.Nodes[chunkSize:]}, true
+	default:
+		return Chunk(ListNode{Nodes: n.Seq()})
+	}
+}
+
+// uncons returns the first element of the sequence of n and the sequence after it - nil and () if it is empty.
+func uncons(n Node) (Node, Node) {
+	if ln, ok := n.(ListNode); ok && len(ln.Nodes) != 0 {
+		return ln.Nodes[0], ListNode{Nodes: ln.Nodes[1:]}
+	}
+	chunk, more, ok := Chunk(n)
+	switch {
+	case !ok:
+		return LiteralNode{nil}, ListNode{}
+	case len(chunk) > 1:
+		return chunk[0], ChunkCons(chunk[1:], more)
+	case more == nil:
+		return chunk[0], ListNode{}
+	default:
+		return chunk[0], more
+	}
+}
+
+// toSeqNode returns lazy sequences as is and other nodes as the list of their elements - rest & co on it are O(1).
+func toSeqNode(n Node) Node {
+	if ls, ok := n.(LazySeqNode); ok {
+		return ls
+	}
+	return ListNode{Nodes: n.Seq()}
+}
+
+func (s *lazySeq) realize() {
+	if !s.mu.TryLock() {
+		// fn is running - on another goroutine or, if it requires the sequence itself, on this one
+		assert(!s.realizing.Load() || !s.realizedHere(), "lazy seq cannot be realized while it is being realized - it requires itself")
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+	if s.fn == nil {
+		return
+	}
+	s.realizing.Store(true)
+	defer s.realizing.Store(false)
+	s.state.step()
+	n := s.fn()
+	if n != nil && !isNil(n) {
+		chunk, more, _ := Chunk(n)
+		s.chunk, s.more = chunk, more
+		s.state.alloc(ListNode{Nodes: chunk})
+	}
+	s.fn = nil
+}
+
+// realizedHere reports whether s is being realized by the current goroutine. Go has no goroutine ids - the stack
+// of the goroutine is searched for an outer realize call of s instead. It is only checked when realize finds s locked.
+func (s *lazySeq) realizedHere() bool {
+	buf := make([]byte, 64<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	return bytes.Count(buf, []byte(fmt.Sprintf("(*lazySeq).realize(%p", s))) > 1
+}
+
+func (n LazySeqNode) Seq() []Node {
+	ns := []Node{}
+	for chunk, more, ok := Chunk(n); ok; chunk, more, ok = Chunk(more) {
+		ns = append(ns, chunk...)
+	}
+	return ns
+}
+
+// Count realizes the sequence chunk by chunk - unlike Seq it does not collect the elements.
+func (n LazySeqNode) Count() int {
+	count := 0
+	for chunk, more, ok := Chunk(n); ok; chunk, more, ok = Chunk(more) {
+		count += len(chunk)
+	}
+	return count
+}
+
+func (n LazySeqNode) Conj(x Node) Node  { return ChunkCons([]Node{x}, n) }
+func (n LazySeqNode) String() string    { return ListNode{Nodes: n.Seq()}.String() }
+func (n LazySeqNode) ToGo() Any         { return ListNode{Nodes: n.Seq()}.ToGo() }
+func (n LazySeqNode) Equal(x Node) bool { return equalSeq(n.Seq(), x) }
+func (n LazySeqNode) Hash() uint32      { return hashSeq(n.Seq()) }
+
+// Get returns the element at index x - only the chunks up to it are realized.
+func (n LazySeqNode) Get(x Node) Node {
+	i, ok := x.(LiteralNode).Value.(int64)
+	assert(ok, "get on lazy seq requires an integer index: %s", x)
+	for chunk, more, ok := Chunk(n); ok && i >= 0; chunk, more, ok = Chunk(more) {
+		if i < int64(len(chunk)) {
+			return chunk[i]
+		}
+		i -= int64(len(chunk))
+	}
+	return LiteralNode{nil}
+}
+
+// lazyConcat returns the lazy concatenation of the sequences of ns.
+func lazyConcat(ns []Node, env *Env) Node {
+	return NewLazySeq(env, func() Node {
+		for ; len(ns) != 0; ns = ns[1:] {
+			if chunk, more, ok := Chunk(ns[0]); ok {
+				return ChunkCons(chunk, lazyConcat(append([]Node{more}, ns[1:]...), env))
+			}
+		}
+		return nil
+	})
+}
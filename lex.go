@@ -5,9 +5,12 @@ package gowen
 // 1. do something with the input (like emit a token)
 // 2. return the next lex fn (or nil if there is nothing left to do) once they are done.
 // As most tokens are delimited by space lexSpace ends up being our dispatching function.
+// Unlike in the talk the lex fns do not run in a goroutine of their own - the consumer pulls each token via
+// nextToken which runs them until the next token is emitted. The input is only lexed as far as it is consumed.
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -30,15 +33,24 @@ const (
 	tokenBracketClose
 	tokenBraceOpen
 	tokenBraceClose
+	tokenSetOpen
 	tokenSpace
 	tokenSymbol
 	tokenKeyword
-	tokenFloat
+	tokenNumber
 	tokenString
 	tokenUnquote
 	tokenUnquoteSplicing
 	tokenQuote
 	tokenQuasiQuote
+	tokenDeref
+	tokenFnOpen
+	tokenDiscard
+	tokenMeta
+	tokenVarQuote
+	tokenChar
+	tokenRegex
+	tokenRawString
 )
 
 const eof = -1
@@ -47,25 +59,42 @@ const digits = "0123456789"
 type stateFn func(*lexer) stateFn
 
 type lexer struct {
-	input  string
-	index  int
-	start  int
-	width  int
-	tokens chan token
+	name         string
+	input        string
+	index        int
+	start        int
+	width        int
+	state        stateFn
+	tokens       []token // emitted but not yet consumed
+	last         token   // the last consumed token - returned again once the lex fns are done
+	atEOF        bool    // set once a lex fn reached the end of input - see Reader
+	line, column int     // of the start of input if it is part of a larger input - see Reader
+	lines        []int   // start indexes of lines; built lazily by the consumer of tokens
+	lastIndex    int     // of the last translated position - tokens are mostly translated in order
+	lastColumn   int
+	inFn         bool // set while the consumer of tokens reads a fn literal - they must not be nested
 }
 
 func lex(input string) *lexer {
-	l := &lexer{
-		input:  input,
-		tokens: make(chan token),
+	return &lexer{input: input, state: lexSpace}
+}
+
+// nextToken returns the next token. After the EOF (or error) token it returns that token again.
+func (l *lexer) nextToken() token {
+	for len(l.tokens) == 0 && l.state != nil {
+		l.state = l.state(l)
 	}
-	go func() {
-		for state := lexSpace; state != nil; {
-			state = state(l)
-		}
-		close(l.tokens)
-	}()
-	return l
+	if len(l.tokens) != 0 {
+		l.last, l.tokens = l.tokens[0], l.tokens[1:]
+	}
+	return l.last
+}
+
+// peekToken returns the next token without consuming it.
+func (l *lexer) peekToken() token {
+	t := l.nextToken()
+	l.tokens = append([]token{t}, l.tokens...)
+	return t
 }
 
 func lexSpace(l *lexer) stateFn {
@@ -93,6 +122,15 @@ func lexSpace(l *lexer) stateFn {
 	case r == '}':
 		l.emit(tokenBraceClose)
 		return lexSpace
+	case r == '#' && strings.ContainsRune("{(_'\"", l.peek()):
+		return lexDispatch
+	case r == '#' && strings.HasPrefix(l.input[l.index:], "r\""):
+		return lexRawString
+	case r == '\\':
+		return lexChar
+	case r == '^':
+		l.emit(tokenMeta)
+		return lexSpace
 	case r == '"':
 		return lexString
 	case r == '\'':
@@ -101,6 +139,9 @@ func lexSpace(l *lexer) stateFn {
 	case r == '`':
 		l.emit(tokenQuasiQuote)
 		return lexSpace
+	case r == '@':
+		l.emit(tokenDeref)
+		return lexSpace
 	case ('0' <= r && r <= '9'):
 		return lexNumber
 	case r == '~':
@@ -131,23 +172,60 @@ func lexUnquote(l *lexer) stateFn {
 	return lexSpace
 }
 
-func lexString(l *lexer) stateFn {
-	for r := l.next(); r != '"'; r = l.next() {
-		if r == '\\' {
-			r = l.next()
-		}
-		if r == eof {
-			return l.errorf("unterminated quoted string")
+// lexDispatch lexes the reader macros starting with # - sets, fn literals, discards, var quotes and regexes.
+func lexDispatch(l *lexer) stateFn {
+	switch l.next() {
+	case '"':
+		if !l.acceptQuoted() {
+			return l.errorf("unterminated regex")
 		}
+		l.emit(tokenRegex)
+	case '{':
+		l.emit(tokenSetOpen)
+	case '(':
+		l.emit(tokenFnOpen)
+	case '_':
+		l.emit(tokenDiscard)
+	case '\'':
+		l.emit(tokenVarQuote)
+	}
+	return lexSpace
+}
+
+func lexString(l *lexer) stateFn {
+	if !l.acceptQuoted() {
+		return l.errorf("unterminated quoted string")
 	}
 	l.emit(tokenString)
 	return lexSpace
 }
 
+// lexRawString lexes raw strings: #r"..." - like regexes their content is taken as is (see acceptQuoted).
+func lexRawString(l *lexer) stateFn {
+	l.index += len(`r"`)
+	if !l.acceptQuoted() {
+		return l.errorf("unterminated raw string")
+	}
+	l.emit(tokenRawString)
+	return lexSpace
+}
+
+// lexChar lexes character literals: a backslash followed by a rune or the name of one (e.g. \a, \newline, \u00e9).
+func lexChar(l *lexer) stateFn {
+	if l.next() == eof {
+		return l.errorf("unterminated character")
+	}
+	for r := l.next(); isValidIdentifierRune(r); r = l.next() {
+	}
+	l.backup()
+	l.emit(tokenChar)
+	return lexSpace
+}
+
 func lexComment(l *lexer) stateFn {
 	offset := strings.Index(l.input[l.index:], "\n")
 	if offset == -1 {
-		return l.errorf("unterminated comment")
+		offset = len(l.input) - l.index
 	}
 	l.index += offset
 	l.ignore()
@@ -173,27 +251,31 @@ func lexKeyword(l *lexer) stateFn {
 func lexNumber(l *lexer) stateFn {
 	l.accept("+-")
 	l.acceptRun(digits)
-	if l.accept(".") {
-		l.acceptRun(digits)
-	}
-	if l.accept("eE") {
-		l.accept("+-")
+	if l.accept("/") {
 		l.acceptRun(digits)
+	} else {
+		if l.accept(".") {
+			l.acceptRun(digits)
+		}
+		if l.accept("eE") {
+			l.accept("+-")
+			l.acceptRun(digits)
+		}
 	}
 	if r := l.peek(); isValidIdentifierRune(r) {
 		return lexSymbol
 	}
-	l.emit(tokenFloat)
+	l.emit(tokenNumber)
 	return lexSpace
 }
 
 func isValidIdentifierRune(r rune) bool {
-	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("?&@~<>=-+*/_#:.", r)
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("?!&@~<>=-+*/_#:.%", r)
 }
 
 func (l *lexer) next() rune {
 	if l.index >= len(l.input) {
-		l.width = 0
+		l.width, l.atEOF = 0, true
 		return eof
 	}
 	r, w := utf8.DecodeRuneInString(l.input[l.index:])
@@ -202,6 +284,19 @@ func (l *lexer) next() rune {
 	return r
 }
 
+// acceptQuoted consumes the rest of a quoted string - up to and including the closing unescaped ".
+func (l *lexer) acceptQuoted() bool {
+	for r := l.next(); r != '"'; r = l.next() {
+		if r == '\\' {
+			r = l.next()
+		}
+		if r == eof {
+			return false
+		}
+	}
+	return true
+}
+
 func (l *lexer) peek() rune {
 	r := l.next()
 	l.backup()
@@ -215,7 +310,7 @@ func (l *lexer) backup() {
 }
 
 func (l *lexer) emit(c tokenCategory) {
-	l.tokens <- token{c, l.input[l.start:l.index], l.start}
+	l.tokens = append(l.tokens, token{c, l.input[l.start:l.index], l.start})
 	l.start = l.index
 }
 
@@ -237,7 +332,38 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
+// position translates a token index into a Position. It must only be called by the
+// consumer of the tokens as it lazily builds the line index.
+func (l *lexer) position(index int) Position {
+	if l.lines == nil {
+		l.lines = []int{0}
+		for i, r := range l.input {
+			if r == '\n' {
+				l.lines = append(l.lines, i+1)
+			}
+		}
+	}
+	line := sort.Search(len(l.lines), func(i int) bool { return l.lines[i] > index })
+	column, start := 0, l.lines[line-1]
+	if l.lastColumn != 0 && start <= l.lastIndex && l.lastIndex <= index {
+		column = l.lastColumn + utf8.RuneCountInString(l.input[l.lastIndex:index])
+	} else {
+		column = utf8.RuneCountInString(l.input[start:index]) + 1
+	}
+	l.lastIndex, l.lastColumn = index, column
+	if line == 1 {
+		column += l.column
+	}
+	return Position{l.name, line + l.line, column}
+}
+
+func (l *lexer) assert(assertion bool, t token, format string, vs ...Any) {
+	if !assertion {
+		panic(Error{Pos: l.position(t.index), Err: errorf(format, vs...)})
+	}
+}
+
 func (l *lexer) errorf(format string, args ...Any) stateFn {
-	l.tokens <- token{tokenError, fmt.Sprintf(format, args...), l.start}
+	l.tokens = append(l.tokens, token{tokenError, fmt.Sprintf(format, args...), l.start})
 	return nil
 }
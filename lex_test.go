@@ -31,12 +31,12 @@ var lexTests = []lexTest{
 	}},
 
 	{"numbers", "-42 0 +42 -42.01 0.01 +42.01", []token{
-		token{tokenFloat, "-42", 0},
-		token{tokenFloat, "0", 4},
-		token{tokenFloat, "+42", 6},
-		token{tokenFloat, "-42.01", 10},
-		token{tokenFloat, "0.01", 17},
-		token{tokenFloat, "+42.01", 22},
+		token{tokenNumber, "-42", 0},
+		token{tokenNumber, "0", 4},
+		token{tokenNumber, "+42", 6},
+		token{tokenNumber, "-42.01", 10},
+		token{tokenNumber, "0.01", 17},
+		token{tokenNumber, "+42.01", 22},
 		token{tokenEOF, "", 28},
 	}},
 
@@ -58,16 +58,16 @@ var lexTests = []lexTest{
 	{"lists", "(+ 1 2)", []token{
 		token{tokenParenOpen, "(", 0},
 		token{tokenSymbol, "+", 1},
-		token{tokenFloat, "1", 3},
-		token{tokenFloat, "2", 5},
+		token{tokenNumber, "1", 3},
+		token{tokenNumber, "2", 5},
 		token{tokenParenClose, ")", 6},
 		token{tokenEOF, "", 7},
 	}},
 
 	{"vectors", "[1 2]", []token{
 		token{tokenBracketOpen, "[", 0},
-		token{tokenFloat, "1", 1},
-		token{tokenFloat, "2", 3},
+		token{tokenNumber, "1", 1},
+		token{tokenNumber, "2", 3},
 		token{tokenBracketClose, "]", 4},
 		token{tokenEOF, "", 5},
 	}},
@@ -77,16 +77,64 @@ var lexTests = []lexTest{
 		token{tokenKeyword, ":foo", 1},
 		token{tokenSymbol, "bar", 6},
 		token{tokenKeyword, ":baz", 10},
-		token{tokenFloat, "42", 15},
+		token{tokenNumber, "42", 15},
 		token{tokenBraceClose, "}", 17},
 		token{tokenEOF, "", 18},
 	}},
 
+	{"sets", "#{1 #}", []token{
+		token{tokenSetOpen, "#{", 0},
+		token{tokenNumber, "1", 2},
+		token{tokenSymbol, "#", 4},
+		token{tokenBraceClose, "}", 5},
+		token{tokenEOF, "", 6},
+	}},
+
+	{"deref", "@a ~@b", []token{
+		token{tokenDeref, "@", 0},
+		token{tokenSymbol, "a", 1},
+		token{tokenUnquoteSplicing, "~@", 3},
+		token{tokenSymbol, "b", 5},
+		token{tokenEOF, "", 6},
+	}},
+
+	{"reader macros", "#(f %) #_a #'b ^:c d", []token{
+		token{tokenFnOpen, "#(", 0},
+		token{tokenSymbol, "f", 2},
+		token{tokenSymbol, "%", 4},
+		token{tokenParenClose, ")", 5},
+		token{tokenDiscard, "#_", 7},
+		token{tokenSymbol, "a", 9},
+		token{tokenVarQuote, "#'", 11},
+		token{tokenSymbol, "b", 13},
+		token{tokenMeta, "^", 15},
+		token{tokenKeyword, ":c", 16},
+		token{tokenSymbol, "d", 19},
+		token{tokenEOF, "", 20},
+	}},
+
+	{"characters & regexes", `\a \newline (\é) #"\d+\"" "\d"`, []token{
+		token{tokenChar, `\a`, 0},
+		token{tokenChar, `\newline`, 3},
+		token{tokenParenOpen, "(", 12},
+		token{tokenChar, `\é`, 13},
+		token{tokenParenClose, ")", 16},
+		token{tokenRegex, `#"\d+\""`, 18},
+		token{tokenString, `"\d"`, 27},
+		token{tokenEOF, "", 31},
+	}},
+
+	{"raw strings", `#r"\d\"" #r`, []token{
+		token{tokenRawString, `#r"\d\""`, 0},
+		token{tokenSymbol, "#r", 9},
+		token{tokenEOF, "", 11},
+	}},
+
 	{"quotes unquotes", "'(+ 2) 'x `y `~@[a ~b]", []token{
 		token{tokenQuote, "'", 0},
 		token{tokenParenOpen, "(", 1},
 		token{tokenSymbol, "+", 2},
-		token{tokenFloat, "2", 4},
+		token{tokenNumber, "2", 4},
 		token{tokenParenClose, ")", 5},
 		token{tokenQuote, "'", 7},
 		token{tokenSymbol, "x", 8},
@@ -106,9 +154,10 @@ var lexTests = []lexTest{
 func TestLex(t *testing.T) {
 	for _, test := range lexTests {
 		l := lex(test.input)
-		tokens := []token{}
-		for token := range l.tokens {
-			tokens = append(tokens, token)
+		tokens := []token{l.nextToken()}
+		for last := tokens[0]; last.category != tokenEOF && last.category != tokenError; {
+			last = l.nextToken()
+			tokens = append(tokens, last)
 		}
 		if !reflect.DeepEqual(tokens, test.tokens) {
 			t.Errorf("%s: got\n\t%#v\nexpected\n\t%#v", test.name, tokens, test.tokens)
@@ -1,10 +1,10 @@
 package gowen
 
-import "reflect"
-
-func init() {
-	Register(values, `(def version "0.0.1")`)
-}
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
 
 var values = map[string]Any{
 	"if":         SpecialFn(fi),
@@ -13,52 +13,225 @@ var values = map[string]Any{
 	"macro":      SpecialFn(newMacro),
 	"try":        SpecialFn(try),
 	"quote":      SpecialFn(quote),
+	"do":         SpecialFn(do),
+	"let":        SpecialFn(let),
+	"loop":       SpecialFn(loop),
+	"recur":      SpecialFn(recur),
+	"ns":         SpecialFn(ns),
 	"quasiquote": MacroFn(quasiquote),
+	"lazy-seq":   SpecialFn(newLazySeq),
+	"var":        SpecialFn(varQuote),
 
-	"get": func(ns []Node, env *Env) Node {
+	"get": BorrowingFn(func(ns []Node, env *Env) Node {
 		v := ns[0].Get(ns[1])
 		if ln, ok := v.(LiteralNode); ok && ln.Value == nil && len(ns) == 3 {
 			return ns[2]
 		}
 		return v
-	},
+	}),
 
-	"seq":  func(ns []Node, env *Env) Node { return ListNode{ns[0].Seq()} },
-	"cons": func(ns []Node, env *Env) Node { return ListNode{append([]Node{ns[0]}, ns[1].Seq()...)} },
-	"conj": func(ns []Node, env *Env) Node { return ns[0].Conj(ns[1]) },
+	"seq": BorrowingFn(func(ns []Node, env *Env) Node { return toSeqNode(ns[0]) }),
+	"cons": BorrowingFn(func(ns []Node, env *Env) Node {
+		if ls, ok := ns[1].(LazySeqNode); ok {
+			return ChunkCons([]Node{ns[0]}, ls)
+		}
+		return ListNode{Nodes: append([]Node{ns[0]}, ns[1].Seq()...)}
+	}),
+	"first": BorrowingFn(func(ns []Node, env *Env) Node { first, _ := uncons(ns[0]); return first }),
+	"rest":  BorrowingFn(func(ns []Node, env *Env) Node { _, rest := uncons(ns[0]); return rest }),
+	"empty?": BorrowingFn(func(ns []Node, env *Env) Node {
+		_, _, ok := Chunk(ns[0])
+		return LiteralNode{!ok}
+	}),
+	"conj": BorrowingFn(func(ns []Node, env *Env) Node { return ns[0].Conj(ns[1]) }),
+	"assoc": BorrowingFn(func(ns []Node, env *Env) Node {
+		assert(len(ns)%2 == 1, "assoc must be called with a collection and key value pairs")
+		coll := ns[0]
+		for i := 1; i < len(ns); i += 2 {
+			switch n := coll.(type) {
+			case VectorNode:
+				index, ok := ns[i].(LiteralNode).Value.(int64)
+				assert(ok, "assoc on vector requires an integer index: %s", ns[i])
+				coll = n.Assoc(int(index), ns[i+1])
+			case MapNode:
+				coll = n.Assoc(ns[i], ns[i+1])
+			default:
+				assert(isNil(coll), "cannot assoc on %s", coll)
+				coll = NewMap(ns[i], ns[i+1])
+			}
+		}
+		return coll
+	}),
+	"dissoc": BorrowingFn(func(ns []Node, env *Env) Node {
+		m, ok := ns[0].(MapNode)
+		assert(ok || isNil(ns[0]), "cannot dissoc on %s", ns[0])
+		for _, k := range ns[1:] {
+			m = m.Dissoc(k)
+		}
+		return m
+	}),
 	"concat": func(ns []Node, env *Env) Node {
 		out := []Node{}
+		for _, n := range ns {
+			if _, ok := n.(LazySeqNode); ok {
+				return lazyConcat(ns, env)
+			}
+		}
 		for _, n := range ns {
 			out = append(out, n.Seq()...)
 		}
-		return ListNode{out}
+		return ListNode{Nodes: out}
 	},
 	"slice": func(ns []Node, env *Env) Node {
-		t := reflect.TypeOf(0)
-		i := reflect.ValueOf(ns[1].(LiteralNode).Value).Convert(t).Int()
-		j := reflect.ValueOf(ns[2].(LiteralNode).Value).Convert(t).Int()
-		return ListNode{ns[0].Seq()[i:j]}
+		i, j := indexArg(ns[1]), indexArg(ns[2])
+		return ListNode{Nodes: ns[0].Seq()[i:j]}
 	},
 
-	"count": func(ns []Node, env *Env) Node { return LiteralNode{float64(len(ns[0].Seq()))} },
+	"count": BorrowingFn(func(ns []Node, env *Env) Node {
+		if c, ok := ns[0].(interface{ Count() int }); ok {
+			return LiteralNode{int64(c.Count())}
+		}
+		return LiteralNode{int64(len(ns[0].Seq()))}
+	}),
+
+	"require": func(ns []Node, env *Env) Node {
+		for _, spec := range ns {
+			require(spec, env.topLevel())
+		}
+		return LiteralNode{nil}
+	},
 
+	"gensym": func(ns []Node, env *Env) Node {
+		prefix := "G__"
+		if len(ns) != 0 {
+			s, ok := ns[0].ToGo().(string)
+			assert(ok, "gensym: prefix must be a string - got %s", ns[0])
+			prefix = s
+		}
+		return gensym(prefix, "")
+	},
+	"meta": func(ns []Node, env *Env) Node {
+		assert(len(ns) == 1, "wrong number of arguments for meta")
+		m := metaOf(ns[0])
+		if v, ok := ns[0].ToGo().(Var); ok {
+			m = v.Meta()
+		}
+		if m.Count() == 0 {
+			return LiteralNode{nil}
+		}
+		return m
+	},
+	"with-meta": func(ns []Node, env *Env) Node {
+		assert(len(ns) == 2, "wrong number of arguments for with-meta")
+		if isNil(ns[1]) {
+			return withMeta(ns[0], MapNode{})
+		}
+		m, ok := ns[1].(MapNode)
+		assert(ok, "with-meta requires a map - got %s", ns[1])
+		return withMeta(ns[0], m)
+	},
+	// ex-message, ex-form, ex-pos & ex-stack return the parts of an error caught by try (or returned by a go block).
+	"ex-message": func(ns []Node, env *Env) Node { return LiteralNode{caughtError(ns[0]).Err.Error()} },
+	"ex-form": func(ns []Node, env *Env) Node {
+		if form := caughtError(ns[0]).Form; form != nil {
+			return form
+		}
+		return LiteralNode{nil}
+	},
+	"ex-pos": func(ns []Node, env *Env) Node {
+		if pos := caughtError(ns[0]).Pos; pos.IsValid() {
+			return LiteralNode{pos.String()}
+		}
+		return LiteralNode{nil}
+	},
+	"ex-stack": func(ns []Node, env *Env) Node {
+		frames := []Node{}
+		for _, f := range caughtError(ns[0]).Stack {
+			frames = append(frames, f.toNode())
+		}
+		return NewVector(frames...)
+	},
 	"macroexpand": func(ns []Node, env *Env) Node { return expand(ns, env)[0] },
 	"parse":       func(in string) []Node { return parse(in) },
 	"eval":        func(ns []Node, env *Env) Node { return eval(ns[0], env) },
-	"apply":       func(ns []Node, env *Env) (Node, *Env, bool) { return apply(ns[0], ns[1].Seq(), env) },
+	"disassemble": func(ns []Node, env *Env) Node { return LiteralNode{disassemble(ns[0], env)} },
+	"reify": func(ns []Node, env *Env) Node {
+		assert(len(ns) == 2, "wrong number of arguments for reify")
+		return LiteralNode{reify(ns[0].ToGo(), ns[1], env)}
+	},
+	"apply": func(ns []Node, env *Env) (Node, *Env, bool) { return apply(ns[0], ns[1].Seq(), env) },
 	"env": func(_ []Node, env *Env) Node {
 		values := map[string]Any{}
 		for env := env; env != nil; env = env.parent {
-			for k, v := range env.values {
-				values[k] = v
-			}
+			env.each(func(k string, v Any) {
+				if _, exists := values[k]; !exists {
+					values[k] = v
+				}
+			})
 		}
 		return LiteralNode{values}
 	},
 }
 
+// builtinMeta holds the docs of the special forms and builtins of values - see (doc name) in lib/core.
+var builtinMeta = map[string]map[string]string{
+	"if":          {"doc": "Evaluates then if test is truthy - else (or nil)."},
+	"def":         {"doc": "Defines the global name with the value of expr - an optional docstring may precede expr."},
+	"fn":          {"doc": "Returns a fn of the params (or arities) that evaluates body. An optional name lets it call itself."},
+	"macro":       {"doc": "Returns a macro of the params that evaluates body to the form it expands to."},
+	"try":         {"doc": "Evaluates body. Errors are caught by (catch e body) and (finally body) is evaluated in any case."},
+	"quote":       {"doc": "Returns x without evaluating it."},
+	"do":          {"doc": "Evaluates the exprs in order and returns the value of the last one."},
+	"let":         {"doc": "Evaluates body with the names of the bindings vector bound to the values of their exprs."},
+	"loop":        {"doc": "Evaluates body like let - recur in its tail position evaluates it again with new bindings."},
+	"recur":       {"doc": "Evaluates the innermost loop or fn again with the values of the exprs."},
+	"ns":          {"doc": "Declares the namespace of the env and requires the namespaces of its (:require ...) clauses."},
+	"quasiquote":  {"doc": "Returns x without evaluating it - except for the unquoted (~x) and unquote-spliced (~@xs) forms."},
+	"lazy-seq":    {"doc": "Returns a lazy seq that evaluates body the first time it is realized."},
+	"var":         {"doc": "Returns the var of the symbol - see meta & deref."},
+	"get":         {"doc": "Returns the value of key in coll - or not-found (or nil) if it does not exist."},
+	"seq":         {"doc": "Returns a seq of the elements of coll."},
+	"cons":        {"doc": "Returns a seq of x followed by the elements of coll."},
+	"first":       {"doc": "Returns the first element of coll - or nil if it is empty."},
+	"rest":        {"doc": "Returns the elements of coll after the first one."},
+	"empty?":      {"doc": "Returns true if coll has no elements."},
+	"conj":        {"doc": "Returns coll with x added - at the end of vectors, the front of lists."},
+	"assoc":       {"doc": "Returns coll with the keys (indexes of vectors) set to the values."},
+	"dissoc":      {"doc": "Returns the map m without the keys."},
+	"concat":      {"doc": "Returns a seq of the elements of the colls in order."},
+	"slice":       {"doc": "Returns a list of the elements of coll from index i up to index j."},
+	"count":       {"doc": "Returns the number of elements of coll."},
+	"require":     {"doc": "Loads the namespaces of the specs - symbols or [name :as alias :refer [names]] vectors."},
+	"gensym":      {"doc": "Returns a new unique symbol - with the prefix (or G__)."},
+	"meta":        {"doc": "Returns the metadata of x - or nil if it has none."},
+	"with-meta":   {"doc": "Returns x with the metadata m."},
+	"ex-message":  {"doc": "Returns the message of the caught error e."},
+	"ex-form":     {"doc": "Returns the form the caught error e occurred in - or nil."},
+	"ex-pos":      {"doc": "Returns the position of the caught error e - or nil."},
+	"ex-stack":    {"doc": "Returns the stack of the caught error e - a vector of frames."},
+	"macroexpand": {"doc": "Returns the form with all macros expanded."},
+	"parse":       {"doc": "Returns the forms read from the string in."},
+	"eval":        {"doc": "Evaluates the form in the current env."},
+	"disassemble": {"doc": "Returns the bytecode of the fn f as a string."},
+	"reify":       {"doc": "Returns a value implementing the Go interface type t whose methods call the fns of the map methods."},
+	"apply":       {"doc": "Calls f with the elements of args."},
+	"env":         {"doc": "Returns a map of the names and values visible in the current env."},
+}
+
+func varQuote(ns []Node, env *Env) (Node, *Env, bool) {
+	assert(len(ns) == 1, "wrong number of arguments for var")
+	sn, ok := ns[0].(SymbolNode)
+	assert(ok, "var requires a symbol - got %s", ns[0])
+	v, ok := env.Var(sn.Value)
+	assert(ok, "could not lookup symbol %s", sn.Value)
+	return LiteralNode{v}, env, true
+}
+
+// quasiquote replaces the quoted symbols ending in # (e.g. x#) with a symbol generated via gensym - the same one
+// for each occurrence in a quasiquote expression. Nested quasiquote expressions generate their own symbols.
 func quasiquote(nodes []Node, env *Env) Node {
 	assert(len(nodes) == 1, "wrong number of arguments for quasiquote")
+	gensyms := map[string]SymbolNode{}
 	var qq func(n Node, lvl int) (Node, bool)
 	qq = func(n Node, lvl int) (Node, bool) {
 		switch n := n.(type) {
@@ -67,11 +240,16 @@ func quasiquote(nodes []Node, env *Env) Node {
 		case SymbolNode:
 			if lvl == 0 {
 				return n, false
+			} else if name := n.Value; len(name) > 1 && strings.HasSuffix(name, "#") {
+				if _, ok := gensyms[name]; !ok {
+					gensyms[name] = gensym(name[:len(name)-1]+"__", "__auto__")
+				}
+				n = gensyms[name]
 			}
 			return wrapInCall("quote", []Node{n}), false
-		case VectorNode:
+		case VectorNode, SetNode:
 			out := wrapInCall("concat", []Node{})
-			for _, cn := range n.Nodes {
+			for _, cn := range n.Seq() {
 				qn, splicing := qq(cn, lvl)
 				if splicing {
 					out.Nodes = append(out.Nodes, qn)
@@ -79,6 +257,9 @@ func quasiquote(nodes []Node, env *Env) Node {
 					out.Nodes = append(out.Nodes, wrapInCall("list", []Node{qn}))
 				}
 			}
+			if _, ok := n.(SetNode); ok {
+				return wrapInCall("set", []Node{out}), false
+			}
 			return wrapInCall("vec", []Node{out}), false
 		case ListNode:
 			if lvl == 0 {
@@ -86,6 +267,9 @@ func quasiquote(nodes []Node, env *Env) Node {
 			}
 			switch callTo(n) {
 			case "quasiquote":
+				outer := gensyms
+				gensyms = map[string]SymbolNode{}
+				defer func() { gensyms = outer }()
 				return qq(n.Nodes[1], lvl+1)
 			case "unquote", "unquote-splicing":
 				lvl--
@@ -114,98 +298,197 @@ func quasiquote(nodes []Node, env *Env) Node {
 	return node
 }
 
-func fi(nodes []Node, parentEnv *Env) (Node, *Env, bool) {
-	env := ChildEnv(parentEnv)
-	assert(len(nodes) >= 2, "wrong number of arguments for if")
-	ln, isLn := eval(nodes[0], env).(LiteralNode)
-	if !isLn || (ln.Value != false && ln.Value != nil) {
-		return nodes[1], env, false
-	} else if len(nodes) == 3 {
-		return nodes[2], env, false
-	} else {
-		return LiteralNode{nil}, env, true
-	}
+var gensymCounter atomic.Int64
+
+// gensym returns a new unique symbol - e.g. x__1__auto__ for x__ and __auto__.
+func gensym(prefix, suffix string) SymbolNode {
+	return SymbolNode{Value: prefix + strconv.FormatInt(gensymCounter.Add(1), 10) + suffix}
 }
 
-func def(nodes []Node, env *Env) (Node, *Env, bool) {
-	assert(env.IsTopLevel(), "def must only be called from top level")
-	assert(len(nodes) == 2, "wrong number of arguments for def")
+type arity struct {
+	params   VectorNode
+	body     []Node
+	required int
+	variadic bool
+}
+
+// parseDef returns the symbol, value and metadata of the def form n - (def name docstring? value). The metadata is
+// that of the symbol plus the docstring (:doc), the position of the symbol (:file, :line, :column) and the params
+// of fn & macro values (:arglists).
+func parseDef(n ListNode) (SymbolNode, Node, MapNode) {
+	nodes := n.Nodes[1:]
+	assert(len(nodes) == 2 || len(nodes) == 3, "wrong number of arguments for def")
 	sn, ok := nodes[0].(SymbolNode)
 	assert(ok, "def must be called with a symbol as the first argument")
-	env.Set(sn.Value, eval(nodes[1], env))
-	return LiteralNode{nil}, env, true
+	m := sn.Meta
+	if len(nodes) == 3 {
+		ln, _ := nodes[1].(LiteralNode)
+		_, ok := ln.Value.(string)
+		assert(ok, "the docstring of def must be a string - got %s", nodes[1])
+		m = m.Assoc(KeywordNode{"doc"}, ln)
+	}
+	if sn.Pos.File != "" {
+		m = m.Assoc(KeywordNode{"file"}, LiteralNode{sn.Pos.File})
+	}
+	if sn.Pos.IsValid() {
+		m = m.Assoc(KeywordNode{"line"}, LiteralNode{int64(sn.Pos.Line)})
+		m = m.Assoc(KeywordNode{"column"}, LiteralNode{int64(sn.Pos.Column)})
+	}
+	value := nodes[len(nodes)-1]
+	if arglists, ok := arglists(value); ok {
+		m = m.Assoc(KeywordNode{"arglists"}, arglists)
+	}
+	return sn, value, m
+}
+
+// arglists returns the params of the arities of the fn or macro form n.
+func arglists(n Node) (ListNode, bool) {
+	ln, ok := n.(ListNode)
+	if name := callTo(n); !ok || name != "fn" && name != "macro" {
+		return ListNode{}, false
+	}
+	nodes, params := ln.Nodes[1:], []Node{}
+	if len(nodes) != 0 {
+		if _, ok := nodes[0].(SymbolNode); ok {
+			nodes = nodes[1:]
+		}
+	}
+	if len(nodes) != 0 {
+		if vn, ok := nodes[0].(VectorNode); ok {
+			return ListNode{Nodes: []Node{withoutPosition(vn)}}, true
+		}
+	}
+	for _, n := range nodes {
+		if ln, ok := n.(ListNode); ok && len(ln.Nodes) != 0 {
+			if vn, ok := ln.Nodes[0].(VectorNode); ok {
+				params = append(params, withoutPosition(vn))
+			}
+		}
+	}
+	return ListNode{Nodes: params}, len(params) != 0
 }
 
-func buildFn(nodes []Node, defsideEnv *Env) (ComplexFn, *Env, string) {
+// parseFn reads the arguments of a fn (or macro) form: an optional name followed by either a single
+// params vector and body or one list of params vector and body per arity.
+func parseFn(nodes []Node) (string, []arity) {
 	assert(len(nodes) >= 1, "wrong number of arguments for fn")
-	fnEnv := ChildEnv(defsideEnv)
 	name := "_"
-	paramNodes := nodes[0]
-	bodyNodes := nodes[1:]
 	if sn, ok := nodes[0].(SymbolNode); ok {
-		name = sn.Value
-		paramNodes = nodes[1]
-		bodyNodes = nodes[2:]
+		name, nodes = sn.Value, nodes[1:]
+	}
+	assert(len(nodes) >= 1, "wrong number of arguments for fn")
+	if _, ok := nodes[0].(VectorNode); ok {
+		return name, []arity{newArity(nodes)}
 	}
-	fn := func(argumentNodes []Node, _ *Env) (Node, *Env, bool) {
-		env := ChildEnv(fnEnv)
-		destructure(paramNodes, VectorNode{argumentNodes}, env)
-		if len(bodyNodes) == 0 {
-			return LiteralNode{nil}, env, true
+	arities, variadic := []arity{}, -1
+	for _, n := range nodes {
+		ln, ok := n.(ListNode)
+		assert(ok && len(ln.Nodes) >= 1, "fn arity must be a list of params and body: %s", n)
+		a := newArity(ln.Nodes)
+		for _, a2 := range arities {
+			assert(a.required != a2.required || a.variadic != a2.variadic, "fn must not have two arities with %d params", a.required)
 		}
-		for _, n := range bodyNodes[:len(bodyNodes)-1] {
-			eval(n, env)
+		if a.variadic {
+			assert(variadic == -1, "fn must not have more than one variadic arity")
+			variadic = a.required
 		}
-		return bodyNodes[len(bodyNodes)-1], env, false
+		arities = append(arities, a)
 	}
-	return fn, fnEnv, name
+	for _, a := range arities {
+		assert(variadic == -1 || a.variadic || a.required <= variadic,
+			"fn must not have a fixed arity with more params than the variadic arity")
+	}
+	return name, arities
 }
 
-func newFn(nodes []Node, defsideEnv *Env) (Node, *Env, bool) {
-	fn, fnEnv, name := buildFn(nodes, defsideEnv)
-	fnEnv.Set(name, fn)
-	return LiteralNode{fn}, defsideEnv, true
+func newArity(nodes []Node) arity {
+	params, ok := nodes[0].(VectorNode)
+	assert(ok, "fn params must be a vector: %s", nodes[0])
+	a := arity{params: params, body: nodes[1:]}
+	for _, param := range params.Seq() {
+		if sn, _ := param.(SymbolNode); sn.Value == "&" {
+			a.variadic = true
+			break
+		} else if kn, _ := param.(KeywordNode); kn.Value == "as" {
+			break
+		}
+		a.required++
+	}
+	return a
 }
 
-func newMacro(nodes []Node, defsideEnv *Env) (Node, *Env, bool) {
-	fn, fnEnv, name := buildFn(nodes, defsideEnv)
-	macroFn := MacroFn(func(ns []Node, env *Env) Node {
-		n, env, isFinal := fn(ns, env)
-		if !isFinal {
-			n = eval(n, env)
-		}
-		return n
-	})
-	fnEnv.Set(name, macroFn)
-	return LiteralNode{macroFn}, defsideEnv, true
+func (a arity) accepts(n int) bool {
+	return n == a.required || (a.variadic && n > a.required)
 }
 
-func try(nodes []Node, parentEnv *Env) (node Node, env *Env, isFinal bool) {
-	assert(len(nodes) >= 1, "wrong number of arguments for try")
-	catch, ok := nodes[len(nodes)-1].(ListNode)
-	body := nodes[:len(nodes)-1]
-	assert(ok && callTo(catch) == "catch", "last form of try must be a catch clause")
-	assert(len(catch.Nodes) >= 2, "invalid catch clause (inside try)")
-	catchSymbol, catchBody := catch.Nodes[1], catch.Nodes[2:]
-	sn, ok := catchSymbol.(SymbolNode)
-	assert(ok, "catch clause must have symbol as first element")
-	defer func() {
-		if err := recover(); err != nil {
-			env, isFinal = ChildEnv(parentEnv), true
-			env.Set(sn.Value, err.(error).Error())
-			for _, n := range catchBody {
-				node = eval(n, env)
+// checkRecur asserts that all calls to recur in nodes (the body of a loop or fn) are in tail position.
+// Macros are expanded (without modifying nodes) as their expansion determines what is in tail position.
+// Bodies that do not mention recur at all are not checked.
+func checkRecur(nodes []Node, env *Env) {
+	if !containsSymbol(nodes, "recur") {
+		return
+	}
+	var check func(n Node, isTail bool)
+	checkBody := func(ns []Node, isTail bool) {
+		for i, n := range ns {
+			check(n, isTail && i == len(ns)-1)
+		}
+	}
+	check = func(n Node, isTail bool) {
+		switch n := n.(type) {
+		case ListNode:
+			if f, ok := lookupMacro(n, env); ok {
+				check(f(n.Nodes[1:], env), isTail)
+				return
 			}
+			switch callTo(n) {
+			case "quote":
+			case "recur":
+				assert(isTail, "recur must be called in tail position: %s", n)
+				checkBody(n.Nodes[1:], false)
+			case "if":
+				checkBody(n.Nodes[1:2], false)
+				for _, cn := range n.Nodes[2:] {
+					check(cn, isTail)
+				}
+			case "do":
+				checkBody(n.Nodes[1:], isTail)
+			case "let", "loop":
+				if len(n.Nodes) >= 2 {
+					check(n.Nodes[1], false)
+				}
+				if len(n.Nodes) >= 3 {
+					checkBody(n.Nodes[2:], isTail || callTo(n) == "loop")
+				}
+			case "fn", "macro":
+				_, arities := parseFn(n.Nodes[1:])
+				for _, a := range arities {
+					checkBody(a.body, true)
+				}
+			default:
+				checkBody(n.Nodes, false)
+			}
+		case VectorNode, SetNode:
+			checkBody(n.Seq(), false)
+		case ArrayMapNode:
+			checkBody(n.Nodes, false)
 		}
-	}()
-	env = ChildEnv(parentEnv)
-	for _, n := range body {
-		node = eval(n, env)
 	}
-	return node, env, true
+	checkBody(nodes, true)
 }
 
-func quote(nodes []Node, env *Env) (Node, *Env, bool) {
-	assert(len(nodes) == 1, "wrong number of arguments for quote")
-	return nodes[0], env, true
+func containsSymbol(nodes []Node, symbol string) bool {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case SymbolNode:
+			if n.Value == symbol {
+				return true
+			}
+		case ListNode, VectorNode, ArrayMapNode, SetNode:
+			if containsSymbol(n.Seq(), symbol) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,208 @@
+package core
+
+// Channels are plain Go channels: chan creates a chan gowen.Node - channels of other types (e.g. returned by Go fns)
+// can be used as well, values are converted when they are sent or received. Blocking channel operations stop with
+// an error once the evaluation they are part of is cancelled. Goroutines started via go & co are not part of the
+// evaluation that started them - they run detached (see gowen.Env.Detach), i.e. they keep running once it returned
+// but stop with its context and count against its budget. They recover from panics: the error is sent instead of a value.
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var nodeType = reflect.TypeOf((*gowen.Node)(nil)).Elem()
+
+var async = map[string]Any{
+	"chan": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		size := 0
+		if len(ns) == 1 {
+			size = intArg(ns[0])
+		}
+		return gowen.LiteralNode{Value: make(chan gowen.Node, size)}
+	},
+	// timeout returns a channel that is closed after ms milliseconds.
+	"timeout": func(ms int64) chan gowen.Node {
+		c := make(chan gowen.Node)
+		time.AfterFunc(time.Duration(ms)*time.Millisecond, func() { close(c) })
+		return c
+	},
+	">!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for >!")
+		sel(env, sendCase(ns[0], ns[1]))
+		return gowen.LiteralNode{Value: true}
+	},
+	// <! returns nil once the channel is closed.
+	"<!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for <!")
+		_, v := sel(env, recvCase(ns[0]))
+		return v
+	},
+	"close!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for close!")
+		toChan(ns[0]).Close()
+		return gowen.LiteralNode{}
+	},
+	// alts! waits for the first of multiple channel operations - ports are channels (receive) or [channel value]
+	// vectors (send). It returns the received value (true for sends) and the port.
+	"alts!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for alts!")
+		ports := ns[0].Seq()
+		cases := make([]reflect.SelectCase, len(ports))
+		for i, port := range ports {
+			if vn, ok := port.(gowen.VectorNode); ok {
+				assert(vn.Count() == 2, "send port of alts! must be a [channel value] vector: %s", port)
+				cases[i] = sendCase(vn.Nth(0), vn.Nth(1))
+			} else {
+				cases[i] = recvCase(port)
+			}
+		}
+		i, v := sel(env, cases...)
+		return gowen.NewVector(v, ports[i])
+	},
+	// go* calls f in a new goroutine and returns a channel that receives its result.
+	"go*": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for go*")
+		f, env, result := ns[0], gowen.ChildEnv(env.Detach()), make(chan gowen.Node, 1)
+		go func() {
+			defer close(result)
+			result <- call(f, nil, env)
+		}()
+		return gowen.LiteralNode{Value: result}
+	},
+	// pipeline calls f with the values of the channel from on n goroutines and sends the results to the channel to
+	// in order. to is closed once from is - or after the first error of f (or of receiving from from or sending a
+	// result to to) was sent to it instead of a result. The rest of from is not consumed in that case.
+	"pipeline": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 4, "wrong number of arguments for pipeline")
+		n, to, f, from := intArg(ns[0]), ns[1], ns[2], ns[3]
+		assert(n > 0, "pipeline requires at least one goroutine")
+		toChan(to)
+		results, done := make(chan chan gowen.Node, n-1), make(chan struct{})
+		env = gowen.ChildEnv(env.Detach())
+		go func() {
+			defer close(results)
+			for {
+				v, ok, err := receive(env, from, done)
+				if err != nil {
+					v = gowen.LiteralNode{Value: err}
+				} else if !ok {
+					return
+				}
+				result := make(chan gowen.Node, 1)
+				select {
+				case results <- result:
+				case <-done:
+					return
+				}
+				if err != nil {
+					result <- v
+					return
+				}
+				go func(env *gowen.Env) { result <- call(f, []gowen.Node{v}, env) }(env.Detach())
+			}
+		}()
+		go func() {
+			defer toChan(to).Close()
+			defer close(done)
+			for result := range results {
+				v := <-result
+				if err := send(env, to, v); err != nil {
+					send(env, to, gowen.LiteralNode{Value: err})
+					return
+				} else if _, ok := v.ToGo().(error); ok {
+					return
+				}
+			}
+		}()
+		return to
+	},
+}
+
+func toChan(n gowen.Node) reflect.Value {
+	c := reflect.ValueOf(n.ToGo())
+	assert(c.Kind() == reflect.Chan, "%s is not a channel", n)
+	return c
+}
+
+func sendCase(c, n gowen.Node) reflect.SelectCase {
+	cv := toChan(c)
+	v := reflect.ValueOf(&n).Elem()
+	if t := cv.Type().Elem(); t != nodeType {
+		v = reflect.ValueOf(n.ToGo())
+		if !v.IsValid() {
+			v = reflect.Zero(t)
+		}
+		assert(v.Type().ConvertibleTo(t), "cannot send %s to %s", n, cv.Type())
+		v = v.Convert(t)
+	}
+	return reflect.SelectCase{Dir: reflect.SelectSend, Chan: cv, Send: v}
+}
+
+func recvCase(c gowen.Node) reflect.SelectCase {
+	return reflect.SelectCase{Dir: reflect.SelectRecv, Chan: toChan(c)}
+}
+
+// sel waits for the first of cases - or panics once the evaluation running in env is cancelled.
+func sel(env *gowen.Env, cases ...reflect.SelectCase) (int, gowen.Node) {
+	i, n, _ := selOK(env, cases...)
+	return i, n
+}
+
+func selOK(env *gowen.Env, cases ...reflect.SelectCase) (int, gowen.Node, bool) {
+	ctx := env.Context()
+	i, v, ok := reflect.Select(append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}))
+	switch {
+	case i == len(cases):
+		panic(ctx.Err())
+	case cases[i].Dir == reflect.SelectSend:
+		return i, gowen.LiteralNode{Value: true}, true
+	case !ok:
+		return i, gowen.LiteralNode{}, false
+	default:
+		return i, gowen.ToNode(v.Interface()), true
+	}
+}
+
+// send sends n to c - or returns the error sending it panicked with (e.g. if c cannot hold n).
+func send(env *gowen.Env, c, n gowen.Node) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = toError(x)
+		}
+	}()
+	sel(env, sendCase(c, n))
+	return nil
+}
+
+// receive receives a value from c - ok is false once c or done is closed. err is the error receiving panicked with
+// (e.g. because the evaluation was cancelled).
+func receive(env *gowen.Env, c gowen.Node, done chan struct{}) (v gowen.Node, ok bool, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = toError(x)
+		}
+	}()
+	i, v, ok := selOK(env, recvCase(c), recvCase(gowen.LiteralNode{Value: done}))
+	return v, i == 0 && ok, nil
+}
+
+// call applies f to args and returns the result - or the error f panicked with.
+func call(f gowen.Node, args []gowen.Node, env *gowen.Env) (n gowen.Node) {
+	defer func() {
+		if x := recover(); x != nil {
+			n = gowen.LiteralNode{Value: toError(x)}
+		}
+	}()
+	return gowen.Apply(f, args, env)
+}
+
+func toError(x Any) error {
+	if err, ok := x.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", x)
+}
@@ -0,0 +1,64 @@
+package core
+
+import "github.com/niklasfasching/gowen"
+
+var atoms = map[string]Any{
+	"atom": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns)%2 == 1, "atom must be called with a value and options")
+		a := gowen.NewAtom(ns[0])
+		for i := 1; i < len(ns); i += 2 {
+			assert(ns[i].Equal(gowen.KeywordNode{Value: "validator"}), "unknown atom option %s", ns[i])
+			a.SetValidator(validator(ns[i+1]), env)
+		}
+		return a
+	},
+	"deref": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		if v, ok := ns[0].ToGo().(gowen.Var); ok {
+			return v.Deref()
+		}
+		return toAtom(ns[0]).Deref()
+	},
+	"swap!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) >= 2, "wrong number of arguments for swap!")
+		return toAtom(ns[0]).Swap(func(v gowen.Node) gowen.Node {
+			return gowen.Apply(ns[1], append([]gowen.Node{v}, ns[2:]...), env)
+		}, env)
+	},
+	"reset!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for reset!")
+		return toAtom(ns[0]).Reset(ns[1], env)
+	},
+	"set-validator!": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for set-validator!")
+		toAtom(ns[0]).SetValidator(validator(ns[1]), env)
+		return gowen.LiteralNode{}
+	},
+	// add-watch calls f with the key, the atom, the old and the new value after each change - in the env of the
+	// swap! or reset! that changed it.
+	"add-watch": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 3, "wrong number of arguments for add-watch")
+		f := ns[2]
+		toAtom(ns[0]).AddWatch(ns[1], func(key gowen.Node, a gowen.AtomNode, old, new gowen.Node, env *gowen.Env) {
+			gowen.Apply(f, []gowen.Node{key, a, old, new}, env)
+		})
+		return ns[0]
+	},
+	"remove-watch": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for remove-watch")
+		toAtom(ns[0]).RemoveWatch(ns[1])
+		return ns[0]
+	},
+}
+
+func toAtom(n gowen.Node) gowen.AtomNode {
+	a, ok := n.(gowen.AtomNode)
+	assert(ok, "%s is not an atom", n)
+	return a
+}
+
+func validator(f gowen.Node) func(gowen.Node, *gowen.Env) bool {
+	if isNil(f) {
+		return nil
+	}
+	return func(v gowen.Node, env *gowen.Env) bool { return isTruthy(gowen.Apply(f, []gowen.Node{v}, env)) }
+}
@@ -3,7 +3,6 @@ package core
 import (
 	"fmt"
 	"io/ioutil"
-	"math"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -16,46 +15,64 @@ type Any = interface{}
 //go:generate go run main.go
 
 func init() {
-	gowen.Register(values, "")
+	gowen.RegisterLibrary("core", numbers, "")
+	gowen.RegisterLibrary("core", sets, "")
+	gowen.RegisterLibrary("core", seqs, "")
+	gowen.RegisterLibrary("core", atoms, "")
+	gowen.RegisterLibrary("core", async, "")
+	gowen.RegisterLibrary("core", docs, "")
+	gowen.RegisterLibrary("core", regexes, "")
+	gowen.RegisterLibrary("core", values, "")
+	gowen.RegisterLibraryMeta("core", builtinMeta)
 }
 
+// Unsafe lists the symbols and package prefixes of core that access the file system or other processes, block or
+// run code outside of the evaluation that calls them (goroutines & timers).
+// e.g. gowen.NewSandboxRuntime(gowen.Sandbox{Deny: core.Unsafe})
+var Unsafe = []string{"os/", "exec/", "ioutil/", "slurp", "spit", "go*", "pipeline",
+	"time/after-func", "time/sleep", "time/tick", "time/new-ticker"}
+
 var values = map[string]Any{
-	"=":   func(x1 Any, x2 Any) bool { return reflect.DeepEqual(x1, x2) },
-	"<":   func(x1 float64, x2 float64) bool { return x1 < x2 },
-	">":   func(x1 float64, x2 float64) bool { return x1 > x2 },
-	"<=":  func(x1 float64, x2 float64) bool { return x1 <= x2 },
-	">=":  func(x1 float64, x2 float64) bool { return x1 >= x2 },
-	"mod": func(x1 float64, x2 float64) float64 { return float64(int(x1) % int(x2)) },
-	"+":   func(vs ...float64) float64 { return calc(func(x, y float64) float64 { return x + y }, vs) },
-	"-":   func(vs ...float64) float64 { return calc(func(x, y float64) float64 { return x - y }, vs) },
-	"*":   func(vs ...float64) float64 { return calc(func(x, y float64) float64 { return x * y }, vs) },
-	"/":   func(vs ...float64) float64 { return calc(func(x, y float64) float64 { return x / y }, vs) },
-	"min": func(vs ...float64) float64 { return calc(func(x, y float64) float64 { return math.Min(x, y) }, vs) },
-	"max": func(vs ...float64) float64 { return calc(func(x, y float64) float64 { return math.Max(x, y) }, vs) },
-
-	"list":   func(ns []gowen.Node, env *gowen.Env) gowen.Node { return gowen.ListNode{ns} },
-	"symbol": func(name string) Any { return gowen.SymbolNode{name} },
-	"vector": func(ns []gowen.Node, env *gowen.Env) gowen.Node { return gowen.VectorNode{ns} },
+	"=": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		for _, n := range ns[1:] {
+			if !ns[0].Equal(n) {
+				return gowen.LiteralNode{Value: false}
+			}
+		}
+		return gowen.LiteralNode{Value: true}
+	},
+
+	"list":   func(ns []gowen.Node, env *gowen.Env) gowen.Node { return gowen.ListNode{Nodes: ns} },
+	"symbol": func(name string) Any { return gowen.SymbolNode{Value: name} },
+	"vector": func(ns []gowen.Node, env *gowen.Env) gowen.Node { return gowen.NewVector(ns...) },
 	"type": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
 		switch n := ns[0].(type) {
 		case gowen.VectorNode:
-			return gowen.LiteralNode{"vector"}
+			return gowen.LiteralNode{Value: "vector"}
 		case gowen.ListNode:
-			return gowen.LiteralNode{"list"}
+			return gowen.LiteralNode{Value: "list"}
+		case gowen.LazySeqNode:
+			return gowen.LiteralNode{Value: "lazy-seq"}
 		case gowen.MapNode, gowen.ArrayMapNode:
-			return gowen.LiteralNode{"hashmap"}
+			return gowen.LiteralNode{Value: "hashmap"}
+		case gowen.SetNode:
+			return gowen.LiteralNode{Value: "set"}
 		case gowen.SymbolNode:
-			return gowen.LiteralNode{"symbol"}
+			return gowen.LiteralNode{Value: "symbol"}
 		case gowen.KeywordNode:
-			return gowen.LiteralNode{"keyword"}
+			return gowen.LiteralNode{Value: "keyword"}
+		case gowen.AtomNode:
+			return gowen.LiteralNode{Value: "atom"}
 		case gowen.LiteralNode:
 			switch v := reflect.ValueOf(n.Value); {
 			case v.Kind() == reflect.Slice:
-				return gowen.LiteralNode{"list"}
+				return gowen.LiteralNode{Value: "list"}
+			case v.Kind() == reflect.Map && v.Type().Elem() == reflect.TypeOf(struct{}{}):
+				return gowen.LiteralNode{Value: "set"}
 			case v.Kind() == reflect.Map:
-				return gowen.LiteralNode{"hashmap"}
+				return gowen.LiteralNode{Value: "hashmap"}
 			default:
-				return gowen.LiteralNode{fmt.Sprintf("%T", n.Value)}
+				return gowen.LiteralNode{Value: fmt.Sprintf("%T", n.Value)}
 			}
 		default:
 			panic("bad node for type")
@@ -68,26 +85,30 @@ var values = map[string]Any{
 	"print": func(args ...Any) { fmt.Println(args...) },
 	"throw": func(template string, vs ...Any) { panic(fmt.Errorf(template, vs...)) },
 
-	"hashmap": func(kvs ...Any) Any {
+	"hashmap": func(kvs []gowen.Node, env *gowen.Env) gowen.Node {
 		assert(len(kvs)%2 == 0, "hashmap must be called with even number of kvs")
-		m := map[Any]Any{}
-		for i := 0; i < len(kvs); i += 2 {
-			m[kvs[i]] = kvs[i+1]
-		}
-		return m
+		return gowen.NewMap(kvs...)
 	},
-	"merge": func(m1 map[Any]Any, m2 map[Any]Any) Any {
-		for k, v := range m2 {
-			m1[k] = v
+	"merge": func(ms []gowen.Node, env *gowen.Env) gowen.Node {
+		out := gowen.MapNode{}
+		for _, m := range ms {
+			for _, kv := range m.Seq() {
+				out = out.Conj(kv).(gowen.MapNode)
+			}
 		}
-		return m1
+		return out
 	},
+	"hash-set": func(ns []gowen.Node, env *gowen.Env) gowen.Node { return gowen.NewSet(ns...) },
 
 	"format": func(format string, args ...Any) string { return fmt.Sprintf(format, args...) },
 	"str": func(xs ...Any) string {
 		s := ""
 		for _, x := range xs {
-			s += fmt.Sprintf("%v", x)
+			if r, ok := x.(rune); ok {
+				s += string(r)
+			} else {
+				s += fmt.Sprintf("%v", x)
+			}
 		}
 		return s
 	},
@@ -96,15 +117,6 @@ var values = map[string]Any{
 	"slurp": slurp,
 }
 
-func calc(fn func(float64, float64) float64, vs []float64) float64 {
-	assert(len(vs) > 0, "wrong number of arguments for calc (+, -, ...)")
-	acc := vs[0]
-	for _, v := range vs[1:] {
-		acc = fn(acc, v)
-	}
-	return acc
-}
-
 func assert(assertion bool, format string, vs ...Any) {
 	if !assertion {
 		panic(fmt.Errorf(format, vs...))
@@ -1,8 +1,15 @@
 package core_test
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/niklasfasching/gowen"
 )
@@ -15,16 +22,114 @@ type coreTest struct {
 
 var coreTests = []coreTest{
 	{"+", "(+ 10 (- 20 10) (* 5 2) (/ 20 2))", "40"},
-	{"count", "[(count [1 2 3]) (count '(1 2))]", "[3 2]"},
+	{"count", "[(count [1 2 3]) (count '(1 2)) (count (map (fn [x] x) (range 100)))]", "[3 2 100]"},
+	{"integer arithmetic", "[(+) (*) (- 3) (quot 7 2) (rem -7 2) (mod -7 2)]", "[0 1 -3 3 -1 1]"},
+	{"ratios", "[(/ 1 3) (/ 6 3) (+ 1/3 2/3) (* 1/2 3) (/ 4)]", "[1/3 2 1 3/2 1/4]"},
+	{"doubles", "[(/ 1.0 4) (+ 1/2 0.5) (mod 7.5 2) (mod -7.5 2) (quot 7.5 2)]", "[0.25 1.0 1.5 0.5 3.0]"},
+	{"big integers", "[(+ 9223372036854775807 1) (* 9223372036854775807 2) (- (+ 9223372036854775807 1) 1)]",
+		"[9223372036854775808 18446744073709551614 9223372036854775807]"},
+	{"big integers & ratios", "[(/ 9223372036854775808 2) (/ 1 9223372036854775808) (* 2.0 9223372036854775808)]",
+		"[4611686018427387904 1/9223372036854775808 18446744073709551616.0]"},
+	{"comparison", "[(< 1 3/2 2.0 9223372036854775808) (<= 1 1 2) (> 2 1/2) (== 1 1.0 2/2) (= 1 1.0)]", "[true true true true false]"},
+	{"min & max", "[(min 2 1/2 3.0) (max 1 9223372036854775808)]", "[1/2 9223372036854775808]"},
+	{"coercion", "[(int 7/2) (int -2.5) (double 1/4) (number? 1/2) (integer? 9223372036854775808) (ratio? 1/2) (double? 1)]",
+		"[3 -2 0.25 true true true false]"},
+	{"interop", "[(strings/repeat \"a\" 3) (+ (strings/index \"ab\" \"b\") 1/2)]", "[\"aaa\" 3/2]"},
+	{"interop number ranges", `[(strings/contains-rune "a" 97) (strings/repeat "a" 2.5)
+                                (try (strings/contains-rune "a" 4294967393) (catch e (ex-message e)))
+                                (try (strings/repeat "a" 1e30) (catch e (ex-message e)))
+                                (try (get [1 2] 18446744073709551617) (catch e (ex-message e)))]`,
+		`[true "aa" "4294967393 overflows int32" "1e+30 overflows int" "18446744073709551617 overflows int"]`},
+	{"callbacks", `[(vec (strings/fields-func "a1b2c" (fn [r] (< 47 r 58)))) (strings/map (fn [r] (+ r 1)) "abc")]`, `[["a" "b" "c"] "bcd"]`},
+	{"sort/slice", `(let [xs (strings/fields "c a b")] (sort/slice xs (fn [i j] (< (strings/compare (get xs i) (get xs j)) 0))) (vec xs))`,
+		`["a" "b" "c"]`},
+	{"reify", `[(io/write-string (reify io/writer {:write (fn [bs] (* 2 (count bs)))}) "foo")
+                (let [a (atom [3 1 2])]
+                  (sort/sort (reify sort/interface {:len (fn [] (count @a))
+                                                    :less (fn [i j] (< (get @a i) (get @a j)))
+                                                    :swap (fn [i j] (swap! a (fn [v] (assoc v i (get v j) j (get v i)))))}))
+                  @a)]`, `[6 [1 2 3]]`},
 	{"let", "(let [x 1 y 2] (+ x y))", "3"},
 	{"do", "(do 1 2 3)", "3"},
 	{"and", "(and 1 2 false 3)", "false"},
 	{"or", "(or false nil 3 false)", "3"},
-	{"reduce", "(reduce (fn [x y] (+ x y)) 0 [1 2 3 4])", "10"},
+	{"threading", "[(-> 1 (+ 2) (* 3)) (->> [1 2] (concat [0]) vec)]", "[9 [0 1 2]]"},
+	{"macro hygiene", "(let [and# 5 or# 6] [(and true and#) (or false or#) (doto [1] (conj and#))])", "[5 6 [1]]"},
+	{"reduce", "[(reduce (fn [x y] (+ x y)) 0 [1 2 3 4]) (reduce + 0 (range 1000)) (reduce + 0 nil)]", "[10 499500 0]"},
+	{"reduce range", "(reduce + 0 (range 10000))", "49995000"},
+	{"range", "[(vec (range 3)) (vec (range 1 3)) (vec (range 3 0 -1)) (vec (range 0 1 1/2))]", "[[0 1 2] [1 2] [3 2 1] [0 1/2]]"},
+	{"repeat", "(repeat 3 :x)", "[:x :x :x]"},
 	{"map", "(map (fn [x] (+ x 1)) [1 2 3])", "'(2 3 4)"},
 	{"filter", "(filter (fn [x] (> x 1)) [0 1 2 3])", "'(2 3)"},
 	{"type", "(type :foo)", `"keyword"`},
 	{"hashmap", `{"a" (+ 1 2) 2 "b"}`, `{2 "b" "a" 3}`},
+	{"assoc & dissoc", `[(assoc {:a 1} :b 2 :a 3) (assoc [1 2] 0 :x 2 :y) (assoc nil :a 1) (dissoc {:a 1 :b 2} :a :c)]`,
+		`[{:a 3 :b 2} [:x 2 :y] {:a 1} {:b 2}]`},
+	{"merge", `[(merge {:a 1} nil {:b 2 :a 3}) (hashmap :a 1)]`, `[{:a 3 :b 2} {:a 1}]`},
+	{"persistent", `(let [v [1 2] m {:a 1}] (conj v 3) (assoc m :b 2) [v m])`, `[[1 2] {:a 1}]`},
+	{"conj many", `(count (reduce conj [] (range 10000)))`, `10000`},
+	{"hash-set", `(let [s (hash-set 1 2 2 3)] [(count s) (get s 2) (get s 4) (type s)])`, `[3 2 nil "set"]`},
+	{"=", `[(= [1 2] '(1 2)) (= {:a [1]} {:a '(1)}) (= 1 1 1) (= 1 1 2) (= (hash-set [1]) (hash-set '(1)))]`, `[true true true false true]`},
+	{"collection keys", `(let [m {[1 2] :a}] [(get m [1 2]) (get m '(1 2)) (get (hash-set {:a 1}) {:a 1})])`, `[:a :a {:a 1}]`},
+	{"set literal", `(let [x 1] [#{x (+ x 1)} (set [1 1 2]) (contains? #{1 nil} nil) (contains? #{1} 2)])`,
+		`[#{1 2} #{1 2} true false]`},
+	{"quasiquote set", "(let [x 1 xs [2 3]] `#{~x ~@xs})", `#{1 2 3}`},
+	{"set operations", `[(union #{1 2} nil #{3}) (intersection #{1 2 3} #{2 3 4} #{3}) (difference #{1 2 3} #{2} #{3}) (disj #{1 2 3} 1 2)]`,
+		`[#{1 2 3} #{3} #{1} #{3}]`},
+	{"subset?", `[(subset? #{1} #{1 2}) (subset? #{1 3} #{1 2}) (subset? nil #{})]`, `[true false true]`},
+	{"contains?", `[(contains? {:a nil} :a) (contains? {:a 1} :b) (contains? [1 2] 1) (contains? [1 2] 2) (contains? nil 1)]`,
+		`[true false true false false]`},
+	{"lazy seqs", `[(vec (take 3 (range))) (vec (take 3 (iterate (fn [x] (* x 2)) 1))) (vec (take 5 (cycle [1 2])))]`,
+		`[[0 1 2] [1 2 4] [1 2 1 2 1]]`},
+	{"lazy seq library", `[(first (drop 5 (filter (fn [x] (= (mod x 2) 0)) (range)))) (vec (take-while (fn [x] (< x 3)) (range))) (get (map (fn [x] (* x x)) (range)) 100)]`,
+		`[10 [0 1 2] 10000]`},
+	{"lazy-seq & cons", `(defn nums [n] (lazy-seq (cons n (nums (+ n 1))))) [(vec (take 3 (nums 0))) (first (rest (nums 5))) (empty? (lazy-seq nil))]`,
+		`[[0 1 2] 6 true]`},
+	{"lazy seq requiring itself", `(def s (lazy-seq (cons (count s) nil))) (try (first s) (catch e (ex-message e)))`,
+		`"lazy seq cannot be realized while it is being realized - it requires itself"`},
+	{"lazy concat & destructuring", `(let [[a b & more] (concat [1] (range 2 1000000000))] [a b (first more) (= (take 2 more) [3 4])])`,
+		`[1 2 3 true]`},
+	{"line-seq", `(vec (line-seq (strings/new-reader "a\nb\r\n\nc")))`, `["a" "b" "" "c"]`},
+	{"atom", `(let [a (atom 1)] [(swap! a + 2 3) @a (reset! a :x) (deref a) (type a)])`, `[6 6 :x :x "atom"]`},
+	{"var", `(let [v #'+ x 1] [(@v 1 2) (deref (var x)) (str v)])`, `[3 1 "#'+"]`},
+	{"docstrings", `(defn f "doc" [x] x) (defmacro m "mdoc" ([] 1) ([x] x)) [(f 1) (m 2) (get (meta #'f) :doc) (get (meta #'m) :arglists)]`,
+		`[1 2 "doc" '([] [x])]`},
+	{"go docs", `(get (meta #'strings/repeat) :signature)`, `"func Repeat(s string, count int) string"`},
+	{"str of collection keys", `[(str {[1 2] :a}) (str #{[1]}) (str {'(1) 2 {:a 1} 3 #{1} 4})]`,
+		`["map[[1 2]::a]" "map[[1]:{}]" "map[(1):2 #{1}:4 {:a 1}:3]"]`},
+	{"characters", `[(str \a \b) (apply str (seq "héllo")) (strings/index-rune "abc" \c)]`, `["ab" "héllo" 2]`},
+	{"regexes", `[(re-find #"\d+" "ab12cd34") (re-find #"(\w)(\d)?" "a") (re-find #"x" "a") (str #"a\"b")]`,
+		`["12" ["a" "a" nil] nil "a\\\"b"]`},
+	{"re-matches & re-seq", `[(re-matches #"a|ab" "ab") (re-matches #"a" "ab") (re-seq #"(\d)" "a1b2") (re-seq #"x" "a")]`,
+		`["ab" nil '(["1" "1"] ["2" "2"]) nil]`},
+	{"re-matches with multi-line flag", `[(re-matches #"(?m)a" "a\nb") (re-matches #"(?m)a\nb" "a\nb")]`, `[nil "a\nb"]`},
+	{"re-groups of a regex", `(try (re-groups #"a") (catch e (ex-message e)))`, `"re-groups requires a matcher - got #\"a\""`},
+	{"re-groups", `(let [m (re-matcher (re-pattern "(\\d)") "1 2")] [(re-find m) (re-groups m) (re-find m) (re-find m)])`,
+		`[["1" "1"] ["1" "1"] ["2" "2"] nil]`},
+	{"atom validator", `(let [a (atom 1 :validator (fn [x] (> x 0)))] [(try (reset! a -1) (catch err :invalid)) (swap! a + 1)])`,
+		`[:invalid 2]`},
+	{"atom watches", `(let [a (atom 0) log (atom [])]
+                       (add-watch a :log (fn [k r old new] (swap! log conj [k old new])))
+                       (swap! a + 1)
+                       (reset! a 5)
+                       (remove-watch a :log)
+                       (reset! a 6)
+                       @log)`, `[[:log 0 1] [:log 1 5]]`},
+	{"go & channels", `(let [c (chan)] (go (>! c 1)) [(<! c) (<! (go (+ 1 2)))])`, `[1 3]`},
+	{"go-loop", `(let [c (chan 10)]
+                   (go-loop [i 0] (if (< i 3) (do (>! c i) (recur (+ i 1))) (close! c)))
+                   [(<! c) (<! c) (<! c) (<! c)])`, `[0 1 2 nil]`},
+	{"alts!", `(let [c (chan) d (chan 1)] (>! d :x) [(first (alts! [c d])) (first (alts! [c (timeout 10)])) (first (alts! [[d :y]]))])`,
+		`[:x nil true]`},
+	{"pipeline", `(let [from (chan) to (chan)]
+                    (go (>! from 1) (>! from 2) (>! from 3) (close! from))
+                    (pipeline 2 to (fn [x] (* x 10)) from)
+                    [(<! to) (<! to) (<! to) (<! to)])`, `[10 20 30 nil]`},
+	{"pipeline error", `(let [from (chan) to (chan)]
+                          (go (>! from 1) (>! from 0) (>! from 3) (close! from))
+                          (pipeline 1 to (fn [x] (if (= x 0) (throw "zero") (* x 10))) from)
+                          [(<! to) (strings/contains (str (<! to)) "zero") (<! to)])`, `[10 true nil]`},
+	{"go error", `(strings/contains (str (<! (go (throw "boom")))) "boom")`, `true`},
 	{"cond", `(cond false 1 nil 2 true 3)`, "3"},
 	{"spit & slurp", `(spit "/tmp/spat" "yo") (slurp "/tmp/spat")`, `"yo"`},
 }
@@ -43,6 +148,9 @@ func TestCore(t *testing.T) {
 			continue
 		}
 		result := nodes[len(nodes)-1]
+		if ls, ok := result.(gowen.LazySeqNode); ok {
+			result = gowen.ListNode{Nodes: ls.Seq()}
+		}
 		nodes, err = gowen.Parse(test.output)
 		if err != nil {
 			t.Errorf("%s: %s", test.name, err)
@@ -58,3 +166,214 @@ func TestCore(t *testing.T) {
 		}
 	}
 }
+
+func TestTypeOfGoValues(t *testing.T) {
+	env := gowen.NewEnv(false)
+	env.Set("go-set", gowen.LiteralNode{Value: map[string]struct{}{"a": {}}})
+	env.Set("go-map", gowen.LiteralNode{Value: map[string]int{"a": 1}})
+	env.Set("go-slice", gowen.LiteralNode{Value: []int{1}})
+	n, err := gowen.ParseAndEval(`[(type go-set) (type go-map) (type go-slice)]`, env)
+	if expected := `["set" "hashmap" "list"]`; err != nil || n.String() != expected {
+		t.Errorf("got %v (%v) expected %s", n, err, expected)
+	}
+}
+
+func TestLazySeqChunks(t *testing.T) {
+	env := gowen.NewEnv(false)
+	realized := 0
+	env.Set("realize", func(x int64) int64 { realized++; return x })
+	if _, err := gowen.ParseAndEval(`(def xs (map realize (range 100))) (first xs) (first (rest xs))`, env); err != nil {
+		t.Fatal(err)
+	}
+	if realized != 32 {
+		t.Errorf("expected first to realize one chunk of 32 elements - realized %d", realized)
+	}
+	if _, err := gowen.ParseAndEval(`(count xs) (count xs)`, env); err != nil || realized != 100 {
+		t.Errorf("expected each element to be realized once - realized %d (%v)", realized, err)
+	}
+}
+
+func TestLazySeqConcurrentRealization(t *testing.T) {
+	env, realized := gowen.NewEnv(false), int64(0)
+	env.Set("realize", func(x int64) int64 { time.Sleep(10 * time.Millisecond); atomic.AddInt64(&realized, 1); return x })
+	if _, err := gowen.ParseAndEval(`(def xs (map realize (range 3)))`, env); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if n, err := gowen.ParseAndEval(`(vec xs)`, env); err != nil || n.String() != "[0 1 2]" {
+				t.Errorf("expected concurrent realizations to wait for each other: got %v (%v)", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if realized != 3 {
+		t.Errorf("expected each element to be realized once - realized %d", realized)
+	}
+}
+
+func TestAtomConcurrentSwap(t *testing.T) {
+	env := gowen.NewEnv(false)
+	if _, err := gowen.ParseAndEval(`(def counter (atom 0)) (defn inc! [] (swap! counter + 1))`, env); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := gowen.ParseAndEval(`(inc!)`, env); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n, err := gowen.ParseAndEval(`@counter`, env); err != nil || n.ToGo() != int64(1000) {
+		t.Errorf("expected 1000 increments, got %v (%v)", n, err)
+	}
+}
+
+func TestAtomCallbacksAcrossEvaluations(t *testing.T) {
+	env := gowen.NewEnv(false)
+	eval := func(input string, budget gowen.Budget) (gowen.Node, error) {
+		ctx, cancel := context.WithCancel(gowen.WithBudget(context.Background(), budget))
+		defer cancel()
+		return gowen.ParseAndEvalContext(ctx, input, env)
+	}
+	_, err := eval(`(def changes (atom []))
+                  (def a (atom 1 :validator (fn [x] (< x 3))))
+                  (add-watch a :log (fn [k a old new] (swap! changes conj [old new])))`, gowen.Budget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := eval(`(swap! a + 1) @changes`, gowen.Budget{}); err != nil || n.String() != "[[1 2]]" {
+		t.Errorf("expected the watch to be called in a later evaluation: got %v (%v)", n, err)
+	}
+	if _, err := eval(`(reset! a 3)`, gowen.Budget{}); err == nil {
+		t.Errorf("expected the validator to reject 3")
+	} else if n, _ := eval(`[@a @changes]`, gowen.Budget{}); n.String() != "[2 [[1 2]]]" {
+		t.Errorf("expected a rejected value not to be set or watched: got %v", n)
+	}
+	// watches are called with the state of the evaluation that changes the atom
+	eval(`(add-watch a :forever (fn [k a old new] (loop [] (recur))))`, gowen.Budget{})
+	if _, err := eval(`(reset! a 1)`, gowen.Budget{Steps: 1000}); !gowen.IsAborted(err) {
+		t.Errorf("expected the watch to be stopped by the budget of reset!: got %v", err)
+	}
+}
+
+func TestGoAcrossEvaluations(t *testing.T) {
+	env := gowen.NewEnv(false)
+	_, err := gowen.ParseAndEvalContext(context.Background(), `(def c (go (<! (timeout 20)) 42))`, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := gowen.ParseAndEval(`(<! c)`, env); err != nil || n.String() != "42" {
+		t.Errorf("expected the go block to outlive the evaluation that started it: got %v (%v)", n, err)
+	}
+}
+
+func TestGoStopsWithEvaluation(t *testing.T) {
+	inputs := map[string]string{
+		"go":       `(go (loop [] (swap! counter (fn [n] (+ n 1))) (recur)))`,
+		"pipeline": `(let [c (chan 1)] (>! c 1) (pipeline 1 (chan 1) (fn [x] (loop [] (swap! counter (fn [n] (+ n 1))) (recur))) c))`,
+	}
+	contexts := map[string]func() (context.Context, context.CancelFunc){
+		"budget": func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(gowen.WithBudget(context.Background(), gowen.Budget{Steps: 1000}))
+		},
+		"deadline": func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		},
+	}
+	for name, input := range inputs {
+		for stop, newContext := range contexts {
+			env := gowen.NewEnv(false)
+			if _, err := gowen.ParseAndEval(`(def counter (atom 0))`, env); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := newContext()
+			if _, err := gowen.ParseAndEvalContext(ctx, input, env); err != nil {
+				t.Fatalf("%s (%s): %s", name, stop, err)
+			}
+			time.Sleep(150 * time.Millisecond)
+			n1, _ := gowen.ParseAndEval(`@counter`, env)
+			time.Sleep(50 * time.Millisecond)
+			n2, _ := gowen.ParseAndEval(`@counter`, env)
+			if n1.String() != n2.String() || (stop == "budget" && n1.ToGo().(int64) > 1000) {
+				t.Errorf("%s (%s): expected the goroutine to stop with the evaluation: counted %v then %v", name, stop, n1, n2)
+			}
+			cancel()
+		}
+	}
+}
+
+func TestReifyAcrossEvaluations(t *testing.T) {
+	env := gowen.NewEnv(false)
+	ctx := gowen.WithBudget(context.Background(), gowen.Budget{Steps: 1000})
+	_, err := gowen.ParseAndEvalContext(ctx, `(def w (reify io/writer {:write (fn [bs] (count bs))}))`, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := gowen.ParseAndEval(`(io/write-string w "foo")`, env); err != nil || n.String() != "3" {
+		t.Errorf("expected the reified writer to outlive the evaluation that created it: got %v (%v)", n, err)
+	}
+	// its methods keep the budget of that evaluation
+	if _, err := gowen.ParseAndEval(`(loop [i 0] (if (< i 1000) (do (io/write-string w "foo") (recur (+ i 1)))))`, env); !gowen.IsAborted(err) {
+		t.Errorf("expected the reified writer to exceed the budget of the evaluation that created it: got %v", err)
+	}
+}
+
+func TestDoc(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	_, err = gowen.ParseAndEval(`(defn f "Returns x.
+
+Really." [x] x) (doc f) (source f) (doc defn) (find-doc "^Returns the second") (doc +) (doc count)`, gowen.NewEnv(false))
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	expected := `-------------------------
+f
+([x])
+  Returns x.
+
+  Really.
+(defn f "Returns x.\n\nReally." [x] x)
+-------------------------
+defn
+([name & decl])
+Macro
+  Defines the fn name. An optional docstring may precede the params (or arities).
+-------------------------
+second
+([coll])
+  Returns the second element of coll.
+-------------------------
++
+  Returns the sum of the numbers xs - 0 for none.
+-------------------------
+count
+  Returns the number of elements of coll.
+`
+	if string(out) != expected {
+		t.Errorf("got\n%s\nexpected\n%s", out, expected)
+	}
+	for _, v := range gowen.NewEnv(false).Vars() {
+		isGoFn := reflect.ValueOf(v.Deref().ToGo()).Kind() == reflect.Func
+		if isGoFn && !strings.Contains(v.Name, "/") && v.Meta().Get(gowen.KeywordNode{Value: "doc"}).ToGo() == nil {
+			t.Errorf("%s: missing doc", v.Name)
+		}
+	}
+}
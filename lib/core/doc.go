@@ -0,0 +1,164 @@
+package core
+
+// doc, source & find-doc print what is known about vars: the metadata of their definitions (e.g. docstrings and
+// arglists - see gowen.Var) and the signatures & docs of Go values attached by GenerateGoPackageRegisterFileContent.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var docs = map[string]Any{
+	"doc": gowen.SpecialFn(func(ns []gowen.Node, env *gowen.Env) (gowen.Node, *gowen.Env, bool) {
+		fmt.Print(docString(lookupVar("doc", ns, env)))
+		return gowen.LiteralNode{}, env, true
+	}),
+	"source": gowen.SpecialFn(func(ns []gowen.Node, env *gowen.Env) (gowen.Node, *gowen.Env, bool) {
+		v := lookupVar("source", ns, env)
+		if form := v.Source(); form != nil {
+			fmt.Println(form)
+		} else {
+			fmt.Printf("source of %s not found\n", v.Name)
+		}
+		return gowen.LiteralNode{}, env, true
+	}),
+	// find-doc prints the docs of the vars whose name or doc match the regexp pattern.
+	"find-doc": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for find-doc")
+		pattern, ok := ns[0].ToGo().(string)
+		assert(ok, "find-doc requires a pattern string - got %s", ns[0])
+		re, err := regexp.Compile(pattern)
+		assert(err == nil, "find-doc: bad pattern: %v", err)
+		for _, v := range env.Vars() {
+			if re.MatchString(v.Name) || re.MatchString(metaString(v.Meta(), "doc")) {
+				fmt.Print(docString(v))
+			}
+		}
+		return gowen.LiteralNode{}
+	},
+}
+
+// builtinMeta holds the docs of the Go builtins of core - Go packages registered via generate get theirs from go doc.
+var builtinMeta = map[string]map[string]string{
+	"+":        {"doc": "Returns the sum of the numbers xs - 0 for none."},
+	"*":        {"doc": "Returns the product of the numbers xs - 1 for none."},
+	"-":        {"doc": "Returns x minus the numbers ys - or the negation of x if there are none."},
+	"/":        {"doc": "Returns x divided by the numbers ys - or 1/x if there are none. Integers are divided to ratios."},
+	"==":       {"doc": "Returns true if the numbers xs are equal - regardless of their type."},
+	"<":        {"doc": "Returns true if the numbers xs are in increasing order."},
+	">":        {"doc": "Returns true if the numbers xs are in decreasing order."},
+	"<=":       {"doc": "Returns true if the numbers xs are in non-decreasing order."},
+	">=":       {"doc": "Returns true if the numbers xs are in non-increasing order."},
+	"min":      {"doc": "Returns the least of the numbers xs."},
+	"max":      {"doc": "Returns the greatest of the numbers xs."},
+	"quot":     {"doc": "Returns the quotient of x divided by y - truncated towards zero."},
+	"rem":      {"doc": "Returns the remainder of x divided by y - it has the sign of x."},
+	"mod":      {"doc": "Returns x modulo y - it has the sign of y."},
+	"int":      {"doc": "Returns the number x as an integer - without its fraction."},
+	"double":   {"doc": "Returns the number x as a double."},
+	"number?":  {"doc": "Returns true if x is a number."},
+	"integer?": {"doc": "Returns true if x is an integer."},
+	"ratio?":   {"doc": "Returns true if x is a ratio."},
+	"double?":  {"doc": "Returns true if x is a double."},
+
+	"union":        {"doc": "Returns the union of the sets."},
+	"intersection": {"doc": "Returns the intersection of the sets."},
+	"difference":   {"doc": "Returns the set s without the elements of the other sets."},
+	"subset?":      {"doc": "Returns true if all elements of the set s1 are elements of the set s2."},
+	"disj":         {"doc": "Returns the set s without the xs."},
+	"contains?":    {"doc": "Returns true if coll contains the key - the elements of sets, the keys of maps and the indexes of vectors."},
+
+	"map":        {"doc": "Returns a lazy seq of the results of calling f with each element of coll."},
+	"filter":     {"doc": "Returns a lazy seq of the elements of coll for which pred returns a truthy value."},
+	"take":       {"doc": "Returns a lazy seq of the first n elements of coll."},
+	"drop":       {"doc": "Returns a lazy seq of the elements of coll after the first n."},
+	"take-while": {"doc": "Returns a lazy seq of the elements of coll up to the first one for which pred returns a falsy value."},
+	"range":      {"doc": "Returns a lazy seq of the numbers from start (0) up to end (infinite) by step (1)."},
+	"iterate":    {"doc": "Returns a lazy seq of x, (f x), (f (f x)) ..."},
+	"cycle":      {"doc": "Returns a lazy seq of the elements of coll repeated infinitely."},
+	"line-seq":   {"doc": "Returns a lazy seq of the lines of the io.Reader r - without line endings."},
+
+	"atom":           {"doc": "Returns an atom holding x. The :validator option sets its validator fn."},
+	"deref":          {"doc": "Returns the value of the atom or var x."},
+	"swap!":          {"doc": "Sets the value of the atom a to (f value args...) and returns it."},
+	"reset!":         {"doc": "Sets the value of the atom a to x and returns it."},
+	"set-validator!": {"doc": "Sets the validator fn of the atom a - new values for which it returns a falsy value are rejected."},
+	"add-watch":      {"doc": "Calls f with the key, the atom a, the old and the new value after each change of a."},
+	"remove-watch":   {"doc": "Removes the watch key from the atom a."},
+
+	"chan":     {"doc": "Returns a channel - buffered if a size is given."},
+	"timeout":  {"doc": "Returns a channel that is closed after ms milliseconds."},
+	">!":       {"doc": "Sends x to the channel c - blocking until it is received or buffered."},
+	"<!":       {"doc": "Returns the next value received from the channel c - or nil once it is closed."},
+	"close!":   {"doc": "Closes the channel c."},
+	"alts!":    {"doc": "Waits for the first of the ports - channels to receive from or [channel value] vectors to send. Returns [value port]."},
+	"go*":      {"doc": "Calls f in a new goroutine and returns a channel that receives its result."},
+	"pipeline": {"doc": "Sends the results of calling f with the values of the channel from on n goroutines to the channel to - in order."},
+
+	"re-pattern": {"doc": "Returns the regex of the string s."},
+	"re-matcher": {"doc": "Returns a matcher of the matches of the regex re in s - see re-find & re-groups."},
+	"re-find":    {"doc": "Returns the first match of the regex re in s - or the next match of the matcher m."},
+	"re-matches": {"doc": "Returns the match of the regex re if it matches all of s."},
+	"re-seq":     {"doc": "Returns a seq of the matches of the regex re in s."},
+	"re-groups":  {"doc": "Returns the groups of the last match of the matcher m."},
+
+	"find-doc": {"doc": "Prints the docs of the vars whose name or doc match the regex pattern."},
+	"doc":      {"doc": "Prints the docs of the var of the symbol."},
+	"source":   {"doc": "Prints the source of the var of the symbol."},
+
+	"=":        {"doc": "Returns true if the xs are equal."},
+	"list":     {"doc": "Returns a list of the xs."},
+	"symbol":   {"doc": "Returns the symbol of the string name."},
+	"vector":   {"doc": "Returns a vector of the xs."},
+	"type":     {"doc": "Returns the type of x as a string - e.g. \"vector\", \"set\" or the Go type of other values."},
+	"string":   {"doc": "Returns the string of the bytes bs."},
+	"subs":     {"doc": "Returns the substring of s from index i up to index j."},
+	"print":    {"doc": "Prints the args separated by spaces and followed by a newline."},
+	"throw":    {"doc": "Fails with the error message formatted from template and the args."},
+	"hashmap":  {"doc": "Returns a map of the keys and values kvs."},
+	"merge":    {"doc": "Returns a map of the entries of the maps - later entries win."},
+	"hash-set": {"doc": "Returns a set of the xs."},
+	"format":   {"doc": "Returns the args formatted according to the Go format string fmt."},
+	"str":      {"doc": "Returns the concatenation of the string forms of the xs."},
+	"spit":     {"doc": "Writes content to the file path - creating its directory if necessary."},
+	"slurp":    {"doc": "Returns the content of the file path."},
+}
+
+func lookupVar(name string, ns []gowen.Node, env *gowen.Env) gowen.Var {
+	assert(len(ns) == 1, "wrong number of arguments for %s", name)
+	sn, ok := ns[0].(gowen.SymbolNode)
+	assert(ok, "%s requires a symbol - got %s", name, ns[0])
+	v, ok := env.Var(sn.Value)
+	assert(ok, "could not lookup symbol %s", sn.Value)
+	return v
+}
+
+func docString(v gowen.Var) string {
+	m := v.Meta()
+	s := "-------------------------\n" + v.Name + "\n"
+	if signature := metaString(m, "signature"); signature != "" {
+		s += signature + "\n"
+	} else if arglists := m.Get(gowen.KeywordNode{Value: "arglists"}); arglists.ToGo() != nil {
+		s += arglists.String() + "\n"
+	}
+	if _, isMacro := v.Deref().ToGo().(gowen.MacroFn); isMacro {
+		s += "Macro\n"
+	}
+	if doc := metaString(m, "doc"); doc != "" {
+		for _, line := range strings.Split(doc, "\n") {
+			if line != "" {
+				line = "  " + line
+			}
+			s += line + "\n"
+		}
+	}
+	return s
+}
+
+func metaString(m gowen.MapNode, key string) string {
+	s, _ := m.Get(gowen.KeywordNode{Value: key}).ToGo().(string)
+	return s
+}
@@ -17,6 +17,8 @@ var packages = map[string]string{
 	"time":    "time",
 	"os":      "os",
 	"exec":    "os/exec",
+	"io":      "io",
+	"sort":    "sort",
 }
 
 func main() {
@@ -29,7 +31,7 @@ func main() {
 	}
 
 	err = ioutil.WriteFile("inlined_gowen__generated__.go",
-		[]byte(core.GenerateGowenInlineFileContent("core", []string{"core.gow"})),
+		[]byte(core.GenerateGowenCompiledFileContent("core", []string{"core.gow"})),
 		os.ModePerm,
 	)
 	if err != nil {
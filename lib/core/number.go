@@ -0,0 +1,415 @@
+package core
+
+// Arithmetic follows the usual contagion rules: operands are converted to the "largest" type involved -
+// int64 < *big.Int < *big.Rat (ratio) < float64 (double). Integer operations that overflow are promoted
+// to *big.Int and exact results are demoted again where possible - e.g. (/ 4 2) => 2, (* 1/2 2) => 1.
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/niklasfasching/gowen"
+)
+
+type numberKind int
+
+const (
+	intKind numberKind = iota
+	bigKind
+	ratioKind
+	doubleKind
+)
+
+var numbers = map[string]Any{
+	"+": arithmetic(add, addInts, 0, false),
+	"*": arithmetic(mul, mulInts, 1, false),
+	"-": arithmetic(sub, subInts, 0, true),
+	"/": arithmetic(div, nil, 1, true),
+
+	"==": comparison(func(c int) bool { return c == 0 }),
+	"<":  comparison(func(c int) bool { return c < 0 }),
+	">":  comparison(func(c int) bool { return c > 0 }),
+	"<=": comparison(func(c int) bool { return c <= 0 }),
+	">=": comparison(func(c int) bool { return c >= 0 }),
+
+	"min": func(x Any, xs ...Any) Any {
+		return fold(func(x, y Any) Any { return pick(compare(x, y) <= 0, x, y) }, x, xs)
+	},
+	"max": func(x Any, xs ...Any) Any {
+		return fold(func(x, y Any) Any { return pick(compare(x, y) >= 0, x, y) }, x, xs)
+	},
+
+	"quot": quot,
+	"rem":  func(x, y Any) Any { return sub(x, mul(y, quot(x, y))) },
+	"mod":  mod,
+
+	"int":    toInteger,
+	"double": toDouble,
+
+	"number?":  func(x Any) bool { _, ok := numberKindOf(x); return ok },
+	"integer?": func(x Any) bool { k, ok := numberKindOf(x); return ok && k <= bigKind },
+	"ratio?":   func(x Any) bool { k, ok := numberKindOf(x); return ok && k == ratioKind },
+	"double?":  func(x Any) bool { k, ok := numberKindOf(x); return ok && k == doubleKind },
+}
+
+// arithmetic & comparison return builtins that take nodes rather than Go values. They are called for nearly every
+// evaluated form - calling Go fns via reflection would cost more than the arithmetic itself. They do not keep
+// their args, i.e. they are BorrowingFns and calls to them do not allocate a slice for the args.
+
+// arithmetic returns a builtin that folds its args with op, starting from the first one - or from identity if
+// there is only one and the builtin has no inverse: (- 1 2) => -1, (- 1) => -1, (+ 1) => 1.
+// Calls with two int64 args use intOp (if set) unless it overflows - their result is not boxed before it is needed.
+func arithmetic(op func(Any, Any) Any, intOp func(a, b int64) (int64, bool), identity int64, hasInverse bool) gowen.BorrowingFn {
+	return func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		if len(ns) == 2 && intOp != nil {
+			a, aOk := ns[0].ToGo().(int64)
+			b, bOk := ns[1].ToGo().(int64)
+			if r, ok := intOp(a, b); aOk && bOk && ok {
+				return intNode(r)
+			}
+		}
+		acc := Any(identity)
+		if hasInverse && len(ns) == 0 {
+			panic(fmt.Errorf("wrong number of args (0)"))
+		} else if len(ns) > 1 {
+			acc, ns = ns[0].ToGo(), ns[1:]
+			toNumber(acc)
+		}
+		for _, n := range ns {
+			acc = op(acc, n.ToGo())
+		}
+		if i, ok := acc.(int64); ok {
+			return intNode(i)
+		}
+		return gowen.LiteralNode{Value: acc}
+	}
+}
+
+// smallInts holds the nodes of the ints from -smallInt to smallInt-1 - results of arithmetic that are small ints
+// (e.g. counters and indexes) do not have to be boxed.
+var smallInts = func() (ns [2 * smallInt]gowen.Node) {
+	for i := range ns {
+		ns[i] = gowen.LiteralNode{Value: int64(i - smallInt)}
+	}
+	return ns
+}()
+
+const smallInt = 1024
+
+func intNode(i int64) gowen.Node {
+	if i >= -smallInt && i < smallInt {
+		return smallInts[i+smallInt]
+	}
+	return gowen.LiteralNode{Value: i}
+}
+
+var trueNode, falseNode gowen.Node = gowen.LiteralNode{Value: true}, gowen.LiteralNode{Value: false}
+
+func comparison(fn func(int) bool) gowen.BorrowingFn {
+	return func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		if len(ns) == 0 {
+			panic(fmt.Errorf("wrong number of args (0)"))
+		}
+		x := ns[0].ToGo()
+		toNumber(x)
+		for _, n := range ns[1:] {
+			y := n.ToGo()
+			if !fn(compare(x, y)) {
+				return falseNode
+			}
+			x = y
+		}
+		return trueNode
+	}
+}
+
+func fold(fn func(Any, Any) Any, acc Any, xs []Any) Any {
+	toNumber(acc)
+	for _, x := range xs {
+		acc = fn(acc, x)
+	}
+	return acc
+}
+
+func pick(first bool, x, y Any) Any {
+	if first {
+		return x
+	}
+	return y
+}
+
+func numberKindOf(x Any) (numberKind, bool) {
+	switch x.(type) {
+	case int64:
+		return intKind, true
+	case *big.Int:
+		return bigKind, true
+	case *big.Rat:
+		return ratioKind, true
+	case float64:
+		return doubleKind, true
+	}
+	switch reflect.ValueOf(x).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return intKind, true
+	case reflect.Float32, reflect.Float64:
+		return doubleKind, true
+	}
+	return 0, false
+}
+
+// toNumber returns x as int64, *big.Int, *big.Rat or float64 - values of named numeric types (e.g. time.Duration)
+// are converted as well.
+func toNumber(x Any) (Any, numberKind) {
+	switch x.(type) {
+	case int64:
+		return x, intKind
+	case float64:
+		return x, doubleKind
+	}
+	k, ok := numberKindOf(x)
+	if !ok {
+		panic(fmt.Errorf("%v (%T) is not a number", x, x))
+	}
+	switch v := reflect.ValueOf(x); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), k
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := v.Uint(); u > math.MaxInt64 {
+			return new(big.Int).SetUint64(u), bigKind
+		}
+		return int64(v.Uint()), k
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), k
+	}
+	return x, k
+}
+
+func convert(x Any, k numberKind) Any {
+	switch x := x.(type) {
+	case int64:
+		switch k {
+		case bigKind:
+			return big.NewInt(x)
+		case ratioKind:
+			return new(big.Rat).SetInt64(x)
+		case doubleKind:
+			return float64(x)
+		}
+	case *big.Int:
+		switch k {
+		case ratioKind:
+			return new(big.Rat).SetInt(x)
+		case doubleKind:
+			f, _ := new(big.Float).SetInt(x).Float64()
+			return f
+		}
+	case *big.Rat:
+		if k == doubleKind {
+			f, _ := x.Float64()
+			return f
+		}
+	}
+	return x
+}
+
+func coerce(x, y Any) (Any, Any, numberKind) {
+	if _, ok := x.(int64); ok { // the common case - neither needs to be converted
+		if _, ok := y.(int64); ok {
+			return x, y, intKind
+		}
+	}
+	x, kx := toNumber(x)
+	y, ky := toNumber(y)
+	k := kx
+	if ky > k {
+		k = ky
+	}
+	return convert(x, k), convert(y, k), k
+}
+
+func normalize(x Any) Any {
+	switch x := x.(type) {
+	case *big.Int:
+		if x.IsInt64() {
+			return x.Int64()
+		}
+	case *big.Rat:
+		if x.IsInt() {
+			return normalize(new(big.Int).Set(x.Num()))
+		}
+	}
+	return x
+}
+
+func add(x, y Any) Any {
+	switch x, y, k := coerce(x, y); k {
+	case intKind:
+		a, b := x.(int64), y.(int64)
+		if s, ok := addInts(a, b); ok {
+			return s
+		}
+		return add(big.NewInt(a), b)
+	case bigKind:
+		return normalize(new(big.Int).Add(x.(*big.Int), y.(*big.Int)))
+	case ratioKind:
+		return normalize(new(big.Rat).Add(x.(*big.Rat), y.(*big.Rat)))
+	default:
+		return x.(float64) + y.(float64)
+	}
+}
+
+// addInts, subInts & mulInts return the result of the operation on int64s - ok is false if it overflows.
+func addInts(a, b int64) (int64, bool) { s := a + b; return s, (s > a) == (b > 0) }
+func subInts(a, b int64) (int64, bool) { s := a - b; return s, (s < a) == (b > 0) }
+
+func mulInts(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	p := a * b
+	return p, p/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func sub(x, y Any) Any {
+	switch x, y, k := coerce(x, y); k {
+	case intKind:
+		a, b := x.(int64), y.(int64)
+		if s, ok := subInts(a, b); ok {
+			return s
+		}
+		return sub(big.NewInt(a), b)
+	case bigKind:
+		return normalize(new(big.Int).Sub(x.(*big.Int), y.(*big.Int)))
+	case ratioKind:
+		return normalize(new(big.Rat).Sub(x.(*big.Rat), y.(*big.Rat)))
+	default:
+		return x.(float64) - y.(float64)
+	}
+}
+
+func mul(x, y Any) Any {
+	switch x, y, k := coerce(x, y); k {
+	case intKind:
+		a, b := x.(int64), y.(int64)
+		if p, ok := mulInts(a, b); ok {
+			return p
+		}
+		return mul(big.NewInt(a), b)
+	case bigKind:
+		return normalize(new(big.Int).Mul(x.(*big.Int), y.(*big.Int)))
+	case ratioKind:
+		return normalize(new(big.Rat).Mul(x.(*big.Rat), y.(*big.Rat)))
+	default:
+		return x.(float64) * y.(float64)
+	}
+}
+
+// div returns exact results for integers and ratios: (/ 1 3) => 1/3.
+func div(x, y Any) Any {
+	x, y, k := coerce(x, y)
+	if k == doubleKind {
+		return x.(float64) / y.(float64)
+	}
+	if k == intKind {
+		if a, b := x.(int64), y.(int64); b != 0 && a%b == 0 && !(a == math.MinInt64 && b == -1) {
+			return a / b
+		}
+	}
+	r := convert(y, ratioKind).(*big.Rat)
+	assert(r.Sign() != 0, "divide by zero")
+	return normalize(new(big.Rat).Quo(convert(x, ratioKind).(*big.Rat), r))
+}
+
+func quot(x, y Any) Any {
+	x, y, k := coerce(x, y)
+	switch k {
+	case intKind:
+		a, b := x.(int64), y.(int64)
+		assert(b != 0, "divide by zero")
+		if !(a == math.MinInt64 && b == -1) {
+			return a / b
+		}
+	case doubleKind:
+		return math.Trunc(x.(float64) / y.(float64))
+	}
+	r := convert(div(x, y), ratioKind).(*big.Rat)
+	return normalize(new(big.Int).Quo(r.Num(), r.Denom()))
+}
+
+// mod returns the modulus (floored division) - its sign is the sign of y.
+func mod(x, y Any) Any {
+	x, y, k := coerce(x, y)
+	switch k {
+	case intKind:
+		a, b := x.(int64), y.(int64)
+		assert(b != 0, "divide by zero")
+		if b == -1 {
+			return int64(0)
+		}
+		m := a % b
+		if m != 0 && (m < 0) != (b < 0) {
+			m += b
+		}
+		return m
+	case doubleKind:
+		a, b := x.(float64), y.(float64)
+		m := math.Mod(a, b)
+		if m != 0 && (m < 0) != (b < 0) {
+			m += b
+		}
+		return m
+	}
+	r := convert(div(x, y), ratioKind).(*big.Rat)
+	return sub(x, mul(y, normalize(new(big.Int).Div(r.Num(), r.Denom())))) // Div of a positive denominator floors
+}
+
+func compare(x, y Any) int {
+	switch x, y, k := coerce(x, y); k {
+	case intKind:
+		a, b := x.(int64), y.(int64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case bigKind:
+		return x.(*big.Int).Cmp(y.(*big.Int))
+	case ratioKind:
+		return x.(*big.Rat).Cmp(y.(*big.Rat))
+	default:
+		a, b := x.(float64), y.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		} else if a == b {
+			return 0
+		}
+		panic(fmt.Errorf("cannot compare %v and %v", a, b))
+	}
+}
+
+func toInteger(x Any) Any {
+	switch x, k := toNumber(x); k {
+	case ratioKind:
+		r := x.(*big.Rat)
+		return normalize(new(big.Int).Quo(r.Num(), r.Denom()))
+	case doubleKind:
+		f := math.Trunc(x.(float64))
+		assert(!math.IsInf(f, 0) && !math.IsNaN(f), "cannot convert %v to int", f)
+		i, _ := big.NewFloat(f).Int(nil)
+		return normalize(i)
+	default:
+		return x
+	}
+}
+
+func toDouble(x Any) float64 {
+	x, _ = toNumber(x)
+	return convert(x, doubleKind).(float64)
+}
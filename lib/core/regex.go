@@ -0,0 +1,113 @@
+package core
+
+// Regexes are read from #"..." literals (see gowen.Parse) or created via re-pattern. The matches returned by
+// re-find, re-matches, re-seq and re-groups are strings - or, if the regex has groups, vectors of the match and
+// its groups (nil for groups that did not participate in the match).
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/niklasfasching/gowen"
+)
+
+// matcher iterates over the matches of a regex in a string - see re-matcher.
+type matcher struct {
+	s       string
+	matches [][]int
+	last    []int // the last match returned by re-find
+}
+
+// anchoredRegexes caches the regexes used by re-matches - the regexes anchored to the start and end of the input -
+// by pattern. It is reset once it holds maxAnchoredRegexes so that regexes built at runtime do not pile up.
+var anchoredRegexes = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+const maxAnchoredRegexes = 256
+
+var regexes = map[string]Any{
+	"re-pattern": func(s string) (*regexp.Regexp, error) { return regexp.Compile(s) },
+	"re-matcher": func(re *regexp.Regexp, s string) *matcher {
+		return &matcher{s: s, matches: re.FindAllStringSubmatchIndex(s, -1)}
+	},
+	// re-find returns the first match of the regex in s - or the next match of the matcher.
+	"re-find": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		if len(ns) == 1 {
+			m, ok := ns[0].ToGo().(*matcher)
+			assert(ok, "re-find requires a matcher or a regex and a string - got %s", ns[0])
+			if len(m.matches) == 0 {
+				return gowen.LiteralNode{}
+			}
+			m.last, m.matches = m.matches[0], m.matches[1:]
+			return groups(m.s, m.last)
+		}
+		re, s := regexArgs("re-find", ns)
+		return groups(s, re.FindStringSubmatchIndex(s))
+	},
+	// re-matches returns the match of the regex if it matches all of s.
+	"re-matches": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		re, s := regexArgs("re-matches", ns)
+		return groups(s, anchored(re).FindStringSubmatchIndex(s))
+	},
+	"re-seq": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		re, s := regexArgs("re-seq", ns)
+		matches := []gowen.Node{}
+		for _, match := range re.FindAllStringSubmatchIndex(s, -1) {
+			matches = append(matches, groups(s, match))
+		}
+		if len(matches) == 0 {
+			return gowen.LiteralNode{}
+		}
+		return gowen.ListNode{Nodes: matches}
+	},
+	// re-groups returns the groups of the last match of the matcher m.
+	"re-groups": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for re-groups")
+		m, ok := ns[0].ToGo().(*matcher)
+		assert(ok, "re-groups requires a matcher - got %s", ns[0])
+		assert(m.last != nil, "re-groups: no match found")
+		return groups(m.s, m.last)
+	},
+}
+
+func regexArgs(name string, ns []gowen.Node) (*regexp.Regexp, string) {
+	assert(len(ns) == 2, "wrong number of arguments for %s", name)
+	re, ok := ns[0].ToGo().(*regexp.Regexp)
+	assert(ok, "%s requires a regex - got %s", name, ns[0])
+	s, ok := ns[1].ToGo().(string)
+	assert(ok, "%s requires a string - got %s", name, ns[1])
+	return re, s
+}
+
+func anchored(re *regexp.Regexp) *regexp.Regexp {
+	anchoredRegexes.Lock()
+	defer anchoredRegexes.Unlock()
+	if a, ok := anchoredRegexes.m[re.String()]; ok {
+		return a
+	} else if len(anchoredRegexes.m) >= maxAnchoredRegexes {
+		anchoredRegexes.m = map[string]*regexp.Regexp{}
+	}
+	a := regexp.MustCompile(`\A(?:` + re.String() + `)\z`)
+	anchoredRegexes.m[re.String()] = a
+	return a
+}
+
+// groups returns the match of s described by the submatch indexes match.
+func groups(s string, match []int) gowen.Node {
+	if match == nil {
+		return gowen.LiteralNode{}
+	} else if len(match) == 2 {
+		return gowen.LiteralNode{Value: s[match[0]:match[1]]}
+	}
+	ns := []gowen.Node{}
+	for i := 0; i < len(match); i += 2 {
+		if match[i] < 0 {
+			ns = append(ns, gowen.LiteralNode{})
+		} else {
+			ns = append(ns, gowen.LiteralNode{Value: s[match[i]:match[i+1]]})
+		}
+	}
+	return gowen.NewVector(ns...)
+}
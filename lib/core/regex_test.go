@@ -0,0 +1,19 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestAnchoredRegexes(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		re := regexp.MustCompile(fmt.Sprintf("a%d", i))
+		if !anchored(re).MatchString(fmt.Sprintf("a%d", i)) || anchored(re).MatchString(fmt.Sprintf("a%dx", i)) {
+			t.Fatalf("expected %s to be anchored", anchored(re))
+		}
+	}
+	if n := len(anchoredRegexes.m); n > maxAnchoredRegexes {
+		t.Errorf("expected at most %d cached regexes - got %d", maxAnchoredRegexes, n)
+	}
+}
@@ -0,0 +1,193 @@
+package core
+
+// The sequence functions are lazy - they return a gowen.LazySeqNode and only realize as many elements
+// of their input as needed. Chunked inputs (e.g. vectors, range, map) are processed one chunk at a time.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var seqs = map[string]Any{
+	"map": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for map")
+		return mapSeq(ns[0], ns[1], env)
+	},
+	"filter": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for filter")
+		return filterSeq(ns[0], ns[1], env)
+	},
+	"take": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for take")
+		return takeSeq(intArg(ns[0]), ns[1], env)
+	},
+	"drop": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for drop")
+		n, coll := intArg(ns[0]), ns[1]
+		return gowen.NewLazySeq(env, func() gowen.Node {
+			for chunk, more, ok := gowen.Chunk(coll); ok; chunk, more, ok = gowen.Chunk(more) {
+				if n < len(chunk) {
+					return gowen.ChunkCons(chunk[n:], more)
+				}
+				n -= len(chunk)
+			}
+			return nil
+		})
+	},
+	"take-while": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for take-while")
+		return takeWhileSeq(ns[0], ns[1], env)
+	},
+	"range": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		start, end, step := Any(int64(0)), Any(nil), Any(int64(1))
+		switch len(ns) {
+		case 0:
+		case 1:
+			end = ns[0].ToGo()
+		case 2:
+			start, end = ns[0].ToGo(), ns[1].ToGo()
+		case 3:
+			start, end, step = ns[0].ToGo(), ns[1].ToGo(), ns[2].ToGo()
+		default:
+			panic(fmt.Errorf("wrong number of arguments for range"))
+		}
+		direction := compare(step, int64(0))
+		assert(direction != 0, "step of range must not be 0")
+		return rangeSeq(start, end, step, direction, env)
+	},
+	"iterate": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for iterate")
+		return iterateSeq(ns[0], ns[1], env)
+	},
+	"cycle": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for cycle")
+		return cycleSeq(ns[0], ns[0], env)
+	},
+	// line-seq returns the lines of r (without line endings). r is read one line at a time.
+	"line-seq": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 1, "wrong number of arguments for line-seq")
+		r, ok := ns[0].ToGo().(io.Reader)
+		assert(ok, "%s is not an io.Reader", ns[0])
+		return lineSeq(bufio.NewReader(r), env)
+	},
+}
+
+func mapSeq(f, coll gowen.Node, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		chunk, more, ok := gowen.Chunk(coll)
+		if !ok {
+			return nil
+		}
+		out := make([]gowen.Node, len(chunk))
+		for i, x := range chunk {
+			out[i] = gowen.Apply(f, []gowen.Node{x}, env)
+		}
+		return gowen.ChunkCons(out, mapSeq(f, more, env))
+	})
+}
+
+func filterSeq(f, coll gowen.Node, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		for chunk, more, ok := gowen.Chunk(coll); ok; chunk, more, ok = gowen.Chunk(more) {
+			out := []gowen.Node{}
+			for _, x := range chunk {
+				if isTruthy(gowen.Apply(f, []gowen.Node{x}, env)) {
+					out = append(out, x)
+				}
+			}
+			if len(out) != 0 {
+				return gowen.ChunkCons(out, filterSeq(f, more, env))
+			}
+		}
+		return nil
+	})
+}
+
+func takeSeq(n int, coll gowen.Node, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		chunk, more, ok := gowen.Chunk(coll)
+		if !ok || n <= 0 {
+			return nil
+		} else if n <= len(chunk) {
+			return gowen.ChunkCons(chunk[:n], nil)
+		}
+		return gowen.ChunkCons(chunk, takeSeq(n-len(chunk), more, env))
+	})
+}
+
+func takeWhileSeq(f, coll gowen.Node, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		chunk, more, ok := gowen.Chunk(coll)
+		if !ok {
+			return nil
+		}
+		for i, x := range chunk {
+			if !isTruthy(gowen.Apply(f, []gowen.Node{x}, env)) {
+				return gowen.ChunkCons(chunk[:i], nil)
+			}
+		}
+		return gowen.ChunkCons(chunk, takeWhileSeq(f, more, env))
+	})
+}
+
+// rangeSeq returns the numbers from start to end (exclusive) - end nil means infinite.
+func rangeSeq(start, end, step Any, direction int, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		chunk, x := []gowen.Node{}, start
+		for ; len(chunk) < 32 && (end == nil || compare(x, end) == -direction); x = add(x, step) {
+			chunk = append(chunk, gowen.LiteralNode{Value: x})
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+		return gowen.ChunkCons(chunk, rangeSeq(x, end, step, direction, env))
+	})
+}
+
+func iterateSeq(f, x gowen.Node, env *gowen.Env) gowen.Node {
+	return gowen.ChunkCons([]gowen.Node{x}, gowen.NewLazySeq(env, func() gowen.Node {
+		return iterateSeq(f, gowen.Apply(f, []gowen.Node{x}, env), env)
+	}))
+}
+
+func cycleSeq(coll, rest gowen.Node, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		chunk, more, ok := gowen.Chunk(rest)
+		if !ok {
+			chunk, more, ok = gowen.Chunk(coll)
+		}
+		if !ok {
+			return nil
+		}
+		return gowen.ChunkCons(chunk, cycleSeq(coll, more, env))
+	})
+}
+
+func lineSeq(r *bufio.Reader, env *gowen.Env) gowen.Node {
+	return gowen.NewLazySeq(env, func() gowen.Node {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			panic(err)
+		} else if err == io.EOF && line == "" {
+			return nil
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		return gowen.ChunkCons([]gowen.Node{gowen.LiteralNode{Value: line}}, lineSeq(r, env))
+	})
+}
+
+func intArg(n gowen.Node) int {
+	ln, _ := n.(gowen.LiteralNode)
+	i, ok := ln.Value.(int64)
+	assert(ok, "%s is not an integer", n)
+	return int(i)
+}
+
+func isTruthy(n gowen.Node) bool {
+	ln, ok := n.(gowen.LiteralNode)
+	return !ok || (ln.Value != nil && ln.Value != false)
+}
@@ -0,0 +1,86 @@
+package core
+
+import "github.com/niklasfasching/gowen"
+
+// Set functions accept nil as the empty set - (union nil #{1}) => #{1}.
+var sets = map[string]Any{
+	"union": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		s := gowen.SetNode{}
+		for _, n := range ns {
+			for _, x := range toSet(n).Seq() {
+				s = s.Conj(x).(gowen.SetNode)
+			}
+		}
+		return s
+	},
+	"intersection": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) >= 1, "wrong number of arguments for intersection")
+		s := toSet(ns[0])
+		for _, n := range ns[1:] {
+			other := toSet(n)
+			for _, x := range s.Seq() {
+				if !other.Contains(x) {
+					s = s.Disj(x)
+				}
+			}
+		}
+		return s
+	},
+	"difference": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) >= 1, "wrong number of arguments for difference")
+		s := toSet(ns[0])
+		for _, n := range ns[1:] {
+			for _, x := range toSet(n).Seq() {
+				s = s.Disj(x)
+			}
+		}
+		return s
+	},
+	"subset?": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for subset?")
+		s1, s2 := toSet(ns[0]), toSet(ns[1])
+		for _, x := range s1.Seq() {
+			if !s2.Contains(x) {
+				return gowen.LiteralNode{Value: false}
+			}
+		}
+		return gowen.LiteralNode{Value: true}
+	},
+	"disj": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) >= 1, "wrong number of arguments for disj")
+		s := toSet(ns[0])
+		for _, x := range ns[1:] {
+			s = s.Disj(x)
+		}
+		return s
+	},
+	// contains? checks for keys - the elements of sets, the keys of maps and the indexes of vectors.
+	"contains?": func(ns []gowen.Node, env *gowen.Env) gowen.Node {
+		assert(len(ns) == 2, "wrong number of arguments for contains?")
+		contains := false
+		switch coll := ns[0].(type) {
+		case gowen.SetNode:
+			contains = coll.Contains(ns[1])
+		case gowen.MapNode:
+			_, contains = coll.Lookup(ns[1])
+		case gowen.VectorNode:
+			ln, _ := ns[1].(gowen.LiteralNode)
+			i, ok := ln.Value.(int64)
+			contains = ok && i >= 0 && int(i) < coll.Count()
+		default:
+			assert(isNil(coll), "contains? not supported on %s", coll)
+		}
+		return gowen.LiteralNode{Value: contains}
+	},
+}
+
+func toSet(n gowen.Node) gowen.SetNode {
+	s, ok := n.(gowen.SetNode)
+	assert(ok || isNil(n), "%s is not a set", n)
+	return s
+}
+
+func isNil(n gowen.Node) bool {
+	ln, ok := n.(gowen.LiteralNode)
+	return ok && ln.Value == nil
+}
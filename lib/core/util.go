@@ -2,10 +2,16 @@ package core
 
 import (
 	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
 	"go/importer"
+	"go/parser"
+	"go/token"
 	"go/types"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -32,44 +38,275 @@ package %s
 import "github.com/niklasfasching/gowen"
 
 %s
+%s
+func init() {
+  gowen.RegisterLibrary(%q, %s, "")
+  gowen.RegisterLibraryMeta(%q, %s)
+%s}`
+
+var goInlineGowenTemplate = `// Code generated automatically via gowen/cmd/generate. DO NOT EDIT.
+
+package %s
+
+import "github.com/niklasfasching/gowen"
 
 func init() {
-  gowen.Register(%s, "")
+    gowen.RegisterLibrary(%q, nil, %q)
 }`
 
-var goInlineGowenTemplate = `// Code generated automatically via gowen/cmd/generate. DO NOT EDIT.
+var compiledGowenTemplate = `// Code generated automatically via gowen/cmd/generate. DO NOT EDIT.
 
 package %s
 
 import "github.com/niklasfasching/gowen"
 
 func init() {
-    gowen.Register(nil, %q)
+    if err := gowen.RegisterCompiledLibrary(%q, []byte(%q)); err != nil {
+        if err := gowen.RegisterLibrary(%q, nil, %q); err != nil {
+            panic(err)
+        }
+    }
 }`
 
+var goAdapterTemplate = `  gowen.RegisterAdapter(%s, func(args []gowen.Node) gowen.Node {
+    gowen.CheckArgs(%s, args, %d, %t)
+    %s
+  })
+`
+
+var goInterfaceTemplate = `  gowen.RegisterInterface((*%s)(nil), func(m gowen.Methods) interface{} {
+    return %s{%s}
+  })
+`
+
+// GenerateGoPackageRegisterFileContent registers the exported values of packages as a library. Adapters (see
+// gowen.RegisterAdapter) are generated for the fns with simple signatures so they can be called without reflection.
+// Interface types are registered as their reflect.Type - with an implementation for reify (see
+// gowen.RegisterInterface) if the types of their methods can be named. The Go signature and doc comment of each
+// value are registered as its metadata (:signature & :doc) - see gowen.RegisterLibraryMeta.
 func GenerateGoPackageRegisterFileContent(packageName string, packages map[string]string) string {
-	values := "map[string]interface{}{\n"
+	values, meta := "map[string]interface{}{\n", "map[string]map[string]string{\n"
 	imports := "import (\n"
+	registrations, implementations := "", ""
 	importer := importer.Default()
+	aliases := map[string]string{}
+	for alias, pkgName := range packages {
+		aliases[pkgName] = alias
+	}
 	for alias, pkgName := range packages {
 		imports += fmt.Sprintf("    %q\n", pkgName)
 		pkg, err := importer.Import(pkgName)
 		if err != nil {
 			log.Fatal(err)
 		}
-		scope := pkg.Scope()
+		scope, docs := pkg.Scope(), goDocs(pkgName)
 		for _, name := range scope.Names() {
-			object := scope.Lookup(name)
-			if _, isType := object.(*types.TypeName); isType || !object.Exported() {
+			object, key := scope.Lookup(name), alias+"/"+toLispCase(name)
+			if !object.Exported() {
+				continue
+			}
+			signature := types.ObjectString(object, types.RelativeTo(pkg))
+			meta += fmt.Sprintf("		%q: {\"signature\": %q, \"doc\": %q},\n", key, signature, docs[name])
+			if _, isType := object.(*types.TypeName); isType {
+				iface, ok := object.Type().Underlying().(*types.Interface)
+				if !ok || !iface.IsMethodSet() || iface.NumMethods() == 0 {
+					continue
+				}
+				values += fmt.Sprintf("		%q: reflect.TypeOf((*%s)(nil)).Elem(),\n", key, alias+"."+name)
+				if implementation, registration := goInterface(alias+"."+name, iface, aliases); implementation != "" {
+					implementations += implementation
+					registrations += registration
+				}
 				continue
 			}
-			key := alias + "/" + toLispCase(name)
 			values += fmt.Sprintf("		%q: %s,\n", key, alias+"."+name)
+			if f, ok := object.(*types.Func); ok {
+				registrations += goAdapter(alias+"."+name, f.Type().(*types.Signature), aliases)
+			}
 		}
 	}
+	if _, ok := aliases["reflect"]; !ok {
+		imports += "    \"reflect\"\n"
+	}
 	imports += ")\n"
 	values += "    }"
-	return fmt.Sprintf(goPackageRegisterTemplate, packageName, imports, values)
+	meta += "    }"
+	return fmt.Sprintf(goPackageRegisterTemplate, packageName, imports, implementations, packageName, values,
+		packageName, meta, registrations)
+}
+
+// goDocs returns the doc comments of the exported package level declarations of the package path by name.
+func goDocs(path string) map[string]string {
+	bpkg, err := build.Import(path, "", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fset, files := token.NewFileSet(), []*ast.File{}
+	for _, name := range append(bpkg.GoFiles, bpkg.CgoFiles...) {
+		f, err := parser.ParseFile(fset, filepath.Join(bpkg.Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	pkg, err := doc.NewFromFiles(fset, files, path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	docs := map[string]string{}
+	addValues := func(vs []*doc.Value) {
+		for _, v := range vs {
+			for _, name := range v.Names {
+				docs[name] = strings.TrimSpace(v.Doc)
+			}
+		}
+	}
+	addFuncs := func(fs []*doc.Func) {
+		for _, f := range fs {
+			docs[f.Name] = strings.TrimSpace(f.Doc)
+		}
+	}
+	addValues(pkg.Consts)
+	addValues(pkg.Vars)
+	addFuncs(pkg.Funcs)
+	for _, t := range pkg.Types {
+		docs[t.Name] = strings.TrimSpace(t.Doc)
+		addValues(t.Consts)
+		addValues(t.Vars)
+		addFuncs(t.Funcs)
+	}
+	return docs
+}
+
+// goInterface returns the implementation of the interface name that calls the funcs of gowen.Methods and its
+// registration - or empty strings if the types of its methods cannot be named.
+func goInterface(name string, iface *types.Interface, aliases map[string]string) (string, string) {
+	typeName := "reified" + strings.Replace(strings.Title(name), ".", "", 1)
+	fields, methods, fns := "", "", []string{}
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		sig := m.Type().(*types.Signature)
+		params, results, ok := goSignature(sig, aliases)
+		if !ok || !m.Exported() {
+			return "", ""
+		}
+		fnType, args := fmt.Sprintf("func(%s)%s", strings.Join(params, ", "), results), []string{}
+		for i, param := range params {
+			params[i], args = fmt.Sprintf("p%d %s", i, param), append(args, fmt.Sprintf("p%d", i))
+		}
+		if sig.Variadic() {
+			args[len(args)-1] += "..."
+		}
+		call := fmt.Sprintf("x.f%s(%s)", m.Name(), strings.Join(args, ", "))
+		if results != "" {
+			call = "return " + call
+		}
+		fields += fmt.Sprintf("  f%s %s\n", m.Name(), fnType)
+		methods += fmt.Sprintf("func (x %s) %s(%s)%s { %s }\n", typeName, m.Name(), strings.Join(params, ", "), results, call)
+		fns = append(fns, fmt.Sprintf("m[%q].(%s)", m.Name(), fnType))
+	}
+	implementation := fmt.Sprintf("type %s struct {\n%s}\n\n%s\n", typeName, fields, methods)
+	return implementation, fmt.Sprintf(goInterfaceTemplate, name, typeName, strings.Join(fns, ", "))
+}
+
+// goSignature returns the types of the params (the last one prefixed with ... if sig is variadic) and the results
+// of sig as they are written in a func type.
+func goSignature(sig *types.Signature, aliases map[string]string) ([]string, string, bool) {
+	params, results := []string{}, []string{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		t := sig.Params().At(i).Type()
+		prefix := ""
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			t, prefix = t.(*types.Slice).Elem(), "..."
+		}
+		name, ok := goTypeName(t, aliases)
+		if !ok {
+			return nil, "", false
+		}
+		params = append(params, prefix+name)
+	}
+	for i := 0; i < sig.Results().Len(); i++ {
+		name, ok := goTypeName(sig.Results().At(i).Type(), aliases)
+		if !ok {
+			return nil, "", false
+		}
+		results = append(results, name)
+	}
+	switch len(results) {
+	case 0:
+		return params, "", true
+	case 1:
+		return params, " " + results[0], true
+	default:
+		return params, " (" + strings.Join(results, ", ") + ")", true
+	}
+}
+
+// goAdapter returns the registration of the adapter for the fn name - or an empty string if the signature is not
+// supported. Supported are params of types that can be named with the imported packages and results of
+// fns that return at most one value and an error.
+func goAdapter(name string, sig *types.Signature, aliases map[string]string) string {
+	if sig.TypeParams().Len() != 0 {
+		return ""
+	}
+	n, args := sig.Params().Len(), []string{}
+	for i := 0; i < n; i++ {
+		t := sig.Params().At(i).Type()
+		if sig.Variadic() && i == n-1 {
+			elem, ok := goTypeName(t.(*types.Slice).Elem(), aliases)
+			if !ok {
+				return ""
+			}
+			args = append(args, fmt.Sprintf("gowen.Args[%s](args[%d:])...", elem, i))
+			n--
+		} else if t, ok := goTypeName(t, aliases); ok {
+			args = append(args, fmt.Sprintf("gowen.Arg[%s](args[%d])", t, i))
+		} else {
+			return ""
+		}
+	}
+	call, errorType := fmt.Sprintf("%s(%s)", name, strings.Join(args, ", ")), types.Universe.Lookup("error").Type()
+	body, rs := "", sig.Results()
+	switch {
+	case rs.Len() == 0:
+		body = call + "\n    return gowen.LiteralNode{}"
+	case rs.Len() == 1 && types.Identical(rs.At(0).Type(), errorType):
+		body = fmt.Sprintf("return gowen.Result(nil, %s)", call)
+	case rs.Len() == 1 && !types.IsInterface(rs.At(0).Type()) && !types.AssignableTo(rs.At(0).Type(), errorType):
+		body = fmt.Sprintf("return gowen.ToNode(%s)", call)
+	case rs.Len() == 2 && types.Identical(rs.At(1).Type(), errorType):
+		body = fmt.Sprintf("return gowen.Result(%s)", call)
+	default:
+		return ""
+	}
+	return fmt.Sprintf(goAdapterTemplate, name, name, n, sig.Variadic(), body)
+}
+
+// goTypeName returns the name of t in a file that imports the packages of aliases.
+func goTypeName(t types.Type, aliases map[string]string) (string, bool) {
+	switch t := t.(type) {
+	case *types.Basic:
+		return t.Name(), t.Info()&types.IsUntyped == 0 && t.Kind() != types.UnsafePointer
+	case *types.Named:
+		if o := t.Obj(); o.Pkg() == nil {
+			return o.Name(), true
+		} else if alias, ok := aliases[o.Pkg().Path()]; ok && o.Exported() && t.TypeArgs().Len() == 0 {
+			return alias + "." + o.Name(), true
+		}
+	case *types.Pointer:
+		elem, ok := goTypeName(t.Elem(), aliases)
+		return "*" + elem, ok
+	case *types.Slice:
+		elem, ok := goTypeName(t.Elem(), aliases)
+		return "[]" + elem, ok
+	case *types.Map:
+		key, ok1 := goTypeName(t.Key(), aliases)
+		elem, ok2 := goTypeName(t.Elem(), aliases)
+		return "map[" + key + "]" + elem, ok1 && ok2
+	case *types.Interface:
+		return "interface{}", t.Empty()
+	}
+	return "", false
 }
 
 func GenerateGowenInlineFileContent(packageName string, filenames []string) string {
@@ -89,5 +326,34 @@ func GenerateGowenInlineFileContent(packageName string, filenames []string) stri
 	for _, n := range nodes {
 		output += n.String()
 	}
-	return fmt.Sprintf(goInlineGowenTemplate, packageName, output)
+	return fmt.Sprintf(goInlineGowenTemplate, packageName, packageName, output)
+}
+
+// GenerateGowenCompiledFileContent compiles the files to a module (see gowen.Module) that is registered as the
+// library packageName. The files are compiled in a fresh env of the DefaultRuntime - which must have the Go
+// values they depend on registered already. Modules that cannot be registered (e.g. as they were generated by
+// another version of gowen) fall back to the source of the files, which is generated as well - so that the
+// generator still runs to replace them. Errors of the source panic.
+func GenerateGowenCompiledFileContent(packageName string, filenames []string) string {
+	nodes, input := []gowen.Node{}, ""
+	for _, f := range filenames {
+		bs, err := ioutil.ReadFile(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fileNodes, err := gowen.ParseFile(f, string(bs))
+		if err != nil {
+			log.Fatal(err)
+		}
+		nodes, input = append(nodes, fileNodes...), input+string(bs)+"\n"
+	}
+	module, err := gowen.CompileModule(nodes, gowen.DefaultRuntime.NewEnv(true))
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := module.MarshalBinary()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fmt.Sprintf(compiledGowenTemplate, packageName, packageName, data, packageName, input)
 }
@@ -1,7 +1,14 @@
 package core
 
 import (
+	"go/importer"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/niklasfasching/gowen"
 )
 
 type lispCaseTest struct {
@@ -24,3 +31,86 @@ func TestToLispCase(t *testing.T) {
 		}
 	}
 }
+
+type goAdapterTest struct {
+	pkg      string
+	name     string
+	expected string
+}
+
+var goAdapterTests = []goAdapterTest{
+	{"strings", "Repeat", "return gowen.ToNode(strings.Repeat(gowen.Arg[string](args[0]), gowen.Arg[int](args[1])))"},
+	{"strings", "NewReplacer", "return gowen.ToNode(strings.NewReplacer(gowen.Args[string](args[0:])...))"},
+	{"strconv", "Atoi", "return gowen.Result(strconv.Atoi(gowen.Arg[string](args[0])))"},
+	{"os", "Remove", "return gowen.Result(nil, os.Remove(gowen.Arg[string](args[0])))"},
+	{"os", "Exit", "os.Exit(gowen.Arg[int](args[0]))\n    return gowen.LiteralNode{}"},
+	{"time", "Sleep", "time.Sleep(gowen.Arg[time.Duration](args[0]))"},
+	{"os", "LookupEnv", ""}, // (string, bool) results
+	{"strings", "Map", ""},  // func param
+	{"os", "Chmod", ""},     // param of type fs.FileMode from an unimported package
+	{"strings", "Cut", ""},  // three results
+}
+
+func TestGoAdapter(t *testing.T) {
+	aliases := map[string]string{"strings": "strings", "strconv": "strconv", "os": "os", "time": "time"}
+	for _, test := range goAdapterTests {
+		pkg, err := importer.Default().Import(test.pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := test.pkg + "." + test.name
+		result := goAdapter(name, pkg.Scope().Lookup(test.name).Type().(*types.Signature), aliases)
+		if test.expected == "" && result != "" || !strings.Contains(result, test.expected) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", name, result, test.expected)
+		}
+	}
+}
+
+var goInterfaceTests = []goAdapterTest{
+	{"io", "Writer", "func (x reifiedIoWriter) Write(p0 []byte) (int, error) { return x.fWrite(p0) }"},
+	{"io", "ReaderFrom", "return reifiedIoReaderFrom{m[\"ReadFrom\"].(func(io.Reader) (int64, error))}"},
+	{"sort", "Interface", "func (x reifiedSortInterface) Swap(p0 int, p1 int) { x.fSwap(p0, p1) }"},
+}
+
+func TestGoInterface(t *testing.T) {
+	aliases := map[string]string{"io": "io", "sort": "sort"}
+	for _, test := range goInterfaceTests {
+		pkg, err := importer.Default().Import(test.pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := test.pkg + "." + test.name
+		implementation, registration := goInterface(name, pkg.Scope().Lookup(test.name).Type().Underlying().(*types.Interface), aliases)
+		if result := implementation + registration; !strings.Contains(result, test.expected) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", name, result, test.expected)
+		}
+	}
+}
+
+func TestGoDocs(t *testing.T) {
+	docs := goDocs("strings")
+	for name, prefix := range map[string]string{
+		"Repeat":    "Repeat returns a new string",
+		"Builder":   "A Builder is used",
+		"NewReader": "NewReader returns a new",
+	} {
+		if !strings.HasPrefix(docs[name], prefix) {
+			t.Errorf("%s: got\n\t%q\nexpected prefix\n\t%q", name, docs[name], prefix)
+		}
+	}
+}
+
+func TestGowenCompiledFileContent(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "lib.gow")
+	if err := os.WriteFile(f, []byte("(def x 1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the source is the fallback for modules that cannot be registered - e.g. ones of another version of gowen
+	content := GenerateGowenCompiledFileContent("lib", []string{f})
+	if expected := `gowen.RegisterLibrary("lib", nil, "(def x 1)\n")`; !strings.Contains(content, expected) {
+		t.Errorf("got\n\t%s\nexpected it to contain\n\t%s", content, expected)
+	}
+	if err := gowen.RegisterCompiledLibrary("stale", []byte("module of another version")); err == nil {
+		t.Errorf("expected an error for a stale module")
+	}
+}
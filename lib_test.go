@@ -10,13 +10,37 @@ var libTests = []evalTest{
 	{"quote", `'[foo bar baz]`, `'[foo bar baz]`},
 	{"fn", "((fn [x] 42) 0)", "42"},
 	{"fn destructure", "((fn [x [y1 y2] z] (+ x y1 y2 z)) 1 [2 3 4] 5)", "11"},
+	{"fn multi-arity", `(def f (fn f ([] (f 1)) ([x] (f x 2)) ([x y] [x y]) ([x y & more] more)))
+                        [(f) (f 3) (f 3 4) (f 3 4 5 6)]`, "[[1 2] [3 2] [3 4] '(5 6)]"},
+	{"fn variadic", "((fn [& xs] xs) 1 2)", "'(1 2)"},
+	{"fn variadic recur", "((fn [acc & xs] (if (> (count xs) 0) (recur (+ acc (first xs)) (rest xs)) acc)) 0 1 2 3)", "6"},
 	{"macro", "((fn [x] 42) 0)", "42"},
 	{"apply", `(apply (fn [x y] [x y]) [1 2])`, "[1 2]"},
 	{"try", `[(try (throw "boo!") (catch e (str "caught: " e)))
-              (try :foobar (catch e "caught"))]`, `["caught: boo!: (throw \"boo!\")" :foobar]`},
+              (try :foobar (catch e "caught"))]`, `["caught: 1:7: boo!: (throw \"boo!\")" :foobar]`},
+	{"try stack", `(try (throw "boo!") (catch e [(.name (first (.stack e))) (str (.kind (first (.stack e))))]))`, `["throw" "interop"]`},
+
+	{"do", "(do 1 2 3)", "3"},
+	{"let", "(let [x 1 [y z] [x 2] x 3] [x y z])", "[3 1 2]"},
+	{"loop", "(loop [i 0 xs []] (if (< i 3) (recur (+ i 1) (conj xs i)) xs))", "[0 1 2]"},
+	{"loop & let", "(loop [i 0] (let [j (+ i 1)] (if (< j 10000) (recur j) j)))", "10000"},
+	{"loop & macro", "(loop [i 0] (cond (< i 5) (recur (+ i 1)) true i))", "5"},
+	{"fn recur", "((fn [n acc] (if (= n 0) acc (recur (- n 1) (+ acc n)))) 10000 0)", "50005000"},
+	{"nested loop", `(loop [i 0 acc 0]
+                       (if (< i 3)
+                         (recur (+ i 1) (loop [j 0 acc acc] (if (< j 3) (recur (+ j 1) (+ acc 1)) acc)))
+                         acc))`, "9"},
 
 	{"macroexpand & defn", "(macroexpand '(defn foo [x & xs] x))", "'(def foo (fn foo [x & xs] x))"},
+	{"macroexpand & multi-arity defn", "(macroexpand '(defn foo ([] 1) ([x] x)))", "'(def foo (fn foo ([] 1) ([x] x)))"},
 	{"macroexpand & defmacro", "(macroexpand '(defmacro foo [x & xs] x))", "'(def foo (macro foo [x & xs] x))"},
+	{"auto-gensym", "(def m (macro m [x] `(let [v# 1] (+ v# ~x)))) (let [v# 10] (m v#))", "11"},
+	{"auto-gensym per quasiquote", "(let [[a b] `[x# x#] [c] `[x#] [[d] e] `[`[x#] x#]] [(= a b) (= a c) (= d e)])", "[true false false]"},
+	{"gensym", `(let [a (gensym) b (gensym "foo")] [(= a b) (type a) (strings/has-prefix (str b) "foo")])`, `[false "symbol" true]`},
+	{"fn literals", "[(#(+ % %2) 1 2) (#(vector %&) 1 2) (vec (map #(* % %) [1 2])) (#(do %2) 1 2)]", "[3 ['(1 2)] [1 4] 2]"},
+	{"characters", `[\a (= (first "héllo") \h) (vec (seq "hé")) \newline (= \u00e9 \é)]`, `[\a true [\h \é] \newline true]`},
+	{"meta & with-meta", "[(meta (with-meta 'x {:a 1})) (meta '^:b []) (meta 'y) (meta (with-meta '^:b y nil))]", "[{:a 1} {:b true} nil nil]"},
+	{"discard & metadata", "(let [^:private x 1 #_ x #_2] ^{:doc \"doc\"} [x])", "[1]"},
 
 	{"q list", "'(+ 1 2)", "'(+ 1 2)"},
 	{"qq unquote", "`(+ 1 ~(+ 1 2))", "'(+ 1 3)"},
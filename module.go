@@ -0,0 +1,681 @@
+package gowen
+
+// A Module is a sequence of top level forms compiled to bytecode (see bytecode.go). Modules can be serialized
+// and loaded again without parsing and expanding the source - e.g. to speed up loading large libraries.
+// Only nodes the reader can produce (plus ints) can be serialized - macros that expand to other Go values
+// (e.g. fns) cannot be part of a serialized module.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+)
+
+type Module struct {
+	forms []*proto
+}
+
+var moduleHeader = []byte("gowen-module\x01")
+
+// CompileModule compiles nodes to a module. Forms are evaluated in env after they are compiled - so that later
+// forms can use the macros defined by earlier ones.
+func CompileModule(nodes []Node, env *Env) (m *Module, err error) {
+	defer handleError(&err)
+	m = &Module{}
+	for _, n := range nodes {
+		p := assemble(n, env)
+		m.forms = append(m.forms, p)
+		p.run(env)
+	}
+	return m, nil
+}
+
+// Eval evaluates the forms of m in env and returns the value of the last one.
+func (m *Module) Eval(env *Env) (n Node, err error) {
+	defer handleError(&err)
+	n = LiteralNode{nil}
+	for _, p := range m.forms {
+		n = p.run(env)
+	}
+	return n, nil
+}
+
+// Disassemble returns a listing of the bytecode of m.
+func (m *Module) Disassemble() string {
+	s := ""
+	for _, p := range m.forms {
+		s += p.disassemble()
+	}
+	return s
+}
+
+func (p *proto) run(env *Env) Node { return run(p.eval, newActivation(p.level, nil, env)) }
+
+func (m *Module) MarshalBinary() (data []byte, err error) {
+	defer handleError(&err)
+	e := &encoder{forms: map[formKey]int{}, strs: map[string]int{}, paths: map[pathKey]int{}}
+	e.Write(moduleHeader)
+	e.int(len(m.forms))
+	for _, p := range m.forms {
+		e.proto(p)
+	}
+	return e.Bytes(), nil
+}
+
+func (m *Module) UnmarshalBinary(data []byte) (err error) {
+	defer handleError(&err)
+	assert(bytes.HasPrefix(data, moduleHeader), "not a gowen module (or a module of another version)")
+	d := &decoder{data: data[len(moduleHeader):]}
+	forms := make([]*proto, d.int())
+	for i := range forms {
+		forms[i] = d.proto()
+	}
+	assert(len(d.data) == 0, "invalid module: %d bytes of trailing data", len(d.data))
+	m.forms = forms
+	return nil
+}
+
+// Forms are referenced by the sites of all forms nested in them (see site). To keep modules small, each form
+// and string is encoded once - later occurrences refer to the first one by index.
+type encoder struct {
+	bytes.Buffer
+	sites map[*site]int // of the proto being encoded
+	forms map[formKey]int
+	count int // of the forms encoded - forms without elements are not in forms
+	strs  map[string]int
+	paths map[pathKey]int
+}
+
+type pathKey struct {
+	frames uintptr
+	count  int
+}
+
+// formKey identifies a form by its elements (rather than their values) and position.
+type formKey struct {
+	tag      byte
+	elements uintptr
+	count    int
+	pos      Position
+}
+
+const (
+	tagNone byte = iota
+	tagNil
+	tagBool
+	tagInt64
+	tagInt
+	tagFloat
+	tagString
+	tagBigInt
+	tagRatio
+	tagSymbol
+	tagKeyword
+	tagList
+	tagVector
+	tagArrayMap
+	tagMap
+	tagSet
+	tagForm // a form encoded before
+	tagMeta // the metadata of the node that follows
+	tagChar
+	tagRegex
+)
+
+func (e *encoder) int(i int) { e.Write(binary.AppendVarint(nil, int64(i))) }
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.int(len(s))
+	e.WriteString(s)
+}
+
+// intern encodes s as the index of its first occurrence (plus 1) - or 0 followed by s.
+func (e *encoder) intern(s string) {
+	if i, ok := e.strs[s]; ok {
+		e.int(i + 1)
+		return
+	}
+	e.strs[s] = len(e.strs)
+	e.int(0)
+	e.string(s)
+}
+
+func (e *encoder) position(p Position) {
+	e.intern(p.File)
+	e.int(p.Line)
+	e.int(p.Column)
+}
+
+func (e *encoder) nodes(ns []Node) {
+	e.int(len(ns))
+	for _, n := range ns {
+		e.node(n)
+	}
+}
+
+// node encodes n with the positions of its forms - they are reported for errors.
+func (e *encoder) node(n Node) {
+	if m := metaOf(n); m.Count() != 0 {
+		e.WriteByte(tagMeta)
+		e.node(m)
+		n = withMeta(n, MapNode{})
+	}
+	switch n := n.(type) {
+	case nil:
+		e.WriteByte(tagNone)
+	case LiteralNode:
+		e.literal(n.Value)
+	case SymbolNode:
+		e.WriteByte(tagSymbol)
+		e.intern(n.Value)
+		e.position(n.Pos)
+	case KeywordNode:
+		e.WriteByte(tagKeyword)
+		e.intern(n.Value)
+	case ListNode:
+		e.form(formKey{tagList, reflect.ValueOf(n.Nodes).Pointer(), len(n.Nodes), n.Pos}, n.Nodes)
+	case LazySeqNode:
+		e.node(ListNode{Nodes: n.Seq()})
+	case VectorNode:
+		e.form(formKey{tagVector, reflect.ValueOf(n.vector).Pointer(), n.Count(), n.Pos}, n.Seq())
+	case ArrayMapNode:
+		e.form(formKey{tagArrayMap, reflect.ValueOf(n.Nodes).Pointer(), len(n.Nodes), n.Pos}, n.Nodes)
+	case MapNode:
+		kvs := []Node{}
+		n.each(func(k, v Node) { kvs = append(kvs, k, v) })
+		e.form(formKey{tagMap, reflect.ValueOf(n.m).Pointer(), len(kvs), Position{}}, kvs)
+	case SetNode:
+		e.form(formKey{tagSet, reflect.ValueOf(n.m).Pointer(), n.Count(), Position{}}, n.Seq())
+	default:
+		panic(errorf("cannot serialize %s (%T)", n, n))
+	}
+}
+
+func (e *encoder) form(k formKey, ns []Node) {
+	if i, ok := e.forms[k]; ok && k.elements != 0 {
+		e.WriteByte(tagForm)
+		e.int(i)
+		return
+	}
+	e.WriteByte(k.tag)
+	e.nodes(ns)
+	if k.tag != tagMap && k.tag != tagSet {
+		e.position(k.pos)
+	}
+	e.forms[k] = e.count
+	e.count++
+}
+
+func (e *encoder) literal(x Any) {
+	switch x := x.(type) {
+	case nil:
+		e.WriteByte(tagNil)
+	case bool:
+		e.WriteByte(tagBool)
+		e.bool(x)
+	case int64:
+		e.WriteByte(tagInt64)
+		e.Write(binary.AppendVarint(nil, x))
+	case int:
+		e.WriteByte(tagInt)
+		e.int(x)
+	case float64:
+		e.WriteByte(tagFloat)
+		e.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(x)))
+	case string:
+		e.WriteByte(tagString)
+		e.string(x)
+	case *big.Int:
+		e.WriteByte(tagBigInt)
+		e.string(x.String())
+	case *big.Rat:
+		e.WriteByte(tagRatio)
+		e.string(x.String())
+	case rune:
+		e.WriteByte(tagChar)
+		e.int(int(x))
+	case *regexp.Regexp:
+		e.WriteByte(tagRegex)
+		e.string(x.String())
+	default:
+		panic(errorf("cannot serialize %v (%T)", x, x))
+	}
+}
+
+func (e *encoder) frames(fs []Frame) {
+	e.int(len(fs))
+	for _, f := range fs {
+		e.intern(f.Name)
+		e.int(int(f.Kind))
+		e.node(f.Form)
+		e.position(f.Pos)
+	}
+}
+
+// path encodes the path of a site. Paths are shared by the sites of a form - see pathWith.
+func (e *encoder) path(fs []Frame) {
+	k := pathKey{reflect.ValueOf(fs).Pointer(), len(fs)}
+	if i, ok := e.paths[k]; ok {
+		e.int(i + 1)
+		return
+	}
+	e.paths[k] = len(e.paths)
+	e.int(0)
+	e.frames(fs)
+}
+
+// site encodes s as its index in the sites of the proto being encoded (plus 1) - 0 for nil.
+func (e *encoder) site(s *site) {
+	if s == nil {
+		e.int(0)
+		return
+	}
+	i, ok := e.sites[s]
+	assert(ok, "cannot serialize site %s", s.node)
+	e.int(i + 1)
+}
+
+func (e *encoder) ints(is []int) {
+	e.int(len(is))
+	for _, i := range is {
+		e.int(i)
+	}
+}
+
+func (e *encoder) strings(ss []string) {
+	e.int(len(ss))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) binder(b *binder) {
+	e.int(b.slot)
+	e.node(b.pattern)
+	e.int(len(b.slots))
+	for name, slot := range b.slots {
+		e.string(name)
+		e.int(slot)
+	}
+}
+
+func (e *encoder) proto(p *proto) {
+	outer := e.sites
+	defer func() { e.sites = outer }()
+	e.sites = map[*site]int{}
+	e.string(p.name)
+	e.int(p.level.size)
+	e.bool(p.level.captures.Load())
+	e.int(p.stack)
+	e.int(len(p.code))
+	for _, in := range p.code {
+		e.int(int(in))
+	}
+	e.nodes(p.consts)
+	e.int(len(p.globals))
+	for _, g := range p.globals {
+		e.node(g.symbol)
+	}
+	e.int(len(p.sites))
+	for i, s := range p.sites {
+		if e.bool(s != nil); s != nil {
+			e.sites[s] = i
+			e.node(s.node)
+			e.path(s.path)
+			e.bool(s.isCall)
+		}
+	}
+	e.int(len(p.calls))
+	for _, c := range p.calls {
+		e.int(c.argc)
+		e.site(c.site)
+		e.site(c.restore)
+	}
+	e.int(len(p.lates))
+	for _, l := range p.lates {
+		e.node(l.form)
+		e.int(len(l.locals))
+		for _, local := range l.locals {
+			e.string(local.name)
+			e.int(local.depth)
+			e.int(local.slot)
+		}
+		e.site(l.site)
+		e.int(l.call)
+		e.int(l.end)
+		e.bool(l.topLevel)
+	}
+	e.int(len(p.binders))
+	for _, b := range p.binders {
+		e.binder(b)
+	}
+	e.int(len(p.recurs))
+	for _, r := range p.recurs {
+		e.int(r.count)
+		e.int(r.pc)
+		e.int(r.sp)
+		e.bool(r.fn)
+		e.ints(r.binders)
+		e.bool(r.variadic)
+		e.site(r.site)
+	}
+	e.int(len(p.tries))
+	for _, t := range p.tries {
+		e.int(t.catch)
+		e.int(t.end)
+		e.int(t.slot)
+		e.site(t.site)
+	}
+	e.int(len(p.fns))
+	for _, f := range p.fns {
+		e.string(f.name)
+		e.bool(f.isMacro)
+		e.int(len(f.arities))
+		for _, a := range f.arities {
+			e.node(a.arity.params)
+			e.int(a.required)
+			e.bool(a.variadic)
+			e.ints(a.params)
+			e.bool(a.bind != nil)
+			if a.bind != nil {
+				e.binder(a.bind)
+			}
+			e.proto(a.proto)
+		}
+	}
+	e.int(len(p.protos))
+	for _, p := range p.protos {
+		e.proto(p)
+	}
+	e.int(len(p.fails))
+	for _, f := range p.fails {
+		err := asError(f.err)
+		e.position(err.Pos)
+		e.node(err.Form)
+		e.string(err.Err.Error())
+		e.frames(err.Stack)
+		e.site(f.site)
+	}
+	e.strings(p.names)
+}
+
+type decoder struct {
+	data  []byte
+	sites []*site // of the proto being decoded - see encoder.site
+	forms []Node
+	strs  []string
+	paths [][]Frame
+}
+
+func (d *decoder) int() int {
+	i, n := binary.Varint(d.data)
+	assert(n > 0, "invalid module: truncated")
+	d.data = d.data[n:]
+	return int(i)
+}
+
+// count returns a length - it is checked against the remaining data to fail early on corrupt modules.
+func (d *decoder) count() int {
+	n := d.int()
+	assert(n >= 0 && n <= len(d.data), "invalid module: bad length %d", n)
+	return n
+}
+
+func (d *decoder) byte() byte {
+	assert(len(d.data) > 0, "invalid module: truncated")
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) bool() bool { return d.byte() == 1 }
+
+func (d *decoder) string() string {
+	n := d.count()
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) intern() string {
+	i := d.int()
+	if i == 0 {
+		d.strs = append(d.strs, d.string())
+		return d.strs[len(d.strs)-1]
+	}
+	assert(i > 0 && i <= len(d.strs), "invalid module: bad string %d", i)
+	return d.strs[i-1]
+}
+
+func (d *decoder) position() Position {
+	return Position{File: d.intern(), Line: d.int(), Column: d.int()}
+}
+
+func (d *decoder) nodes() []Node {
+	ns := make([]Node, d.count())
+	for i := range ns {
+		ns[i] = d.node()
+	}
+	return ns
+}
+
+func (d *decoder) node() Node {
+	switch tag := d.byte(); tag {
+	case tagNone:
+		return nil
+	case tagNil:
+		return LiteralNode{nil}
+	case tagBool:
+		return LiteralNode{d.bool()}
+	case tagInt64:
+		return LiteralNode{int64(d.int())}
+	case tagInt:
+		return LiteralNode{d.int()}
+	case tagFloat:
+		assert(len(d.data) >= 8, "invalid module: truncated")
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+		d.data = d.data[8:]
+		return LiteralNode{f}
+	case tagString:
+		return LiteralNode{d.string()}
+	case tagBigInt:
+		i, ok := new(big.Int).SetString(d.string(), 10)
+		assert(ok, "invalid module: bad big int")
+		return LiteralNode{i}
+	case tagRatio:
+		r, ok := new(big.Rat).SetString(d.string())
+		assert(ok, "invalid module: bad ratio")
+		return LiteralNode{r}
+	case tagChar:
+		return LiteralNode{rune(d.int())}
+	case tagRegex:
+		re, err := regexp.Compile(d.string())
+		assert(err == nil, "invalid module: bad regex")
+		return LiteralNode{re}
+	case tagSymbol:
+		return SymbolNode{Value: d.intern(), Pos: d.position()}
+	case tagKeyword:
+		return KeywordNode{d.intern()}
+	case tagList:
+		return d.form(ListNode{Nodes: d.nodes(), Pos: d.position()})
+	case tagVector:
+		v := NewVector(d.nodes()...)
+		v.Pos = d.position()
+		return d.form(v)
+	case tagArrayMap:
+		return d.form(ArrayMapNode{Nodes: d.nodes(), Pos: d.position()})
+	case tagMap:
+		return d.form(NewMap(d.nodes()...))
+	case tagSet:
+		return d.form(NewSet(d.nodes()...))
+	case tagMeta:
+		m, ok := d.node().(MapNode)
+		assert(ok, "invalid module: bad metadata")
+		return withMeta(d.node(), m)
+	case tagForm:
+		i := d.int()
+		assert(i >= 0 && i < len(d.forms), "invalid module: bad form %d", i)
+		return d.forms[i]
+	default:
+		panic(errorf("invalid module: unknown tag %d", tag))
+	}
+}
+
+func (d *decoder) form(n Node) Node {
+	d.forms = append(d.forms, n)
+	return n
+}
+
+func (d *decoder) frames() []Frame {
+	fs := make([]Frame, d.count())
+	for i := range fs {
+		fs[i] = Frame{Name: d.intern(), Kind: FrameKind(d.int()), Form: d.node(), Pos: d.position()}
+	}
+	return fs
+}
+
+func (d *decoder) path() []Frame {
+	i := d.int()
+	if i == 0 {
+		d.paths = append(d.paths, d.frames())
+		return d.paths[len(d.paths)-1]
+	}
+	assert(i > 0 && i <= len(d.paths), "invalid module: bad path %d", i)
+	return d.paths[i-1]
+}
+
+func (d *decoder) site() *site {
+	i := d.int()
+	assert(i >= 0 && i <= len(d.sites), "invalid module: bad site %d", i)
+	if i == 0 {
+		return nil
+	}
+	return d.sites[i-1]
+}
+
+func (d *decoder) ints() []int {
+	is := make([]int, d.count())
+	for i := range is {
+		is[i] = d.int()
+	}
+	return is
+}
+
+func (d *decoder) strings() []string {
+	ss := make([]string, d.count())
+	for i := range ss {
+		ss[i] = d.string()
+	}
+	return ss
+}
+
+func (d *decoder) binder() *binder {
+	b := &binder{slot: d.int(), pattern: d.node()}
+	if n := d.count(); b.pattern != nil {
+		b.slots = make(map[string]int, n)
+		for i := 0; i < n; i++ {
+			b.slots[d.string()] = d.int()
+		}
+	}
+	return b
+}
+
+// proto decodes a proto. Instructions are not validated - modules must come from a trusted source.
+func (d *decoder) proto() *proto {
+	outer := d.sites
+	defer func() { d.sites = outer }()
+	p := newProto(d.string(), &level{size: d.int()})
+	p.level.captures.Store(d.bool())
+	p.stack = d.int()
+	p.code = make([]instr, d.count())
+	for i := range p.code {
+		p.code[i] = instr(d.int())
+	}
+	p.consts = d.nodes()
+	p.globals = make([]*global, d.count())
+	for i := range p.globals {
+		sn, ok := d.node().(SymbolNode)
+		assert(ok, "invalid module: bad global")
+		p.globals[i] = &global{symbol: sn}
+	}
+	p.sites = make([]*site, d.count())
+	for i := range p.sites {
+		if d.bool() {
+			p.sites[i] = &site{node: d.node(), path: d.path(), isCall: d.bool()}
+		}
+	}
+	d.sites = p.sites
+	p.calls = make([]callInfo, d.count())
+	for i := range p.calls {
+		p.calls[i] = callInfo{argc: d.int(), site: d.site(), restore: d.site()}
+	}
+	p.lates = make([]*lateCall, d.count())
+	for i := range p.lates {
+		l := &lateCall{form: d.listNode()}
+		l.locals = make([]lateLocal, d.count())
+		for j := range l.locals {
+			l.locals[j] = lateLocal{name: d.string(), depth: d.int(), slot: d.int()}
+		}
+		l.site, l.call, l.end, l.topLevel = d.site(), d.int(), d.int(), d.bool()
+		p.lates[i] = l
+	}
+	p.binders = make([]*binder, d.count())
+	for i := range p.binders {
+		p.binders[i] = d.binder()
+	}
+	p.recurs = make([]recurInfo, d.count())
+	for i := range p.recurs {
+		p.recurs[i] = recurInfo{count: d.int(), pc: d.int(), sp: d.int(), fn: d.bool(), binders: d.ints(), variadic: d.bool(), site: d.site()}
+	}
+	p.tries = make([]tryInfo, d.count())
+	for i := range p.tries {
+		p.tries[i] = tryInfo{catch: d.int(), end: d.int(), slot: d.int(), site: d.site()}
+	}
+	p.fns = make([]*fnProto, d.count())
+	for i := range p.fns {
+		f := &fnProto{name: d.string(), isMacro: d.bool()}
+		f.arities = make([]*compiledArity, d.count())
+		for j := range f.arities {
+			params, ok := d.node().(VectorNode)
+			assert(ok, "invalid module: bad params")
+			a := &compiledArity{arity: arity{params: params, required: d.int(), variadic: d.bool()}}
+			a.params = d.ints()
+			if d.bool() {
+				a.bind = d.binder()
+			}
+			a.proto = d.proto()
+			a.level, a.code, a.proto.arity = a.proto.level, a.proto.eval, a
+			f.arities[j] = a
+		}
+		p.fns[i] = f
+	}
+	p.protos = make([]*proto, d.count())
+	for i := range p.protos {
+		p.protos[i] = d.proto()
+	}
+	p.fails = make([]failure, d.count())
+	for i := range p.fails {
+		err := Error{Pos: d.position(), Form: d.node(), Err: errors.New(d.string()), Stack: d.frames()}
+		p.fails[i] = failure{err, d.site()}
+	}
+	p.names = d.strings()
+	return p
+}
+
+func (d *decoder) listNode() ListNode {
+	ln, ok := d.node().(ListNode)
+	assert(ok, "invalid module: bad form")
+	return ln
+}
@@ -0,0 +1,174 @@
+package gowen_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var moduleTests = []evalTest{
+	{"defn & calls", "(defn sq [x] (* x x)) [(sq 3) (vec (map sq [1 2]))]", "[9 [1 4]]"},
+	{"macros", "(defmacro unless [c & body] `(if ~c nil (do ~@body))) (unless false 1 2)", "2"},
+	{"loop & recur", "(loop [i 0 acc []] (if (< i 3) (recur (+ i 1) (conj acc i)) acc))", "[0 1 2]"},
+	{"variadic recur", "(defn f [n & xs] (if (= n 0) xs (recur (- n 1) [n xs]))) (f 2 :x)", "'(1 (2 (:x)))"},
+	{"closures in loop", "(loop [i 0 fs []] (if (< i 3) (recur (+ i 1) (conj fs (fn [] i))) (map (fn [f] (f)) fs)))", "'(0 1 2)"},
+	{"destructuring", "(let [[a & {:keys [b]}] [1 :b 2] {c :c} {:c 3}] [a b c])", "[1 2 3]"},
+	{"try & catch", `[(try (throw "boom") (catch e :caught)) (try 1 2 (catch e e))]`, "[:caught 2]"},
+	{"lazy-seq", "(defn nums [n] (lazy-seq (cons n (nums (+ n 1))))) (vec (take 3 (nums 5)))", "[5 6 7]"},
+	{"literals", `[1/3 9223372036854775808 1.5 "s" #{:a} {:b 'c} '(d [e])]`, `[1/3 9223372036854775808 1.5 "s" #{:a} {:b 'c} '(d [e])]`},
+	{"reader macros", "(defn ^:private f [^long x] (#(* % %) x)) [(f 3) #_(f 4) (@#'f 2)]", "[9 4]"},
+	{"metadata", `(def ^:a x "doc" 1) [(get (meta (var x)) :doc) (get (meta (var x)) :a)]`, `["doc" true]`},
+	{"characters & regexes", `[\a (re-find #"b+" "abbc")]`, `[\a "bb"]`},
+	{"late macro", "(defn f [] (m 1)) (defmacro m [x] `[~x ~x]) (f)", "[1 1]"},
+	{"borrowed & kept args", "(defn f [x] [(+ x (- x 1)) (list x x)]) [(f 2) (f 3)]", "[[3 '(2 2)] [5 '(3 3)]]"},
+}
+
+// Modules are compiled in one env and evaluated in another after a round trip through their binary form.
+func TestModule(t *testing.T) {
+	for _, test := range moduleTests {
+		nodes, err := gowen.Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		m, err := gowen.CompileModule(nodes, gowen.NewEnv(false))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		data, err := m.MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		m, env := &gowen.Module{}, gowen.NewEnv(false)
+		if err := m.UnmarshalBinary(data); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		result, err := m.Eval(env)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if ls, ok := result.(gowen.LazySeqNode); ok {
+			result = gowen.ListNode{Nodes: ls.Seq()}
+		}
+		expected, err := gowen.ParseAndEval(test.expected, env)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, result, expected)
+		}
+	}
+}
+
+// Errors in bytecode are reported like errors of compiled closures.
+func TestModuleErrors(t *testing.T) {
+	for _, test := range evalErrorTests {
+		nodes, err := gowen.ParseFile("test.gow", test.input)
+		if err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		if _, err = gowen.CompileModule(nodes, gowen.NewEnv(false)); err == nil || err.Error() != test.err {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, err, test.err)
+		}
+	}
+}
+
+// The fns of the stack tests are loaded from a module - the positions of their forms survive serialization.
+func TestModuleStack(t *testing.T) {
+	for _, test := range stackTests {
+		nodes, err := gowen.ParseFile("test.gow", test.input)
+		if err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		env := gowen.NewEnv(false)
+		m, err := gowen.CompileModule(nodes[:len(nodes)-1], gowen.NewEnv(false))
+		if err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		data, _ := m.MarshalBinary()
+		if m = (&gowen.Module{}); m.UnmarshalBinary(data) != nil {
+			t.Errorf("%s: could not load module", test.name)
+			continue
+		} else if _, err := m.Eval(env); err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		_, err = gowen.Eval(nodes[len(nodes)-1], env)
+		gerr, ok := err.(gowen.Error)
+		if !ok {
+			t.Errorf("%s: got %#v", test.name, err)
+			continue
+		}
+		frames := []string{}
+		for _, f := range gerr.Stack {
+			frames = append(frames, fmt.Sprintf("%s [%s] %s", f.Name, f.Kind, f.Pos))
+		}
+		if !reflect.DeepEqual(frames, test.frames) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, frames, test.frames)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	out, err := gowen.ParseAndEval("(disassemble (fn inc [x] (+ x 1)))", gowen.NewEnv(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"fn inc [x] (slots 2, stack 3)", "global         0  ; +", "local          1  ; x", "tail-call      0  ; 2 args"} {
+		if !strings.Contains(out.ToGo().(string), s) {
+			t.Errorf("expected disassembly to contain %q - got\n%s", s, out)
+		}
+	}
+}
+
+func TestModuleSerializationErrors(t *testing.T) {
+	env := gowen.NewEnv(false)
+	env.Set("go-value", struct{}{})
+	nodes, _ := gowen.Parse("(defmacro m [] go-value) (m)")
+	m, err := gowen.CompileModule(nodes, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.MarshalBinary(); err == nil || !strings.Contains(err.Error(), "cannot serialize") {
+		t.Errorf("expected serialization of Go values to fail - got %v", err)
+	}
+	if err := (&gowen.Module{}).UnmarshalBinary([]byte("foo")); err == nil {
+		t.Errorf("expected invalid modules to fail")
+	}
+}
+
+func BenchmarkModule(b *testing.B) {
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			env := gowen.NewEnv(false)
+			if _, err := gowen.ParseAndEval(bm.setup+" nil", env); err != nil {
+				b.Fatal(err)
+			}
+			nodes, err := gowen.Parse(bm.input)
+			if err != nil {
+				b.Fatal(err)
+			}
+			m, err := gowen.CompileModule(nodes, env)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := m.Eval(env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,44 +2,120 @@ package gowen
 
 import (
 	"fmt"
+	"hash/fnv"
+	"math/big"
 	"reflect"
+	"regexp"
+	"unicode"
 )
 
+// Node is a gowen value. Equal compares nodes by value - positions are ignored, sequential nodes (lists, vectors
+// and Go slices) are equal if their elements are and maps if their entries are. Hash is consistent with Equal
+// (equal nodes have equal hashes) and determines the identity of map keys and set elements.
 type Node interface {
 	ToGo() Any
 	String() string
 	Seq() []Node
 	Conj(Node) Node
 	Get(Node) Node
+	Equal(Node) bool
+	Hash() uint32
 }
 
-type SymbolNode struct{ Value string }
+// Position is the location of a node in the source it was parsed from.
+// Only forms (symbols and collections) carry a position - keywords and literals are plain values
+// that are used as map keys and must compare equal regardless of where they were read.
+// The zero value marks nodes that did not come out of the parser (e.g. created by macros or builtins).
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+type SymbolNode struct {
+	Value string
+	Pos   Position
+	Meta  MapNode // metadata attached by the reader (^) - see withMeta
+}
 type KeywordNode struct{ Value string }
 type LiteralNode struct{ Value Any }
 
-type ListNode struct{ Nodes []Node }
-type VectorNode struct{ Nodes []Node }
-type MapNode struct{ Nodes map[Node]Node }
-type ArrayMapNode struct{ Nodes []Node }
+type ListNode struct {
+	Nodes []Node
+	Pos   Position
+	Meta  MapNode
+}
+
+// VectorNode, MapNode and SetNode are persistent (immutable) collections - see vector.go and hamt.go.
+// Their zero values are empty collections. Vectors and maps used to be plain Go slices and maps in an exported
+// Nodes field - Go code written against them migrates by replacing VectorNode{Nodes: ns} with NewVector(ns...),
+// MapNode{Nodes: m} with NewMapFromNodes(m) and reads of the field with calls of Nodes().
+type VectorNode struct {
+	vector *vector
+	Pos    Position
+	Meta   MapNode
+}
+type MapNode struct{ m *hamt }
+type SetNode struct{ m *hamt }
+type ArrayMapNode struct {
+	Nodes []Node
+	Pos   Position
+	Meta  MapNode
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	s := fmt.Sprintf("%d:%d", p.Line, p.Column)
+	if p.File != "" {
+		s = p.File + ":" + s
+	}
+	return s
+}
 
 func (n SymbolNode) Seq() []Node      { panic(errorf("seq on SymbolNode %v", n)) }
 func (n SymbolNode) Conj(_ Node) Node { panic(errorf("conj on SymbolNode %v", n)) }
 func (n SymbolNode) Get(_ Node) Node  { panic(errorf("get on SymbolNode %v", n)) }
 func (n SymbolNode) String() string   { return n.Value }
-func (n SymbolNode) ToGo() Any        { return n }
+func (n SymbolNode) ToGo() Any        { return SymbolNode{Value: n.Value} }
+func (n SymbolNode) Hash() uint32     { return hashString("symbol:" + n.Value) }
+
+func (n SymbolNode) Equal(x Node) bool {
+	sn, ok := x.(SymbolNode)
+	return ok && sn.Value == n.Value
+}
 
 func (n KeywordNode) Seq() []Node      { panic(errorf("seq on KeywordNode %v", n)) }
 func (n KeywordNode) Conj(_ Node) Node { panic(errorf("conj on KeywordNode %v", n)) }
 func (n KeywordNode) Get(_ Node) Node  { panic(errorf("get on KeywordNode %v", n)) }
 func (n KeywordNode) String() string   { return ":" + n.Value }
 func (n KeywordNode) ToGo() Any        { return n }
+func (n KeywordNode) Hash() uint32     { return hashString("keyword:" + n.Value) }
+
+func (n KeywordNode) Equal(x Node) bool {
+	kn, ok := x.(KeywordNode)
+	return ok && kn.Value == n.Value
+}
 
-func (n ListNode) Seq() []Node      { return n.Nodes }
-func (n ListNode) Conj(x Node) Node { return ListNode{copyAppendNodes([]Node{x}, n.Nodes...)} }
+func (n ListNode) Seq() []Node       { return n.Nodes }
+func (n ListNode) Conj(x Node) Node  { return ListNode{Nodes: copyAppendNodes([]Node{x}, n.Nodes...)} }
+func (n ListNode) Equal(x Node) bool { return equalSeq(n.Nodes, x) }
+func (n ListNode) Hash() uint32      { return hashSeq(n.Nodes) }
+
+var intType = reflect.TypeOf(0)
+
+// indexArg converts the index x to an int - indexes that do not fit into an int are errors rather than truncated.
+func indexArg(x Node) int {
+	v := x.(LiteralNode).Value
+	if i, ok := numberArg(v, intType); ok {
+		return int(i.Int())
+	}
+	return int(reflect.ValueOf(v).Convert(intType).Int())
+}
 
 func (n ListNode) Get(x Node) Node {
-	i := reflect.ValueOf(x.(LiteralNode).Value).Convert(reflect.TypeOf(0)).Int()
-	if len(n.Nodes) <= int(i) {
+	i := indexArg(x)
+	if len(n.Nodes) <= i {
 		return LiteralNode{nil}
 	}
 	return n.Nodes[i]
@@ -64,20 +140,64 @@ func (n ListNode) ToGo() Any {
 	return values
 }
 
-func (n VectorNode) Seq() []Node      { return n.Nodes }
-func (n VectorNode) Conj(x Node) Node { return VectorNode{copyAppendNodes(n.Nodes, x)} }
+// NewVector returns a vector of ns.
+func NewVector(ns ...Node) VectorNode { return VectorNode{vector: newVector(ns)} }
+
+// Nodes returns the elements of n - see VectorNode for the migration from the former Nodes field.
+func (n VectorNode) Nodes() []Node { return n.Seq() }
+
+func (n VectorNode) Count() int {
+	if n.vector == nil {
+		return 0
+	}
+	return n.vector.count
+}
+
+// Nth returns the node at index i - or nil if i is out of bounds.
+func (n VectorNode) Nth(i int) Node {
+	if i < 0 || i >= n.Count() {
+		return nil
+	}
+	return n.vector.nth(i)
+}
+
+// Assoc returns a copy of n with the node at index i replaced by x. i == Count() appends x.
+func (n VectorNode) Assoc(i int, x Node) VectorNode {
+	assert(i >= 0 && i <= n.Count(), "index %d out of bounds for vector of length %d", i, n.Count())
+	if n.vector == nil {
+		return NewVector(x)
+	}
+	return VectorNode{vector: n.vector.assoc(i, x)}
+}
+
+func (n VectorNode) Seq() []Node {
+	if n.vector == nil {
+		return []Node{}
+	}
+	return n.vector.nodes()
+}
+
+func (n VectorNode) Conj(x Node) Node { return VectorNode{vector: n.vector.conj(x)} }
+func (n VectorNode) Hash() uint32     { return hashSeq(n.Seq()) }
+
+func (n VectorNode) Equal(x Node) bool {
+	if vn, ok := x.(VectorNode); ok && vn.Count() != n.Count() {
+		return false
+	}
+	return equalSeq(n.Seq(), x)
+}
 
 func (n VectorNode) Get(x Node) Node {
-	i := reflect.ValueOf(x.(LiteralNode).Value).Convert(reflect.TypeOf(0)).Int()
-	if len(n.Nodes) <= int(i) {
-		return LiteralNode{nil}
+	i := indexArg(x)
+	if cn := n.Nth(i); cn != nil {
+		return cn
 	}
-	return n.Nodes[i]
+	return LiteralNode{nil}
 }
 
 func (n VectorNode) String() string {
 	s := "["
-	for _, n := range n.Nodes {
+	for _, n := range n.Seq() {
 		s += n.String() + " "
 	}
 	if len(s) > 1 {
@@ -85,45 +205,102 @@ func (n VectorNode) String() string {
 	}
 	return s + "]"
 }
+
 func (n VectorNode) ToGo() Any {
-	values := make([]Any, len(n.Nodes))
-	for i, cn := range n.Nodes {
-		values[i] = cn.ToGo()
+	values := make([]Any, 0, n.Count())
+	for _, cn := range n.Seq() {
+		values = append(values, cn.ToGo())
 	}
 	return values
 }
 
-func (n MapNode) Seq() []Node {
-	ns := []Node{}
-	for k, v := range n.Nodes {
-		ns = append(ns, VectorNode{[]Node{k, v}})
+// NewMap returns a map of the key value pairs kvs.
+func NewMap(kvs ...Node) MapNode {
+	assert(len(kvs)%2 == 0, "map must have an even number of elements")
+	m := MapNode{}
+	for i := 0; i < len(kvs); i += 2 {
+		m = m.Assoc(kvs[i], kvs[i+1])
+	}
+	return m
+}
+
+// NewMapFromNodes returns a map of the entries of m.
+func NewMapFromNodes(m map[Node]Node) MapNode {
+	n := MapNode{}
+	for k, v := range m {
+		n = n.Assoc(k, v)
+	}
+	return n
+}
+
+// Nodes returns the entries of n as a Go map - see MapNode for the migration from the former Nodes field.
+// Like the field it is keyed by the nodes themselves: it panics if n has keys that are not comparable (e.g. lists).
+func (n MapNode) Nodes() map[Node]Node {
+	m := make(map[Node]Node, n.Count())
+	n.each(func(k, v Node) { m[k] = v })
+	return m
+}
+
+func (n MapNode) Count() int {
+	if n.m == nil {
+		return 0
 	}
+	return n.m.count
+}
+
+// Lookup returns the value of key k and whether n contains k.
+func (n MapNode) Lookup(k Node) (Node, bool) { return n.m.get(k) }
+func (n MapNode) Assoc(k, v Node) MapNode    { return MapNode{n.m.assoc(k, v)} }
+func (n MapNode) Dissoc(k Node) MapNode      { return MapNode{n.m.dissoc(k)} }
+func (n MapNode) each(f func(k, v Node))     { n.m.each(f) }
+
+func (n MapNode) Seq() []Node {
+	ns := make([]Node, 0, n.Count())
+	n.each(func(k, v Node) { ns = append(ns, NewVector(k, v)) })
 	return ns
 }
 
 func (n MapNode) Conj(x Node) Node {
-	m := map[Node]Node{}
-	for k, v := range n.Nodes {
-		m[k] = v
+	if mn, ok := x.(MapNode); ok {
+		mn.each(func(k, v Node) { n = n.Assoc(k, v) })
+		return n
 	}
 	ns := x.Seq()
-	m[ns[0]] = ns[1]
-	return MapNode{m}
+	assert(len(ns) == 2, "conj on map requires a key value pair: %s", x)
+	return n.Assoc(ns[0], ns[1])
 }
 
 func (n MapNode) Get(x Node) Node {
-	v, ok := n.Nodes[x]
+	v, ok := n.Lookup(x)
 	if !ok {
 		return LiteralNode{nil}
 	}
 	return v
 }
 
+func (n MapNode) Equal(x Node) bool {
+	m, ok := toMap(x)
+	if !ok || m.Count() != n.Count() {
+		return false
+	}
+	equal := true
+	n.each(func(k, v Node) {
+		v2, ok := m.Lookup(k)
+		equal = equal && ok && v.Equal(v2)
+	})
+	return equal
+}
+
+// Hash does not depend on the order of the entries - it is the sum of the hashes of the key value pairs.
+func (n MapNode) Hash() uint32 {
+	h := uint32(0)
+	n.each(func(k, v Node) { h += k.Hash() ^ v.Hash() })
+	return h
+}
+
 func (n MapNode) String() string {
 	s := "{"
-	for k, v := range n.Nodes {
-		s += k.String() + " " + v.String() + ", "
-	}
+	n.each(func(k, v Node) { s += k.String() + " " + v.String() + ", " })
 	if len(s) > 1 {
 		s = s[:len(s)-2]
 	}
@@ -132,8 +309,88 @@ func (n MapNode) String() string {
 
 func (n MapNode) ToGo() Any {
 	m := map[Any]Any{}
-	for k, v := range n.Nodes {
-		m[k.ToGo()] = v.ToGo()
+	n.each(func(k, v Node) { m[goKey(k)] = v.ToGo() })
+	return m
+}
+
+// goKey returns the Go value of the map key n - or n itself if that is not comparable (e.g. the slice of a vector).
+// Keys that are not comparable either are represented by their printed form.
+func goKey(n Node) Any {
+	for _, k := range []Any{n.ToGo(), n} {
+		if v := reflect.ValueOf(k); !v.IsValid() || v.Comparable() {
+			return k
+		}
+	}
+	return n.String()
+}
+
+// NewSet returns a set of ns.
+func NewSet(ns ...Node) SetNode {
+	s := SetNode{}
+	for _, n := range ns {
+		s = s.Conj(n).(SetNode)
+	}
+	return s
+}
+
+func (n SetNode) Count() int {
+	if n.m == nil {
+		return 0
+	}
+	return n.m.count
+}
+
+func (n SetNode) Contains(x Node) bool { _, ok := n.m.get(x); return ok }
+func (n SetNode) Disj(x Node) SetNode  { return SetNode{n.m.dissoc(x)} }
+func (n SetNode) Conj(x Node) Node     { return SetNode{n.m.assoc(x, x)} }
+
+func (n SetNode) Seq() []Node {
+	ns := make([]Node, 0, n.Count())
+	n.m.each(func(k, _ Node) { ns = append(ns, k) })
+	return ns
+}
+
+func (n SetNode) Get(x Node) Node {
+	if v, ok := n.m.get(x); ok {
+		return v
+	}
+	return LiteralNode{nil}
+}
+
+func (n SetNode) Equal(x Node) bool {
+	s, ok := toSet(x)
+	if !ok || s.Count() != n.Count() {
+		return false
+	}
+	for _, n := range n.Seq() {
+		if !s.Contains(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n SetNode) Hash() uint32 {
+	h := uint32(0)
+	n.m.each(func(k, _ Node) { h += k.Hash() })
+	return h
+}
+
+func (n SetNode) String() string {
+	s := "#{"
+	for _, n := range n.Seq() {
+		s += n.String() + " "
+	}
+	if len(s) > 2 {
+		s = s[:len(s)-1]
+	}
+	return s + "}"
+}
+
+func (n SetNode) ToGo() Any {
+	m := map[Any]struct{}{}
+	for _, n := range n.Seq() {
+		m[goKey(n)] = struct{}{}
 	}
 	return m
 }
@@ -141,24 +398,27 @@ func (n MapNode) ToGo() Any {
 func (n ArrayMapNode) Seq() []Node {
 	ns := []Node{}
 	for i := 0; i < len(n.Nodes); i += 2 {
-		ns = append(ns, VectorNode{n.Nodes[i : i+2]})
+		ns = append(ns, NewVector(n.Nodes[i], n.Nodes[i+1]))
 	}
 	return ns
 }
 
 func (n ArrayMapNode) Conj(x Node) Node {
-	return ArrayMapNode{copyAppendNodes(n.Nodes, x.(VectorNode).Nodes...)}
+	return ArrayMapNode{Nodes: copyAppendNodes(n.Nodes, x.Seq()...)}
 }
 
 func (n ArrayMapNode) Get(x Node) Node {
 	for i := 0; i < len(n.Nodes); i += 2 {
-		if reflect.DeepEqual(n.Nodes[i], x) {
+		if n.Nodes[i].Equal(x) {
 			return n.Nodes[i+1]
 		}
 	}
 	return LiteralNode{nil}
 }
 
+func (n ArrayMapNode) Equal(x Node) bool { return NewMap(n.Nodes...).Equal(x) }
+func (n ArrayMapNode) Hash() uint32      { return NewMap(n.Nodes...).Hash() }
+
 func (n ArrayMapNode) String() string {
 	s := "{"
 	for i := 0; i < len(n.Nodes); i += 2 {
@@ -173,7 +433,7 @@ func (n ArrayMapNode) String() string {
 func (n ArrayMapNode) ToGo() Any {
 	m := map[Any]Any{}
 	for i := 0; i < len(n.Nodes); i += 2 {
-		m[n.Nodes[i].ToGo()] = n.Nodes[i+1].ToGo()
+		m[goKey(n.Nodes[i])] = n.Nodes[i+1].ToGo()
 	}
 	return m
 }
@@ -183,10 +443,9 @@ func (n LiteralNode) Seq() []Node {
 	case n.Value == nil:
 		return []Node{}
 	case v.Kind() == reflect.String:
-		s := v.String()
-		ns := make([]Node, len(s))
-		for i, c := range s {
-			ns[i] = LiteralNode{string(c)}
+		ns := []Node{}
+		for _, r := range v.String() {
+			ns = append(ns, LiteralNode{r})
 		}
 		return ns
 	case v.Kind() == reflect.Slice:
@@ -198,7 +457,7 @@ func (n LiteralNode) Seq() []Node {
 	case v.Kind() == reflect.Map:
 		ns := []Node{}
 		for _, k := range v.MapKeys() {
-			kv := VectorNode{[]Node{ToNode(k.Interface()), ToNode(v.MapIndex(k).Interface())}}
+			kv := NewVector(ToNode(k.Interface()), ToNode(v.MapIndex(k).Interface()))
 			ns = append(ns, kv)
 		}
 		return ns
@@ -210,19 +469,19 @@ func (n LiteralNode) Seq() []Node {
 func (n LiteralNode) Conj(x Node) Node {
 	switch v := reflect.ValueOf(n.Value); {
 	case n.Value == nil:
-		return ListNode{[]Node{x}}
+		return ListNode{Nodes: []Node{x}}
 	case v.Kind() == reflect.Slice:
 		ns := make([]Node, v.Len())
 		for i := 0; i < v.Len(); i++ {
 			ns[i] = ToNode(v.Index(i).Interface())
 		}
-		return ListNode{append(ns, x)}
+		return ListNode{Nodes: append(ns, x)}
 	case v.Kind() == reflect.Map:
 		ns := make([]Node, v.Len()*2)
 		for _, k := range v.MapKeys() {
 			ns = append(ns, ToNode(k.Interface()), ToNode(v.MapIndex(k).Interface()))
 		}
-		return ArrayMapNode{append(ns, x.Seq()...)}
+		return ArrayMapNode{Nodes: append(ns, x.Seq()...)}
 	default:
 		panic(errorf("conj on LiteralNode %v", n))
 	}
@@ -233,15 +492,21 @@ func (n LiteralNode) Get(x Node) Node {
 	case n.Value == nil:
 		return n
 	case v.Kind() == reflect.Slice:
-		i := reflect.ValueOf(x.(LiteralNode).Value).Convert(reflect.TypeOf(0)).Int()
-		if int(i) >= v.Len() {
+		i := indexArg(x)
+		if i >= v.Len() {
 			return LiteralNode{nil}
 		}
-		return LiteralNode{v.Index(int(i)).Interface()}
+		return LiteralNode{v.Index(i).Interface()}
 	case v.Kind() == reflect.Map:
-		result := v.MapIndex(reflect.ValueOf(x.ToGo()))
-		if result.IsValid() {
-			return LiteralNode{result.Interface()}
+		if k := reflect.ValueOf(x.ToGo()); k.IsValid() && k.Type().AssignableTo(v.Type().Key()) && k.Type().Comparable() {
+			if result := v.MapIndex(k); result.IsValid() {
+				return LiteralNode{result.Interface()}
+			}
+		}
+		for _, k := range v.MapKeys() {
+			if ToNode(k.Interface()).Equal(x) {
+				return LiteralNode{v.MapIndex(k).Interface()}
+			}
 		}
 		return LiteralNode{nil}
 	default:
@@ -249,5 +514,254 @@ func (n LiteralNode) Get(x Node) Node {
 	}
 }
 
-func (n LiteralNode) String() string { return fmt.Sprintf("%#v", n.Value) }
-func (n LiteralNode) ToGo() Any      { return n.Value }
+func (n LiteralNode) String() string {
+	switch x := n.Value.(type) {
+	case rune:
+		return formatChar(x)
+	case *regexp.Regexp:
+		return `#"` + x.String() + `"`
+	case error:
+		return "#<error " + x.Error() + ">"
+	}
+	if s, ok := formatNumber(n.Value); ok {
+		return s
+	}
+	return fmt.Sprintf("%#v", n.Value)
+}
+
+// formatChar returns the character literal of r - see parseChar.
+func formatChar(r rune) string {
+	for name, c := range charNames {
+		if c == r {
+			return `\` + name
+		}
+	}
+	if !unicode.IsPrint(r) {
+		return fmt.Sprintf(`\u%04x`, r)
+	}
+	return `\` + string(r)
+}
+
+func (n LiteralNode) ToGo() Any { return n.Value }
+
+func (n LiteralNode) Equal(x Node) bool {
+	if ns, ok := toSeq(n); ok {
+		return equalSeq(ns, x)
+	} else if s, ok := toSet(n); ok {
+		return s.Equal(x)
+	} else if m, ok := toMap(n); ok {
+		return m.Equal(x)
+	}
+	ln, ok := x.(LiteralNode)
+	return ok && equalValues(n.Value, ln.Value)
+}
+
+func (n LiteralNode) Hash() uint32 {
+	if ns, ok := toSeq(n); ok {
+		return hashSeq(ns)
+	} else if s, ok := toSet(n); ok {
+		return s.Hash()
+	} else if m, ok := toMap(n); ok {
+		return m.Hash()
+	}
+	return hashValue(n.Value)
+}
+
+func position(n Node) Position {
+	switch n := n.(type) {
+	case SymbolNode:
+		return n.Pos
+	case ListNode:
+		return n.Pos
+	case VectorNode:
+		return n.Pos
+	case ArrayMapNode:
+		return n.Pos
+	default:
+		return Position{}
+	}
+}
+
+// withoutPosition returns a copy of n with the positions of n and all its children removed.
+func withoutPosition(n Node) Node {
+	switch n := n.(type) {
+	case SymbolNode:
+		return SymbolNode{Value: n.Value, Meta: n.Meta}
+	case ListNode:
+		return ListNode{Nodes: withoutPositions(n.Nodes), Meta: n.Meta}
+	case VectorNode:
+		v := NewVector(withoutPositions(n.Seq())...)
+		v.Meta = n.Meta
+		return v
+	case ArrayMapNode:
+		return ArrayMapNode{Nodes: withoutPositions(n.Nodes), Meta: n.Meta}
+	case SetNode:
+		return NewSet(withoutPositions(n.Seq())...)
+	default:
+		return n
+	}
+}
+
+func withoutPositions(ns []Node) []Node {
+	if ns == nil {
+		return nil
+	}
+	out := make([]Node, len(ns))
+	for i, n := range ns {
+		out[i] = withoutPosition(n)
+	}
+	return out
+}
+
+// metaOf returns the metadata of n - an empty map for nodes that cannot have metadata.
+func metaOf(n Node) MapNode {
+	switch n := n.(type) {
+	case SymbolNode:
+		return n.Meta
+	case ListNode:
+		return n.Meta
+	case VectorNode:
+		return n.Meta
+	case ArrayMapNode:
+		return n.Meta
+	}
+	return MapNode{}
+}
+
+// withMeta returns a copy of n with the metadata m. Only symbols, lists, vectors and literal maps have metadata.
+func withMeta(n Node, m MapNode) Node {
+	switch n := n.(type) {
+	case SymbolNode:
+		n.Meta = m
+		return n
+	case ListNode:
+		n.Meta = m
+		return n
+	case VectorNode:
+		n.Meta = m
+		return n
+	case ArrayMapNode:
+		n.Meta = m
+		return n
+	}
+	panic(errorf("cannot attach metadata to %s", n))
+}
+
+// toSeq returns the elements of sequential nodes - lists, vectors and Go slices & arrays.
+func toSeq(n Node) ([]Node, bool) {
+	switch n := n.(type) {
+	case ListNode:
+		return n.Nodes, true
+	case VectorNode, LazySeqNode:
+		return n.Seq(), true
+	case LiteralNode:
+		if k := reflect.ValueOf(n.Value).Kind(); k == reflect.Slice || k == reflect.Array {
+			return n.Seq(), true
+		}
+	}
+	return nil, false
+}
+
+// toMap returns associative nodes (maps, unevaluated literal maps and Go maps) as MapNode.
+func toMap(n Node) (MapNode, bool) {
+	switch n := n.(type) {
+	case MapNode:
+		return n, true
+	case ArrayMapNode:
+		return NewMap(n.Nodes...), true
+	case LiteralNode:
+		if v := reflect.ValueOf(n.Value); v.Kind() == reflect.Map && !isGoSet(v.Type()) {
+			m := MapNode{}
+			for _, k := range v.MapKeys() {
+				m = m.Assoc(ToNode(k.Interface()), ToNode(v.MapIndex(k).Interface()))
+			}
+			return m, true
+		}
+	}
+	return MapNode{}, false
+}
+
+// toSet returns sets and Go sets (map[T]struct{}) as SetNode.
+func toSet(n Node) (SetNode, bool) {
+	switch n := n.(type) {
+	case SetNode:
+		return n, true
+	case LiteralNode:
+		if v := reflect.ValueOf(n.Value); v.IsValid() && isGoSet(v.Type()) {
+			s := SetNode{}
+			for _, k := range v.MapKeys() {
+				s = s.Conj(ToNode(k.Interface())).(SetNode)
+			}
+			return s, true
+		}
+	}
+	return SetNode{}, false
+}
+
+func isGoSet(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem() == reflect.TypeOf(struct{}{})
+}
+
+func equalSeq(ns []Node, x Node) bool {
+	xs, ok := toSeq(x)
+	if !ok || len(xs) != len(ns) {
+		return false
+	}
+	for i := range ns {
+		if !ns[i].Equal(xs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func hashSeq(ns []Node) uint32 {
+	h := uint32(1)
+	for _, n := range ns {
+		h = 31*h + n.Hash()
+	}
+	return h
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// equalValues compares the values of literals. Numbers are only equal to numbers of the same kind
+// (int, big int, ratio or double) - (= 1 1.0) is false. Other values are compared with reflect.DeepEqual.
+func equalValues(a, b Any) bool {
+	a, b = normalizeNumber(a), normalizeNumber(b)
+	switch a := a.(type) {
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case *big.Rat:
+		b, ok := b.(*big.Rat)
+		return ok && a.Cmp(b) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// hashValue hashes the values of literals consistently with equalValues. Values that are not numbers, strings or
+// booleans are only hashed by their type as reflect.DeepEqual compares the values pointers point to.
+func hashValue(x Any) uint32 {
+	x = normalizeNumber(x)
+	if f, ok := x.(float64); ok && f == 0 {
+		x = 0.0 // -0.0 == 0.0
+	}
+	switch x.(type) {
+	case *big.Int, *big.Rat:
+		return hashString(fmt.Sprintf("%T:%v", x, x))
+	}
+	switch reflect.ValueOf(x).Kind() {
+	case reflect.Invalid, reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return hashString(fmt.Sprintf("%T:%v", x, x))
+	default:
+		return hashString(fmt.Sprintf("%T", x))
+	}
+}
@@ -12,18 +12,18 @@ type readPrintReadTest struct {
 }
 
 var readPrintReadPrintTests = []readPrintReadTest{
-	{"numbers", "0 1 2.0 -3 +4.20 1e6 1000000", "0 1 2 -3 4.2 1000000 1e6"},
+	{"numbers", "0 1 2.0 -3 +4.20 1e6 1000000 +2/4 9223372036854775808", "0 1 2.0 -3 4.2 1000000.0 1000000 1/2 9223372036854775808"},
 	{"strings", `"foo" "foo\nbar"`, `"foo" "foo\nbar"`},
-	{"collections", "{1 2} [3 4] (5 6)", "{1 2} [3 4] (5 6)"},
+	{"collections", "{1 2} [3 4] (5 6) #{7}", "{1 2} [3 4] (5 6) #{7}"},
 }
 
 func TestReadPrintReadPrint(t *testing.T) {
 	for _, test := range readPrintReadPrintTests {
 		results := []Node{}
 		for _, node := range parse(test.input) {
-			results = append(results, parse(node.String())[0])
+			results = append(results, withoutPosition(parse(node.String())[0]))
 		}
-		expected := parse(test.expected)
+		expected := withoutPositions(parse(test.expected))
 		if !reflect.DeepEqual(results, expected) {
 			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, results, expected)
 		}
@@ -41,7 +41,7 @@ var seqTests = []seqTest{
 	{"VectorNode", `[1 2 3]`, `(1 2 3)`},
 	{"MapNode", `{1 2}`, `([1 2])`},
 	{"ArrayMapNode", `'{1 2}`, `([1 2])`},
-	{"string", `"foo"`, `("f" "o" "o")`},
+	{"string", `"foo"`, `(\f \o \o)`},
 	{"nil", `nil`, `()`},
 }
 
@@ -49,7 +49,7 @@ func TestSeq(t *testing.T) {
 	for _, test := range seqTests {
 		env := NewEnv(false)
 		result := eval(wrapInCall("seq", parse(test.input)), env)
-		expected := parse(test.output)[0]
+		expected := withoutPosition(parse(test.output)[0])
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, result, expected)
 		}
@@ -64,11 +64,11 @@ type getTest struct {
 }
 
 var getTests = []getTest{
-	{"nil", "nil", 0.0, nil},
-	{"List", "'(1 2 3)", 1.0, 2.0},
-	{"Vector", "[1 2 3]", 1.0, 2.0},
-	{"Map", "{1 2 3 4}", 3.0, 4.0},
-	{"ArrayMap", "'{1 2 3 4}", 3.0, 4.0},
+	{"nil", "nil", int64(0), nil},
+	{"List", "'(1 2 3)", int64(1), int64(2)},
+	{"Vector", "[1 2 3]", int64(1), int64(2)},
+	{"Map", "{1 2 3 4}", int64(3), int64(4)},
+	{"ArrayMap", "'{1 2 3 4}", int64(3), int64(4)},
 }
 
 func TestGet(t *testing.T) {
@@ -123,16 +123,91 @@ var concatTests = []concatTest{
 	{"List", "'(1 2) nil [3 4] [5]", "'(1 2 3 4 5)"},
 	{"Map", "'(1 2) nil {3 4}", "'(1 2 [3 4])"},
 	{"ArrayMap", "'(1 2) nil '{3 4}", "'(1 2 [3 4])"},
-	{"string", `'(1 2) nil "foo"`, `'(1 2 "f" "o" "o")`},
+	{"string", `'(1 2) nil "foo"`, `'(1 2 \f \o \o)`},
 }
 
 func TestConcat(t *testing.T) {
 	for _, test := range concatTests {
 		env := NewEnv(false)
 		result := eval(wrapInCall("concat", parse(test.xs)), env)
-		expected := eval(parse(test.output)[0], env)
+		expected := withoutPosition(eval(parse(test.output)[0], env))
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, result, expected)
 		}
 	}
 }
+
+type equalTest struct {
+	name  string
+	a     string
+	b     string
+	equal bool
+}
+
+var equalTests = []equalTest{
+	{"numbers", "1", "1", true},
+	{"int & double", "1", "1.0", false},
+	{"big integers & ratios", "[9223372036854775808 1/2]", "[9223372036854775808 2/4]", true},
+	{"list & vector", "'(1 2)", "[1 2]", true},
+	{"list & vector of different length", "'(1 2)", "[1 2 3]", false},
+	{"nested", "[[1 2] {:a '(3)}]", "['(1 2) {:a [3]}]", true},
+	{"maps", "{:a 1 :b 2}", "{:b 2 :a 1}", true},
+	{"map & literal map", "{:a 1 :b 2}", "'{:b 2 :a 1}", true},
+	{"maps with different values", "{:a 1}", "{:a 2}", false},
+	{"vector keys", "{[1 2] :a}", "{'(1 2) :a}", true},
+	{"sets", "#{1 [2]}", "#{'(2) 1}", true},
+	{"set & vector", "#{1}", "[1]", false},
+	{"symbols & positions", "'foo", "(quote foo)", true},
+	{"keyword & string", ":a", `"a"`, false},
+	{"nil", "nil", "'()", false},
+}
+
+func TestEqual(t *testing.T) {
+	for _, test := range equalTests {
+		env := NewEnv(false)
+		a, b := eval(parse(test.a)[0], env), eval(parse(test.b)[0], env)
+		if a.Equal(b) != test.equal || b.Equal(a) != test.equal {
+			t.Errorf("%s: expected (= %s %s) to be %v", test.name, a, b, test.equal)
+		} else if test.equal && a.Hash() != b.Hash() {
+			t.Errorf("%s: expected equal hashes for %s and %s", test.name, a, b)
+		}
+	}
+	goValues := []Node{LiteralNode{[]int{1, 2}}, LiteralNode{map[string]int{"a": 1}}, LiteralNode{map[string]struct{}{"a": {}}}}
+	nodes := []Node{
+		NewVector(LiteralNode{int64(1)}, LiteralNode{int64(2)}),
+		NewMap(LiteralNode{"a"}, LiteralNode{int64(1)}),
+		NewSet(LiteralNode{"a"}),
+	}
+	for i := range goValues {
+		if !goValues[i].Equal(nodes[i]) || !nodes[i].Equal(goValues[i]) || goValues[i].Hash() != nodes[i].Hash() {
+			t.Errorf("expected %s to equal %s", goValues[i], nodes[i])
+		}
+	}
+}
+
+func TestToGoCollectionKeys(t *testing.T) {
+	v := NewVector(LiteralNode{int64(1)}, LiteralNode{int64(2)})
+	l := ListNode{Nodes: []Node{LiteralNode{int64(1)}}}
+	m := NewMap(v, LiteralNode{"vector"}, l, LiteralNode{"list"}, LiteralNode{int64(1)}, LiteralNode{"int"}).ToGo().(map[Any]Any)
+	if m[v] != "vector" || m["(1)"] != "list" || m[int64(1)] != "int" {
+		t.Errorf("got %#v", m)
+	}
+	if s := NewSet(v, l).ToGo().(map[Any]struct{}); len(s) != 2 {
+		t.Errorf("got %#v", s)
+	}
+}
+
+func TestNodesAccessors(t *testing.T) {
+	ns := []Node{LiteralNode{int64(1)}, KeywordNode{"a"}}
+	if v := NewVector(ns...); !reflect.DeepEqual(v.Nodes(), ns) {
+		t.Errorf("got %v expected %v", v.Nodes(), ns)
+	}
+	m := map[Node]Node{KeywordNode{"a"}: LiteralNode{int64(1)}, LiteralNode{"b"}: NewVector(ns...)}
+	n := NewMapFromNodes(m)
+	if !n.Equal(NewMap(KeywordNode{"a"}, LiteralNode{int64(1)}, LiteralNode{"b"}, NewVector(ns...))) {
+		t.Errorf("got %v", n)
+	}
+	if !reflect.DeepEqual(n.Nodes(), m) {
+		t.Errorf("got %v expected %v", n.Nodes(), m)
+	}
+}
@@ -0,0 +1,244 @@
+package gowen
+
+// Namespaces are top level envs registered (per Runtime) under a name. Files on the LoadPath declare their namespace
+// with (ns name ...) as their first form and are loaded on the first require of that name.
+// Go packages registered under alias/name keys (see lib/core) act as namespaces as well -
+// e.g. (ns foo (:require [strings :as str])) allows (str/split "a b" " ").
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type namespace struct {
+	name    string
+	aliases map[string]string
+}
+
+func (e *Env) namespace() *namespace {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ns == nil {
+		e.ns = &namespace{aliases: map[string]string{}}
+	}
+	return e.ns
+}
+
+// alias returns the name of the namespace alias refers to in the top level env e.
+func (e *Env) alias(alias string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.ns == nil {
+		return "", false
+	}
+	name, ok := e.ns.aliases[alias]
+	return name, ok
+}
+
+func (e *Env) getQualified(nsName, name string) (Node, bool) {
+	if e.runtime == nil {
+		return nil, false
+	}
+	if target, ok := e.topLevel().alias(nsName); ok {
+		nsName = target
+	}
+	return e.runtime.lookupQualified(nsName, name)
+}
+
+func (r *Runtime) lookupQualified(nsName, name string) (Node, bool) {
+	env, key := r.qualified(nsName, name)
+	v, exists := env.lookup(key)
+	return ToNode(v), exists
+}
+
+// qualified returns the env and key nsName/name is defined in - the namespace nsName or the root env.
+func (r *Runtime) qualified(nsName, name string) (*Env, string) {
+	if env, ok := r.namespace(nsName); ok {
+		return env, name
+	}
+	return r.root, nsName + "/" + name
+}
+
+func ns(nodes []Node, env *Env) (Node, *Env, bool) {
+	assert(env.IsTopLevel() && env.parent != nil, "ns must only be called from top level")
+	assert(len(nodes) >= 1, "wrong number of arguments for ns")
+	sn, ok := nodes[0].(SymbolNode)
+	assert(ok, "ns must be called with a symbol as the first argument")
+	env.setNamespace(sn.Value)
+	for _, clause := range nodes[1:] {
+		ln, _ := clause.(ListNode)
+		assert(len(ln.Nodes) >= 1 && ln.Nodes[0] == KeywordNode{"require"}, "unsupported ns clause: %s", clause)
+		for _, spec := range ln.Nodes[1:] {
+			require(spec, env)
+		}
+	}
+	return LiteralNode{nil}, env, true
+}
+
+// require loads the namespace specified by spec and applies its options (:as alias, :refer [names] / :all)
+// to the top level env env. spec is either the symbol of the namespace or a vector of the symbol and options.
+func require(spec Node, env *Env) {
+	name, options := "", []Node{}
+	switch spec := spec.(type) {
+	case SymbolNode:
+		name = spec.Value
+	case VectorNode:
+		sn, ok := spec.Nth(0).(SymbolNode)
+		assert(ok, "require spec must start with a symbol: %s", spec)
+		name, options = sn.Value, spec.Seq()[1:]
+	default:
+		panic(errorf("bad require spec: %s", spec))
+	}
+	nsEnv := env.runtime.loadNamespace(name, env.isLoading())
+	assert(len(options)%2 == 0, "require spec must have an even number of options: %s", spec)
+	for i := 0; i < len(options); i += 2 {
+		option, value := options[i], options[i+1]
+		switch option {
+		case KeywordNode{"as"}:
+			alias, ok := value.(SymbolNode)
+			assert(ok, "alias of require must be a symbol: %s", value)
+			ns := env.namespace()
+			env.mu.Lock()
+			ns.aliases[alias.Value] = name
+			env.mu.Unlock()
+			env.runtime.define()
+		case KeywordNode{"refer"}:
+			if value == (KeywordNode{"all"}) {
+				assert(nsEnv != nil, "cannot refer all of %s", name)
+				nsEnv.each(func(k string, v Any) { env.define(k, v, nsEnv.definition(k)) })
+				continue
+			}
+			vn, ok := value.(VectorNode)
+			assert(ok, "refer of require must be a vector of symbols or :all: %s", value)
+			for _, n := range vn.Seq() {
+				sn, ok := n.(SymbolNode)
+				assert(ok, "refer of require must be a vector of symbols or :all: %s", value)
+				defEnv, key := env.runtime.qualified(name, sn.Value)
+				v, exists := defEnv.lookup(key)
+				assert(exists, "cannot refer %s/%s: not defined", name, sn.Value)
+				env.define(sn.Value, v, defEnv.definition(key))
+			}
+		default:
+			panic(errorf("unsupported require option %s", option))
+		}
+	}
+}
+
+// setNamespace declares the top level env e to be the namespace name. Envs that already belong to another
+// namespace switch to name instead - e then evaluates in the scope of name, which is created if necessary.
+func (e *Env) setNamespace(name string) {
+	current := e.namespace()
+	e.runtime.mu.Lock()
+	defer e.runtime.mu.Unlock()
+	s := e.scope
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := e.runtime.namespaces[name]
+	switch {
+	case current.name == "" || current.name == name:
+		assert(!ok || existing.scope == s, "namespace %s is already defined", name)
+		current.name = name
+		e.runtime.namespaces[name] = e.withState(nil)
+	case ok:
+		assert(existing.scope.home() == s.home(), "namespace %s is already defined", name)
+		e.scope = existing.scope
+	default:
+		e.scope = &scope{ns: &namespace{name: name, aliases: map[string]string{}}, origin: s.home()}
+		e.runtime.namespaces[name] = e.withState(nil)
+	}
+	e.runtime.define()
+}
+
+// home returns the scope of the env s was created for - s itself unless s is the scope of a namespace
+// the env switched to via ns.
+func (s *scope) home() *scope {
+	if s.origin != nil {
+		return s.origin
+	}
+	return s
+}
+
+func (e *Env) isLoading() bool {
+	s := e.scope.home()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loading
+}
+
+func (e *Env) setLoading(loading bool) {
+	s := e.scope.home()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loading = loading
+}
+
+// loadFile evaluates the file of the namespace name. If that fails the namespaces it declared are removed again -
+// so that a later require loads them from scratch rather than finding them half loaded.
+func (r *Runtime) loadFile(name, path, input string) *Env {
+	env := r.NewEnv(false)
+	env.setLoading(true)
+	r.loading[name] = true
+	defer func() {
+		delete(r.loading, name)
+		env.setLoading(false)
+		if x := recover(); x != nil {
+			r.removeNamespaces(env)
+			panic(x)
+		}
+	}()
+	evalTopological(parseFile(path, input), env)
+	existing, ok := r.namespace(name)
+	assert(ok && existing.scope.home() == env.scope.home(), "%s does not declare namespace %s", path, name)
+	return existing
+}
+
+// removeNamespaces removes the namespaces declared by env.
+func (r *Runtime) removeNamespaces(env *Env) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, nsEnv := range r.namespaces {
+		if nsEnv.scope.home() == env.scope.home() {
+			delete(r.namespaces, name)
+		}
+	}
+	r.define()
+}
+
+func (r *Runtime) namespace(name string) (*Env, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	env, ok := r.namespaces[name]
+	return env, ok
+}
+
+// loadNamespace returns the env of the namespace name - loading it from the LoadPath if necessary.
+// Namespaces of Go packages registered under the prefix name/ do not have an env - nil is returned.
+// Namespaces are loaded one at a time so that concurrent requires of a namespace load it only once - isLoading
+// is true for requires from namespaces that are being loaded (i.e. already hold the lock).
+func (r *Runtime) loadNamespace(name string, isLoading bool) *Env {
+	if !isLoading {
+		r.loadMu.Lock()
+		defer r.loadMu.Unlock()
+	}
+	assert(!r.loading[name], "cyclic require of namespace %s", name)
+	if env, ok := r.namespace(name); ok {
+		return env
+	}
+	for _, dir := range r.LoadPath {
+		path := filepath.Join(dir, filepath.FromSlash(strings.NewReplacer(".", "/", "-", "_").Replace(name))+".gow")
+		bs, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		assert(err == nil, "could not load namespace %s: %s", name, err)
+		return r.loadFile(name, path, string(bs))
+	}
+	isPackage := false
+	r.root.each(func(k string, _ Any) { isPackage = isPackage || strings.HasPrefix(k, name+"/") })
+	if isPackage {
+		return nil
+	}
+	panic(errorf("could not find namespace %s in load path %v", name, r.LoadPath))
+}
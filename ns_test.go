@@ -0,0 +1,93 @@
+package gowen_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var nsFiles = map[string]string{
+	"team_a/util.gow": `(ns team-a.util (:require [strings :as str]))
+                        (defn helper [x] (str "a:" x))
+                        (defn shout [x] (str/to-upper (helper x)))`,
+	"team_b/util.gow": `(ns team-b.util)
+                        (defn helper [x] (str "b:" x))`,
+	"team_b/app.gow": `(ns team-b.app (:require [team-a.util :as a :refer [shout]] team-b.util))
+                       (def result [(a/helper 1) (team-b.util/helper 2) (shout "x")])`,
+	"wrong_name.gow": `(ns right-name)`,
+	"cycle_a.gow":    `(ns cycle-a (:require cycle-b))`,
+	"cycle_b.gow":    `(ns cycle-b (:require cycle-a))`,
+	"broken.gow":     `(ns broken) (def x 1) (undefined)`,
+	"multi.gow":      `(ns multi.helpers) (defn h [] 1) (ns multi) (def v (multi.helpers/h))`,
+	"team_c/shared.gow": `(ns team-c.shared (:require team-b.app))
+                          (def result team-b.app/result)`,
+}
+
+var nsTests = []evalTest{
+	{"alias & qualified", `(ns test.app (:require [team-a.util :as a] team-b.util))
+                           [(a/helper 1) (team-b.util/helper 2)]`, `["a:1" "b:2"]`},
+	{"refer", `(ns test.refer (:require [team-a.util :refer [helper]]))
+                (helper 1)`, `"a:1"`},
+	{"refer all", `(require '[team-b.util :refer :all]) (helper 1)`, `"b:1"`},
+	{"transitive", `(require '[team-b.app :as app]) app/result`, `["a:1" "b:2" "A:X"]`},
+	{"go package alias", `(require '[strings :as s]) (s/to-upper "foo")`, `"FOO"`},
+	{"switch", `(ns test.first (:require [team-a.util :as u])) (def x 1)
+                (ns test.second (:require [team-b.util :as u])) (def x 2) (def y (u/helper x))
+                (ns test.first) [x (u/helper x) test.second/y]`, `[1 "a:1" "b:2"]`},
+	{"switch in file", `(require '[multi :as m]) [m/v (multi.helpers/h)]`, `[1 1]`},
+}
+
+func TestNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for path, content := range nsFiles {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(loadPath []string) { gowen.DefaultRuntime.LoadPath = loadPath }(gowen.DefaultRuntime.LoadPath)
+	gowen.DefaultRuntime.LoadPath = []string{dir}
+
+	for _, test := range nsTests {
+		if err := compare(test.input, test.expected); err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+		}
+	}
+
+	for input, expected := range map[string]string{
+		"(require 'does-not.exist)":              "could not find namespace does-not.exist in load path [" + dir + "]",
+		"(require 'wrong-name)":                  filepath.Join(dir, "wrong_name.gow") + " does not declare namespace wrong-name",
+		"(require '[team-a.util :refer [nope]])": "cannot refer team-a.util/nope: not defined",
+		"(require 'cycle-a)":                     "cyclic require of namespace cycle-a",
+		"(require 'broken)":                      `could not lookup symbol "undefined"`,
+		"(require 'broken) broken/x":             `could not lookup symbol "undefined"`,
+		"(ns test.mine) (ns team-b.util)":        "namespace team-b.util is already defined",
+	} {
+		_, err := gowen.ParseAndEval(input, gowen.NewEnv(false))
+		if gerr, ok := err.(gowen.Error); !ok || gerr.Err.Error() != expected {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", input, err, expected)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := compare(`(require '[team-c.shared :as c]) c/result`, `["a:1" "b:2" "A:X"]`); err != nil {
+				t.Errorf("concurrent require: got %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,147 @@
+package gowen
+
+// Numbers are int64, *big.Int (integers that do not fit into an int64), *big.Rat (ratios, e.g. 1/3)
+// and float64 (doubles, e.g. 1.5 or 1e6). Go values of other numeric types are converted to them by ToNode -
+// named types like time.Duration are kept as is. See lib/core for the arithmetic on them.
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func parseNumber(s string) (Any, bool) {
+	switch {
+	case strings.ContainsRune(s, '/'):
+		r, ok := new(big.Rat).SetString(strings.TrimPrefix(s, "+"))
+		if !ok {
+			return nil, false
+		}
+		if r.IsInt() {
+			return normalizeBigInt(new(big.Int).Set(r.Num())), true
+		}
+		return r, true
+	case strings.ContainsAny(s, ".eE"):
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	default:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, true
+		}
+		i, ok := new(big.Int).SetString(strings.TrimPrefix(s, "+"), 10)
+		return i, ok
+	}
+}
+
+func normalizeBigInt(x *big.Int) Any {
+	if x.IsInt64() {
+		return x.Int64()
+	}
+	return x
+}
+
+// normalizeNumber converts values of the unnamed numeric Go types (int, uint8, float32, ...) to int64, *big.Int or float64.
+func normalizeNumber(x Any) Any {
+	switch x := x.(type) {
+	case int:
+		return int64(x)
+	case int8:
+		return int64(x)
+	case int16:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case uint:
+		return normalizeNumber(uint64(x))
+	case uint8:
+		return int64(x)
+	case uint16:
+		return int64(x)
+	case uint32:
+		return int64(x)
+	case uint64:
+		if x > math.MaxInt64 {
+			return new(big.Int).SetUint64(x)
+		}
+		return int64(x)
+	case float32:
+		return float64(x)
+	default:
+		return x
+	}
+}
+
+func formatNumber(x Any) (string, bool) {
+	switch x := x.(type) {
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case *big.Int:
+		return x.String(), true
+	case *big.Rat:
+		return x.RatString(), true
+	case float64:
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s, true
+	default:
+		return "", false
+	}
+}
+
+// numberArg converts numeric args to the numeric Go type paramType. Numbers that do not fit into paramType cause
+// a panic rather than being truncated - floats converted to integers only lose their fraction, like (int x).
+func numberArg(arg Any, paramType reflect.Type) (reflect.Value, bool) {
+	if x, ok := arg.(int64); ok && isIntKind(paramType.Kind()) {
+		v := reflect.New(paramType).Elem()
+		assert(!v.OverflowInt(x), "%d overflows %s", x, paramType)
+		v.SetInt(x)
+		return v, true
+	}
+	var i *big.Int
+	f := 0.0
+	switch x := arg.(type) {
+	case int64:
+		i, f = big.NewInt(x), float64(x)
+	case *big.Int:
+		i = x
+		f, _ = new(big.Float).SetInt(x).Float64()
+	case *big.Rat:
+		i = new(big.Int).Quo(x.Num(), x.Denom())
+		f, _ = x.Float64()
+	case float64:
+		if !math.IsInf(x, 0) && !math.IsNaN(x) {
+			i, _ = big.NewFloat(math.Trunc(x)).Int(nil)
+		}
+		f = x
+	default:
+		return reflect.Value{}, false
+	}
+	v := reflect.New(paramType).Elem()
+	switch paramType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		assert(i != nil && i.IsInt64() && !v.OverflowInt(i.Int64()), "%s overflows %s", formatArg(arg), paramType)
+		v.SetInt(i.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		assert(i != nil && i.IsUint64() && !v.OverflowUint(i.Uint64()), "%s overflows %s", formatArg(arg), paramType)
+		v.SetUint(i.Uint64())
+	case reflect.Float32, reflect.Float64:
+		assert(math.IsInf(f, 0) || math.IsNaN(f) || !v.OverflowFloat(f), "%s overflows %s", formatArg(arg), paramType)
+		v.SetFloat(f)
+	default:
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func formatArg(x Any) string {
+	s, _ := formatNumber(x)
+	return s
+}
@@ -1,71 +1,116 @@
 package gowen
 
 import (
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Parse reads the input string into an AST (list of nodes).
-// Note that literal maps are read into ArrayMapNode, not MapNode. This allows
-// unhashable nodes (nodes containing a slice or map) to be used as map keys.
-// Only with this do nested associative destructuring and computed keys (e.g. {(+ 1 2) 3})
-// become possible. ArrayMapNode only exists until the first evaluation after which it
-// becomes a normal MapNode.
+// Note that literal maps are read into ArrayMapNode, not MapNode. The keys of a literal map are unevaluated
+// forms - ArrayMapNode keeps them in source order and does not merge keys that are only equal before
+// evaluation (e.g. {(rand) 1 (rand) 2}). ArrayMapNode only exists until the first evaluation after which it
+// becomes a normal MapNode. Literal sets are read into SetNode directly - duplicate elements are an error.
 func Parse(input string) (nodes []Node, err error) {
+	return ParseFile("", input)
+}
+
+// ParseFile is like Parse but records name as the file of the positions of the parsed nodes.
+func ParseFile(name, input string) (nodes []Node, err error) {
 	defer handleError(&err)
-	return parse(input), nil
+	return parseFile(name, input), nil
+}
+
+func parse(input string) []Node { return parseFile("", input) }
+
+func parseFile(name, input string) []Node {
+	l := lex(input)
+	l.name = name
+	return parseLoop(l, []Node{}, "")
 }
 
-func parse(input string) []Node { return parseLoop(lex(input), []Node{}, "") }
 func parseLoop(l *lexer, ns []Node, inside string) []Node {
 LOOP:
-	for t := range l.tokens {
+	for {
+		t := l.nextToken()
+		pos := l.position(t.index)
 		switch t.category {
 		case tokenParenOpen:
-			ns = append(ns, ListNode{parseLoop(l, []Node{}, "()")})
+			ns = append(ns, ListNode{Nodes: parseLoop(l, []Node{}, "()"), Pos: pos})
 		case tokenBracketOpen:
-			ns = append(ns, VectorNode{parseLoop(l, []Node{}, "[]")})
+			ns = append(ns, VectorNode{vector: newVector(parseLoop(l, []Node{}, "[]")), Pos: pos})
 		case tokenBraceOpen:
 			cns := parseLoop(l, []Node{}, "{}")
-			assert(len(cns)%2 == 0, "hashmap must have an even number of elements (%s)", cns)
-			ns = append(ns, ArrayMapNode{cns})
+			l.assert(len(cns)%2 == 0, t, "hashmap must have an even number of elements (%s)", cns)
+			ns = append(ns, ArrayMapNode{Nodes: cns, Pos: pos})
+		case tokenFnOpen:
+			l.assert(!l.inFn, t, "nested #()s are not allowed")
+			l.inFn = true
+			ns = append(ns, fnLiteral(ListNode{Nodes: parseLoop(l, []Node{}, "()"), Pos: pos}))
+			l.inFn = false
+		case tokenSetOpen:
+			cns := parseLoop(l, []Node{}, "#{}")
+			s := NewSet(cns...)
+			l.assert(s.Count() == len(cns), t, "set must not contain duplicate elements (%s)", cns)
+			ns = append(ns, s)
 		case tokenKeyword:
-			assert(len(t.string) > 1, "bad keyword")
+			l.assert(len(t.string) > 1, t, "bad keyword")
 			ns = append(ns, KeywordNode{t.string[1:]})
 		case tokenSymbol:
-			ns = append(ns, SymbolNode{t.string})
+			ns = append(ns, SymbolNode{Value: t.string, Pos: pos})
 		case tokenQuote:
-			ns = append(ns, wrapInCall("quote", parseLoop(l, []Node{}, "'")))
+			ns = append(ns, wrapInCallAt("quote", parseLoop(l, []Node{}, "'"), pos))
 		case tokenQuasiQuote:
-			ns = append(ns, wrapInCall("quasiquote", parseLoop(l, []Node{}, "'")))
+			ns = append(ns, wrapInCallAt("quasiquote", parseLoop(l, []Node{}, "'"), pos))
 		case tokenUnquote:
-			ns = append(ns, wrapInCall("unquote", parseLoop(l, []Node{}, "'")))
+			ns = append(ns, wrapInCallAt("unquote", parseLoop(l, []Node{}, "'"), pos))
+		case tokenDeref:
+			ns = append(ns, wrapInCallAt("deref", parseLoop(l, []Node{}, "'"), pos))
+		case tokenVarQuote:
+			ns = append(ns, wrapInCallAt("var", parseLoop(l, []Node{}, "'"), pos))
+		case tokenDiscard:
+			parseLoop(l, []Node{}, "'")
+			continue
+		case tokenMeta:
+			meta, n := parseLoop(l, []Node{}, "'")[0], parseLoop(l, []Node{}, "'")[0]
+			ns = append(ns, attachMeta(l, t, n, meta))
 		case tokenUnquoteSplicing:
-			ns = append(ns, wrapInCall("unquote-splicing", parseLoop(l, []Node{}, "'")))
+			ns = append(ns, wrapInCallAt("unquote-splicing", parseLoop(l, []Node{}, "'"), pos))
+		case tokenChar:
+			r, ok := parseChar(t.string[1:])
+			l.assert(ok, t, "bad character %s", t.string)
+			ns = append(ns, LiteralNode{r})
+		case tokenRegex:
+			re, err := regexp.Compile(t.string[2 : len(t.string)-1])
+			l.assert(err == nil, t, "bad regex: %v", err)
+			ns = append(ns, LiteralNode{re})
+		case tokenRawString:
+			ns = append(ns, LiteralNode{t.string[3 : len(t.string)-1]})
 		case tokenString:
 			unquoted, err := strconv.Unquote(strings.Replace(t.string, "\n", "\\n", -1))
-			assert(err == nil, "cannot parseLoop string from %v", t.string)
+			l.assert(err == nil, t, "cannot parseLoop string from %v", t.string)
 			ns = append(ns, LiteralNode{unquoted})
-		case tokenFloat:
-			float, err := strconv.ParseFloat(t.string, 64)
-			assert(err == nil, "cannot parseLoop float from %q", t.string)
-			ns = append(ns, LiteralNode{float})
+		case tokenNumber:
+			number, ok := parseNumber(t.string)
+			l.assert(ok, t, "cannot parse number from %q", t.string)
+			ns = append(ns, LiteralNode{number})
 		case tokenError:
-			assert(false, "parseLoop error: %s", t.string)
+			l.assert(false, t, "parseLoop error: %s", t.string)
 		case tokenEOF:
-			assert(inside == "", "unexpected EOF")
+			l.assert(inside == "", t, "unexpected EOF")
 			break LOOP
 		case tokenParenClose:
-			assert(inside == "()", "unexpected ) %v", t)
+			l.assert(inside == "()", t, "unexpected )")
 			break LOOP
 		case tokenBracketClose:
-			assert(inside == "[]", "unexpected ]")
+			l.assert(inside == "[]", t, "unexpected ]")
 			break LOOP
 		case tokenBraceClose:
-			assert(inside == "{}", "unexpected }")
+			l.assert(inside == "{}" || inside == "#{}", t, "unexpected }")
 			break LOOP
 		default:
-			panic(Error{t, errorf("bad token")})
+			l.assert(false, t, "bad token %v", t)
 		}
 		if inside == "'" || inside == "~" {
 			break LOOP
@@ -73,3 +118,105 @@ LOOP:
 	}
 	return ns
 }
+
+// charNames are the names of characters that cannot be written as a backslash followed by the character itself.
+var charNames = map[string]rune{
+	"newline":   '\n',
+	"space":     ' ',
+	"tab":       '\t',
+	"return":    '\r',
+	"backspace": '\b',
+	"formfeed":  '\f',
+}
+
+// parseChar returns the rune of the character literal \s - a single rune, the name of one or u followed by its
+// code point as 4 hex digits.
+func parseChar(s string) (rune, bool) {
+	if r, size := utf8.DecodeRuneInString(s); size == len(s) && r != utf8.RuneError {
+		return r, true
+	} else if r, ok := charNames[s]; ok {
+		return r, true
+	} else if len(s) == 5 && s[0] == 'u' {
+		i, err := strconv.ParseUint(s[1:], 16, 32)
+		return rune(i), err == nil
+	}
+	return 0, false
+}
+
+// attachMeta returns n with the metadata meta read via ^ merged into its metadata. A keyword k is short for
+// {k true}, a symbol or string s for {:tag s}.
+func attachMeta(l *lexer, t token, n, meta Node) Node {
+	m := metaOf(n)
+	switch meta := meta.(type) {
+	case KeywordNode:
+		m = m.Assoc(meta, LiteralNode{true})
+	case SymbolNode:
+		m = m.Assoc(KeywordNode{"tag"}, withoutPosition(meta))
+	case LiteralNode:
+		_, isString := meta.Value.(string)
+		l.assert(isString, t, "bad metadata %s", meta)
+		m = m.Assoc(KeywordNode{"tag"}, meta)
+	case ArrayMapNode:
+		for i := 0; i < len(meta.Nodes); i += 2 {
+			m = m.Assoc(withoutPosition(meta.Nodes[i]), withoutPosition(meta.Nodes[i+1]))
+		}
+	default:
+		l.assert(false, t, "bad metadata %s", meta)
+	}
+	switch n.(type) {
+	case SymbolNode, ListNode, VectorNode, ArrayMapNode:
+		return withMeta(n, m)
+	}
+	l.assert(false, t, "cannot attach metadata to %s", n)
+	return nil
+}
+
+// fnLiteral returns the fn for the body of #(...). % (short for %1) to %n are its params and %& its rest param.
+func fnLiteral(body ListNode) Node {
+	n, variadic := 0, false
+	body = fnLiteralParams(body, &n, &variadic).(ListNode)
+	params := []Node{}
+	for i := 1; i <= n; i++ {
+		params = append(params, SymbolNode{Value: "%" + strconv.Itoa(i), Pos: body.Pos})
+	}
+	if variadic {
+		params = append(params, SymbolNode{Value: "&", Pos: body.Pos}, SymbolNode{Value: "%&", Pos: body.Pos})
+	}
+	vector := VectorNode{vector: newVector(params), Pos: body.Pos}
+	return ListNode{Nodes: []Node{SymbolNode{Value: "fn", Pos: body.Pos}, vector, body}, Pos: body.Pos}
+}
+
+// fnLiteralParams replaces % with %1 in n and records the highest param used in n and whether %& is used.
+func fnLiteralParams(n Node, max *int, variadic *bool) Node {
+	params := func(ns []Node) []Node {
+		out := make([]Node, len(ns))
+		for i, n := range ns {
+			out[i] = fnLiteralParams(n, max, variadic)
+		}
+		return out
+	}
+	switch n := n.(type) {
+	case SymbolNode:
+		if n.Value == "%" {
+			n.Value = "%1"
+		}
+		if n.Value == "%&" {
+			*variadic = true
+		} else if i, err := strconv.Atoi(strings.TrimPrefix(n.Value, "%")); err == nil && n.Value[0] == '%' && i > *max {
+			*max = i
+		}
+		return n
+	case ListNode:
+		n.Nodes = params(n.Nodes)
+		return n
+	case VectorNode:
+		n.vector = newVector(params(n.Seq()))
+		return n
+	case ArrayMapNode:
+		n.Nodes = params(n.Nodes)
+		return n
+	case SetNode:
+		return NewSet(params(n.Seq())...)
+	}
+	return n
+}
@@ -26,41 +26,98 @@ var parseTests = []parseTest{
 	}},
 
 	{"vectors", `[1 2 "foo"] []`, []Node{
-		VectorNode{[]Node{
-			LiteralNode{1.0},
-			LiteralNode{2.0},
+		NewVector(
+			LiteralNode{int64(1)},
+			LiteralNode{int64(2)},
 			LiteralNode{"foo"},
-		}},
-		VectorNode{[]Node{}},
+		),
+		NewVector(),
 	}},
 
 	{"lists", `() (+ 1 2)`, []Node{
-		ListNode{[]Node{}},
-		ListNode{[]Node{
-			SymbolNode{"+"},
-			LiteralNode{1.0},
-			LiteralNode{2.0},
+		ListNode{Nodes: []Node{}},
+		ListNode{Nodes: []Node{
+			SymbolNode{Value: "+"},
+			LiteralNode{int64(1)},
+			LiteralNode{int64(2)},
 		}},
 	}},
 
 	{"maps", `{} {:foo [:bar]}`, []Node{
-		ArrayMapNode{[]Node{}},
-		ArrayMapNode{[]Node{
+		ArrayMapNode{Nodes: []Node{}},
+		ArrayMapNode{Nodes: []Node{
 			KeywordNode{"foo"},
-			VectorNode{[]Node{KeywordNode{"bar"}}},
+			NewVector(KeywordNode{"bar"}),
 		}},
 	}},
 
+	{"sets", `#{} #{:foo [:bar]}`, []Node{
+		SetNode{},
+		NewSet(KeywordNode{"foo"}, NewVector(KeywordNode{"bar"})),
+	}},
+
 	{"keywords", ":foo/bar :42", []Node{
 		KeywordNode{"foo/bar"},
 		KeywordNode{"42"},
 	}},
 
 	{"quotes", " `foo 'bar ~baz ~@bam ", []Node{
-		ListNode{[]Node{SymbolNode{"quasiquote"}, SymbolNode{"foo"}}},
-		ListNode{[]Node{SymbolNode{"quote"}, SymbolNode{"bar"}}},
-		ListNode{[]Node{SymbolNode{"unquote"}, SymbolNode{"baz"}}},
-		ListNode{[]Node{SymbolNode{"unquote-splicing"}, SymbolNode{"bam"}}},
+		ListNode{Nodes: []Node{SymbolNode{Value: "quasiquote"}, SymbolNode{Value: "foo"}}},
+		ListNode{Nodes: []Node{SymbolNode{Value: "quote"}, SymbolNode{Value: "bar"}}},
+		ListNode{Nodes: []Node{SymbolNode{Value: "unquote"}, SymbolNode{Value: "baz"}}},
+		ListNode{Nodes: []Node{SymbolNode{Value: "unquote-splicing"}, SymbolNode{Value: "bam"}}},
+	}},
+
+	{"characters", `\a \é \newline \space \u00e9 \( \\`, []Node{
+		LiteralNode{'a'},
+		LiteralNode{'é'},
+		LiteralNode{'\n'},
+		LiteralNode{' '},
+		LiteralNode{'é'},
+		LiteralNode{'('},
+		LiteralNode{'\\'},
+	}},
+
+	{"raw strings", `#r"C:\dir\n" #r"say \"hi\"" #r"" #rx`, []Node{
+		LiteralNode{`C:\dir\n`},
+		LiteralNode{`say \"hi\"`},
+		LiteralNode{""},
+		SymbolNode{Value: "#rx"},
+	}},
+
+	{"fn literals", "#(f % %3) #(apply f %&) #(vector {:a [%]})", []Node{
+		ListNode{Nodes: []Node{
+			SymbolNode{Value: "fn"},
+			NewVector(SymbolNode{Value: "%1"}, SymbolNode{Value: "%2"}, SymbolNode{Value: "%3"}),
+			ListNode{Nodes: []Node{SymbolNode{Value: "f"}, SymbolNode{Value: "%1"}, SymbolNode{Value: "%3"}}},
+		}},
+		ListNode{Nodes: []Node{
+			SymbolNode{Value: "fn"},
+			NewVector(SymbolNode{Value: "&"}, SymbolNode{Value: "%&"}),
+			ListNode{Nodes: []Node{SymbolNode{Value: "apply"}, SymbolNode{Value: "f"}, SymbolNode{Value: "%&"}}},
+		}},
+		ListNode{Nodes: []Node{
+			SymbolNode{Value: "fn"},
+			NewVector(SymbolNode{Value: "%1"}),
+			ListNode{Nodes: []Node{
+				SymbolNode{Value: "vector"},
+				ArrayMapNode{Nodes: []Node{KeywordNode{"a"}, NewVector(SymbolNode{Value: "%1"})}},
+			}},
+		}},
+	}},
+
+	{"discard", "(a #_b #_ #_c d e) #_f", []Node{
+		ListNode{Nodes: []Node{SymbolNode{Value: "a"}, SymbolNode{Value: "e"}}},
+	}},
+
+	{"var quote", "#'foo", []Node{
+		ListNode{Nodes: []Node{SymbolNode{Value: "var"}, SymbolNode{Value: "foo"}}},
+	}},
+
+	{"metadata", `^:private ^{:doc "x"} a ^String [b] ^"T" ()`, []Node{
+		SymbolNode{Value: "a", Meta: NewMap(KeywordNode{"private"}, LiteralNode{true}, KeywordNode{"doc"}, LiteralNode{"x"})},
+		withMeta(NewVector(SymbolNode{Value: "b"}), NewMap(KeywordNode{"tag"}, SymbolNode{Value: "String"})),
+		ListNode{Nodes: []Node{}, Meta: NewMap(KeywordNode{"tag"}, LiteralNode{"T"})},
 	}},
 }
 
@@ -71,8 +128,92 @@ func TestParse(t *testing.T) {
 			t.Errorf("%s: got %s", test.name, err)
 			continue
 		}
-		if !reflect.DeepEqual(nodes, test.nodes) {
+		if nodes = withoutPositions(nodes); !reflect.DeepEqual(nodes, test.nodes) {
 			t.Errorf("%s: got\n\t%#v\nexpected\n\t%#v", test.name, nodes, test.nodes)
 		}
 	}
 }
+
+type parsePositionTest struct {
+	name      string
+	input     string
+	positions []Position
+}
+
+var parsePositionTests = []parsePositionTest{
+	{"forms", "(foo [1 2]\n  {:a b})", []Position{
+		{"test.gow", 1, 1},
+		{"test.gow", 1, 2},
+		{"test.gow", 1, 6},
+		{"test.gow", 2, 3},
+		{"test.gow", 2, 7},
+	}},
+	{"quotes", "'foo\n `(bar)", []Position{
+		{"test.gow", 1, 1},
+		{"test.gow", 1, 1},
+		{"test.gow", 1, 2},
+		{"test.gow", 2, 2},
+		{"test.gow", 2, 2},
+		{"test.gow", 2, 3},
+		{"test.gow", 2, 4},
+	}},
+	{"multibyte", `"äöü" (foo)`, []Position{
+		{"test.gow", 1, 7},
+		{"test.gow", 1, 8},
+	}},
+}
+
+func TestParsePositions(t *testing.T) {
+	for _, test := range parsePositionTests {
+		nodes, err := ParseFile("test.gow", test.input)
+		if err != nil {
+			t.Errorf("%s: got %s", test.name, err)
+			continue
+		}
+		positions := []Position{}
+		var collect func(ns []Node)
+		collect = func(ns []Node) {
+			for _, n := range ns {
+				if pos := position(n); pos.IsValid() {
+					positions = append(positions, pos)
+				}
+				switch n := n.(type) {
+				case ListNode, VectorNode, ArrayMapNode:
+					collect(n.Seq())
+				}
+			}
+		}
+		collect(nodes)
+		if !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, positions, test.positions)
+		}
+	}
+}
+
+type parseErrorTest struct {
+	name  string
+	input string
+	err   string
+}
+
+var parseErrorTests = []parseErrorTest{
+	{"unexpected close", "(foo)\n  (bar))", "test.gow:2:8: unexpected )"},
+	{"unexpected EOF", "(foo\n  [bar", "test.gow:2:7: unexpected EOF"},
+	{"odd hashmap", "\n{:a}", "test.gow:2:1: hashmap must have an even number of elements ([:a])"},
+	{"duplicate set element", "#{:a :a}", "test.gow:1:1: set must not contain duplicate elements ([:a :a])"},
+	{"bad character", "(\\foo)", "test.gow:1:2: bad character \\foo"},
+	{"unterminated raw string", `(#r"foo)`, "test.gow:1:2: parseLoop error: unterminated raw string"},
+	{"bad regex", `#"("`, "test.gow:1:1: bad regex: error parsing regexp: missing closing ): `(`"},
+	{"nested fn literals", "#(map #(+ % 1) %)", "test.gow:1:7: nested #()s are not allowed"},
+	{"bad metadata", "^1 a", "test.gow:1:1: bad metadata 1"},
+	{"metadata on literal", "^:a 1", "test.gow:1:1: cannot attach metadata to 1"},
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range parseErrorTests {
+		_, err := ParseFile("test.gow", test.input)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: got\n\t%v\nexpected\n\t%v", test.name, err, test.err)
+		}
+	}
+}
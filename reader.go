@@ -0,0 +1,152 @@
+package gowen
+
+// Reader parses the forms of a stream one at a time - e.g. of a REPL or a network connection. It buffers the
+// input read so far and parses the next form from it; input that ends in the middle of a form (or of a token
+// that might continue, e.g. a symbol) is only parsed once more input was read or the stream ended.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// Reader reads top level forms from an io.Reader.
+type Reader struct {
+	r        io.Reader
+	buf      []byte   // read but not yet parsed input
+	pos      Position // of the start of buf
+	eof      bool
+	scanned  int  // length of the prefix of buf that was scanned for the end of the next form - see scan
+	depth    int  // nesting depth at the end of the scanned prefix
+	skipping bool // set after syntax errors until the rest of the line was skipped
+}
+
+var errIncomplete = errors.New("incomplete form")
+
+// NewReader returns a Reader that reads forms from r. name is recorded as the file of their positions.
+func NewReader(name string, r io.Reader) *Reader {
+	return &Reader{r: r, pos: Position{File: name, Line: 1, Column: 1}}
+}
+
+// Read returns the next top level form. It returns io.EOF once the stream ended and only whitespace and comments
+// were left. On syntax errors the rest of the offending line is skipped - reading continues with the next line.
+// Errors of the underlying io.Reader are returned as is.
+func (r *Reader) Read() (Node, error) {
+	for {
+		if r.skipLine() && r.scan() {
+			if n, err := r.parse(); err != errIncomplete {
+				r.scanned, r.depth = 0, 0
+				return n, err
+			}
+		}
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// scan lexes the input read since the last scan and reports whether the next form might be complete - i.e. whether
+// a token at the top level was read. Only then is the buffered input parsed - otherwise reading a large form in
+// small pieces would parse it again and again.
+func (r *Reader) scan() bool {
+	if r.eof {
+		return true
+	}
+	l := lex(string(r.buf[r.scanned:]))
+	for offset := r.scanned; ; {
+		t := l.nextToken()
+		if l.atEOF {
+			return false // the token might continue - or there is none
+		}
+		r.scanned = offset + l.start
+		switch t.category {
+		case tokenError:
+			return true
+		case tokenParenOpen, tokenBracketOpen, tokenBraceOpen, tokenSetOpen, tokenFnOpen:
+			r.depth++
+		case tokenParenClose, tokenBracketClose, tokenBraceClose:
+			r.depth--
+		case tokenQuote, tokenQuasiQuote, tokenUnquote, tokenUnquoteSplicing, tokenDeref, tokenDiscard, tokenMeta,
+			tokenVarQuote:
+			continue
+		}
+		if r.depth <= 0 {
+			return true
+		}
+	}
+}
+
+// parse parses the next form from the buffered input - or returns errIncomplete if more input is needed.
+func (r *Reader) parse() (n Node, err error) {
+	l := lex(string(r.buf))
+	l.name, l.line, l.column = r.pos.File, r.pos.Line-1, r.pos.Column-1
+	defer func() {
+		if x := recover(); x != nil {
+			if l.atEOF && !r.eof {
+				n, err = nil, errIncomplete
+				return
+			}
+			n, err = nil, asError(x)
+			r.advance(l.index)
+			r.skipping = true
+		}
+	}()
+	if l.peekToken().category == tokenEOF {
+		if !r.eof {
+			return nil, errIncomplete
+		}
+		r.advance(len(r.buf))
+		return nil, io.EOF
+	}
+	n = parseLoop(l, []Node{}, "'")[0]
+	if l.atEOF && !r.eof {
+		return nil, errIncomplete
+	}
+	r.advance(l.start)
+	return n, nil
+}
+
+// skipLine skips the rest of the line after a syntax error and reports whether it was skipped completely.
+func (r *Reader) skipLine() bool {
+	if !r.skipping {
+		return true
+	} else if i := bytes.IndexByte(r.buf, '\n'); i != -1 {
+		r.advance(i + 1)
+		r.skipping = false
+		return true
+	}
+	r.advance(len(r.buf))
+	r.skipping = !r.eof
+	return !r.skipping
+}
+
+// fill appends the next chunk of input to the buffer.
+func (r *Reader) fill() error {
+	if r.eof {
+		return io.EOF
+	}
+	if cap(r.buf)-len(r.buf) < 512 {
+		r.buf = append(r.buf, make([]byte, 4096)...)[:len(r.buf)]
+	}
+	n, err := r.r.Read(r.buf[len(r.buf):cap(r.buf)])
+	r.buf = r.buf[:len(r.buf)+n]
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// advance drops the first n bytes of the buffered input.
+func (r *Reader) advance(n int) {
+	consumed := r.buf[:n]
+	if i := bytes.LastIndexByte(consumed, '\n'); i != -1 {
+		r.pos.Line += bytes.Count(consumed, []byte("\n"))
+		r.pos.Column = utf8.RuneCount(consumed[i+1:]) + 1
+	} else {
+		r.pos.Column += utf8.RuneCount(consumed)
+	}
+	r.buf = r.buf[n:]
+}
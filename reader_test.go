@@ -0,0 +1,128 @@
+package gowen
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type readerTest struct {
+	name   string
+	input  string
+	output []string // printed forms - errors are prefixed with "ERROR: "
+}
+
+var readerTests = []readerTest{
+	{"forms", "1 foo (+ 1 2) [3 4] {:a 5}", []string{"1", "foo", "(+ 1 2)", "[3 4]", "{:a 5}"}},
+
+	{"brackets in strings, chars & comments", "(str \")(\" \\) \\() ; )(\n(foo ; (\n)", []string{`(str ")(" \) \()`, "(foo)"}},
+
+	{"reader macros", "'foo `(a ~b) #(+ % 1) #_ (ignored) bar", []string{"(quote foo)", "(quasiquote (a (unquote b)))", "(fn [%1] (+ %1 1))", "bar"}},
+
+	{"trailing comment", "foo ; bar", []string{"foo"}},
+
+	{"syntax error", "(foo]\nbar\nbaz", []string{"ERROR: ", "bar", "baz"}},
+
+	{"syntax error skips the rest of the line", "(foo] bar\nbaz ]\n qux", []string{"ERROR: ", "baz", "ERROR: ", "qux"}},
+
+	{"discard & metadata across reads", "#_ foo ^:a [bar] #_\n#_ 1 2 3", []string{"[bar]", "3"}},
+
+	{"incomplete form", "(foo (bar)", []string{"ERROR: "}},
+}
+
+func TestReader(t *testing.T) {
+	readers := map[string]func(string) io.Reader{
+		"one chunk":     func(s string) io.Reader { return strings.NewReader(s) },
+		"byte per read": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+	}
+	for name, reader := range readers {
+		for _, test := range readerTests {
+			r := NewReader("", reader(test.input))
+			output := []string{}
+			for {
+				n, err := r.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					output = append(output, "ERROR: ")
+					continue
+				}
+				output = append(output, n.String())
+			}
+			if strings.Join(output, " | ") != strings.Join(test.output, " | ") {
+				t.Errorf("%s (%s):\n\tgot      %q\n\texpected %q", test.name, name, output, test.output)
+			}
+			if _, err := r.Read(); err != io.EOF {
+				t.Errorf("%s (%s): expected io.EOF after the end of input - got %v", test.name, name, err)
+			}
+		}
+	}
+}
+
+func TestReaderLargeForm(t *testing.T) {
+	input := "[" + strings.Repeat("foo ", 20000) + "]"
+	start := time.Now()
+	n, err := NewReader("", iotest.OneByteReader(strings.NewReader(input))).Read()
+	if err != nil || n.(VectorNode).Count() != 20000 {
+		t.Fatalf("got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected a large form read a byte at a time to be parsed once - took %s", d)
+	}
+}
+
+func TestReaderPositions(t *testing.T) {
+	r := NewReader("repl", iotest.OneByteReader(strings.NewReader("foo\n  (bar\n   baz)  qux")))
+	expected := []string{"repl:1:1", "repl:2:3", "repl:3:10"}
+	for _, pos := range expected {
+		n, err := r.Read()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if actual := position(n).String(); actual != pos {
+			t.Errorf("%s: got position %s expected %s", n, actual, pos)
+		}
+	}
+}
+
+func TestReaderPartialInput(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader("", pr)
+	// each form is delivered as soon as it is complete - i.e. without waiting for the input that follows it
+	steps := []struct{ chunks, expected []string }{
+		{[]string{"(foo", " bar)\n"}, []string{"(foo bar)"}},
+		{[]string{"(ba", "z", ") q", "ux\n"}, []string{"(baz)", "qux"}},
+		{[]string{"(x #", "r\"a\\", "b\")\n"}, []string{`(x "a\\b")`}},
+	}
+	for _, step := range steps {
+		go func(chunks []string) {
+			for _, chunk := range chunks {
+				pw.Write([]byte(chunk))
+			}
+		}(step.chunks)
+		for _, expected := range step.expected {
+			if n, err := r.Read(); err != nil || n.String() != expected {
+				t.Errorf("got %v (%v) expected %s", n, err, expected)
+			}
+		}
+	}
+	pw.Close()
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF - got %v", err)
+	}
+}
+
+func TestParseDoesNotLeakGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		Parse("(foo (bar)")
+		Parse("(foo]")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no leaked goroutines: %d before, %d after", before, after)
+	}
+}
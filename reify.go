@@ -0,0 +1,143 @@
+package gowen
+
+// gowen fns passed to Go fns that expect a func are converted to Go funcs of the expected type via
+// reflect.MakeFunc. Go cannot create types with methods at runtime - values implementing a Go interface are
+// created by reify from the implementation registered for the interface (see RegisterInterface).
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Methods maps the names of the methods of an interface to Go funcs of their type - see RegisterInterface.
+type Methods map[string]Any
+
+var interfaces = map[reflect.Type]func(Methods) Any{}
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// RegisterInterface registers create to create values implementing the interface *nilPointer (e.g.
+// (*io.Writer)(nil)) for reify. create must return a value that calls the funcs in methods for the respective
+// methods. RegisterInterface must only be called from init.
+func RegisterInterface(nilPointer Any, create func(methods Methods) Any) {
+	t := reflect.TypeOf(nilPointer)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		panic(errorf("cannot register %T as an interface", nilPointer))
+	}
+	interfaces[t.Elem()] = create
+}
+
+func isFn(x Any) bool {
+	switch x.(type) {
+	case Closure, Fn, BorrowingFn, ComplexFn:
+		return true
+	}
+	return false
+}
+
+// reflectFn returns a Go func of type t that calls the gowen fn f in env. Args are converted via ToNode - and the
+// result like args of Go fns. A result that is a seq is spread if t has multiple results. If the last result of
+// t is an error, errors of f are returned - otherwise they panic through the Go code that called the func.
+// Go code may keep the func and call it after the evaluation that created it - env is detached (see Env.Detach),
+// so calls still stop with the context of that evaluation and count against its budget.
+func reflectFn(f Node, t reflect.Type, env *Env) reflect.Value {
+	env = env.Detach()
+	outs, returnsErr := t.NumOut(), t.NumOut() != 0 && t.Out(t.NumOut()-1) == errorType
+	if returnsErr {
+		outs--
+	}
+	return reflect.MakeFunc(t, func(args []reflect.Value) (results []reflect.Value) {
+		results = make([]reflect.Value, t.NumOut())
+		if returnsErr {
+			results[outs] = reflect.Zero(errorType)
+			defer func() {
+				if x := recover(); x != nil {
+					err := error(asError(x))
+					if IsAborted(err) {
+						panic(x)
+					}
+					for i := 0; i < outs; i++ {
+						results[i] = reflect.Zero(t.Out(i))
+					}
+					results[outs] = reflect.ValueOf(&err).Elem()
+				}
+			}()
+		}
+		argns := make([]Node, 0, len(args))
+		for i, arg := range args {
+			if t.IsVariadic() && i == len(args)-1 {
+				for j := 0; j < arg.Len(); j++ {
+					argns = append(argns, ToNode(arg.Index(j).Interface()))
+				}
+			} else {
+				argns = append(argns, ToNode(arg.Interface()))
+			}
+		}
+		n := Apply(f, argns, env.Detach())
+		switch outs {
+		case 0:
+		case 1:
+			results[0] = reflectResult(n, t.Out(0))
+		default:
+			ns := n.Seq()
+			if len(ns) != outs {
+				panic(errorf("cannot return %s from %s: expected %d values", n, t, outs))
+			}
+			for i, n := range ns {
+				results[i] = reflectResult(n, t.Out(i))
+			}
+		}
+		return results
+	})
+}
+
+func reflectResult(n Node, t reflect.Type) reflect.Value {
+	v := reflectArg(n.ToGo(), t)
+	if v.Type() == t {
+		return v
+	} else if !v.Type().AssignableTo(t) {
+		panic(errorf("cannot return %s as %s", n, t))
+	}
+	result := reflect.New(t).Elem()
+	result.Set(v)
+	return result
+}
+
+// reify returns a value implementing the interface t. Its methods call the fns in the map methods - the keys
+// are the method names in the style of member interop (e.g. :write or :serveHTTP).
+func reify(t Any, methods Node, env *Env) Any {
+	it, ok := t.(reflect.Type)
+	if !ok || it.Kind() != reflect.Interface {
+		panic(errorf("reify: %v is not an interface type", t))
+	}
+	create, ok := interfaces[it]
+	if !ok {
+		panic(errorf("reify: no implementation of %s registered - see RegisterInterface", it))
+	}
+	m, ok := toMap(methods)
+	if !ok {
+		panic(errorf("reify: methods must be a map - got %s", methods))
+	}
+	fns := Methods{}
+	m.each(func(k, v Node) {
+		name := ""
+		switch k := k.(type) {
+		case KeywordNode:
+			name = strings.Title(k.Value)
+		case SymbolNode:
+			name = strings.Title(k.Value)
+		}
+		method, ok := it.MethodByName(name)
+		if !ok {
+			panic(errorf("reify: %s is not a method of %s", k, it))
+		} else if !isFn(v.ToGo()) {
+			panic(errorf("reify: %s is not a fn", v))
+		}
+		fns[name] = reflectFn(v, method.Type, env).Interface()
+	})
+	for i := 0; i < it.NumMethod(); i++ {
+		if _, ok := fns[it.Method(i).Name]; !ok {
+			panic(errorf("reify: missing method %s of %s", it.Method(i).Name, it))
+		}
+	}
+	return create(fns)
+}
@@ -0,0 +1,158 @@
+package gowen
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Runtime owns a root env (the special forms and builtins of gowen plus all registered values)
+// and the namespaces loaded into it. Envs of different runtimes are completely independent -
+// e.g. one runtime can expose os & exec while another one only knows the builtins.
+type Runtime struct {
+	// LoadPath is the list of directories namespaces are loaded from. The namespace foo.bar-baz
+	// is loaded from the file foo/bar_baz.gow in the first directory that contains it.
+	LoadPath []string
+
+	root       *Env
+	mu         sync.RWMutex // guards namespaces & libraries
+	loadMu     sync.Mutex   // held while namespaces and libraries are loaded
+	namespaces map[string]*Env
+	loading    map[string]bool // namespaces that are being loaded from files - guarded by loadMu
+	libraries  map[string]bool
+	sandbox    *Sandbox
+	generation atomic.Uint64 // incremented on each definition - see define
+}
+
+type libraryPart struct {
+	values map[string]Any
+	input  string
+	module *Module                      // set for compiled parts - see RegisterCompiledLibrary
+	meta   map[string]map[string]string // set for metadata parts - see RegisterLibraryMeta
+}
+
+var libraries = map[string][]libraryPart{}
+
+// DefaultRuntime is the runtime used by NewEnv and Register. Libraries like lib/core register themselves
+// to it when imported.
+var DefaultRuntime = NewRuntime()
+
+// NewRuntime returns a runtime that only knows the special forms and builtins of gowen.
+func NewRuntime() *Runtime { return newRuntime(nil) }
+
+func newRuntime(sandbox *Sandbox) *Runtime {
+	r := &Runtime{LoadPath: []string{"."}, namespaces: map[string]*Env{}, loading: map[string]bool{}, libraries: map[string]bool{}, sandbox: sandbox}
+	r.root = &Env{
+		scope: &scope{values: map[string]Any{
+			"nil":   nil,
+			"true":  true,
+			"false": false,
+		}},
+		runtime: r,
+	}
+	if err := r.Register(values, `(def version "0.0.1")`); err != nil {
+		panic(err)
+	}
+	r.registerPart(libraryPart{meta: builtinMeta})
+	return r
+}
+
+// define invalidates the values of globals cached by compiled code (see global in compile.go).
+// It must be called whenever the value of a symbol changes - e.g. on def and on new namespaces & aliases.
+func (r *Runtime) define() {
+	if r != nil {
+		r.generation.Add(1)
+	}
+}
+
+// NewEnv returns a new top level env.
+func (r *Runtime) NewEnv(allowRedefine bool) *Env {
+	return &Env{scope: &scope{}, parent: r.root, allowRedefine: allowRedefine, runtime: r}
+}
+
+// Register registers the values of m and evaluates input in the root env of r.
+// Values not permitted by the sandbox of r are registered as such (see Sandbox).
+func (r *Runtime) Register(m map[string]Any, input string) error {
+	for k, v := range m {
+		if r.sandbox != nil && !r.sandbox.Permits(k, v) {
+			v = notPermitted(k)
+		}
+		r.root.Set(k, v)
+	}
+	nodes, err := Parse(input)
+	if err != nil {
+		return err
+	}
+	_, err = EvalMultiple(nodes, r.root)
+	return err
+}
+
+// RegisterLibrary adds values and input to the library name and registers them to the DefaultRuntime.
+// Libraries (e.g. lib/core) call it from init - possibly multiple times, the parts are registered in order.
+// Other runtimes can then opt in to the library via LoadLibrary.
+func RegisterLibrary(name string, values map[string]Any, input string) error {
+	return registerLibraryPart(name, libraryPart{values: values, input: input})
+}
+
+// RegisterCompiledLibrary is RegisterLibrary for a serialized module (see Module) - e.g. generated from
+// the gowen source of a library to skip parsing and expanding it on each start.
+func RegisterCompiledLibrary(name string, data []byte) error {
+	m := &Module{}
+	if err := m.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	return registerLibraryPart(name, libraryPart{module: m})
+}
+
+// RegisterLibraryMeta adds the metadata of values to the library name - e.g. the docs of the Go values registered
+// by it. meta maps the names of the values to their metadata - keywords to strings (e.g. {"doc": "..."}).
+func RegisterLibraryMeta(name string, meta map[string]map[string]string) error {
+	return registerLibraryPart(name, libraryPart{meta: meta})
+}
+
+func registerLibraryPart(name string, part libraryPart) error {
+	libraries[name] = append(libraries[name], part)
+	DefaultRuntime.mu.Lock()
+	DefaultRuntime.libraries[name] = true
+	DefaultRuntime.mu.Unlock()
+	return DefaultRuntime.registerPart(part)
+}
+
+func (r *Runtime) registerPart(part libraryPart) error {
+	if part.module != nil {
+		_, err := part.module.Eval(r.root)
+		return err
+	} else if part.meta != nil {
+		for name, kvs := range part.meta {
+			m := MapNode{}
+			for k, v := range kvs {
+				m = m.Assoc(KeywordNode{k}, LiteralNode{v})
+			}
+			r.root.setMeta(name, m)
+		}
+		return nil
+	}
+	return r.Register(part.values, part.input)
+}
+
+// LoadLibrary registers the library name to r. The package of the library must be imported.
+func (r *Runtime) LoadLibrary(name string) error {
+	parts, ok := libraries[name]
+	if !ok {
+		return errorf("unknown library %s", name)
+	}
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	r.mu.Lock()
+	loaded := r.libraries[name]
+	r.libraries[name] = true
+	r.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	for _, part := range parts {
+		if err := r.registerPart(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
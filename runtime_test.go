@@ -0,0 +1,65 @@
+package gowen_test
+
+import (
+	"testing"
+
+	"github.com/niklasfasching/gowen"
+)
+
+var runtimeTests = []struct {
+	name     string
+	core     bool
+	values   map[string]gowen.Any
+	input    string
+	expected string
+}{
+	{"builtins", false, nil, `(count [1 2 3])`, `3`},
+	{"no core", false, nil, `(+ 1 2)`, `1:2: could not lookup symbol "+": +`},
+	{"core", true, nil, `(+ 1 2)`, `3`},
+	{"registered values", false, map[string]gowen.Any{"answer": 42}, `answer`, `42`},
+}
+
+func TestRuntime(t *testing.T) {
+	for _, test := range runtimeTests {
+		r := gowen.NewRuntime()
+		if test.core {
+			if err := r.LoadLibrary("core"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Register(test.values, ""); err != nil {
+			t.Fatal(err)
+		}
+		nodes, err := gowen.Parse(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := gowen.EvalMultiple(nodes, r.NewEnv(false))
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		} else {
+			actual = result[len(result)-1].String()
+		}
+		if actual != test.expected {
+			t.Errorf("%s: got\n\t%s\nexpected\n\t%s", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestRuntimeIsolation(t *testing.T) {
+	r1, r2 := gowen.NewRuntime(), gowen.NewRuntime()
+	r1.Register(map[string]gowen.Any{"answer": 42}, "")
+	if _, ok := r1.NewEnv(false).Get("answer"); !ok {
+		t.Errorf("expected answer to be defined in r1")
+	}
+	if _, ok := r2.NewEnv(false).Get("answer"); ok {
+		t.Errorf("expected answer to be undefined in r2")
+	}
+	if _, ok := gowen.NewEnv(false).Get("answer"); ok {
+		t.Errorf("expected answer to be undefined in the default runtime")
+	}
+	if err := r1.LoadLibrary("does-not-exist"); err == nil {
+		t.Errorf("expected error for unknown library")
+	}
+}
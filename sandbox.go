@@ -0,0 +1,57 @@
+package gowen
+
+import "strings"
+
+// Sandbox restricts the values that can be registered to a runtime. Entries are either symbols (e.g. slurp)
+// or package prefixes ending in / (e.g. os/). If Allow is not empty only matching symbols are permitted -
+// special forms and builtins of gowen itself (if, fn, quasiquote, eval, ...) are always permitted unless they
+// are denied explicitly. Deny takes precedence.
+//
+// Rejected symbols stay defined but fail with a "not permitted in sandbox" error when they are evaluated or called.
+// As gowen code registered via Register or LoadLibrary is evaluated inside the sandboxed runtime, it cannot
+// reach rejected values either - the same goes for eval, parse, apply & env.
+//
+// A sandbox does not limit the resources of evaluations: sandboxed code should be evaluated via EvalContext & co
+// with a deadline and a Budget - which also limits the depth of recursion (see Budget.Depth). Permitted Go fns are
+// trusted: Go fns that block or call back into gowen from other goroutines (e.g. time/after-func - a failing callback
+// panics in the goroutine of the timer and crashes the process) should be denied - see core.Unsafe.
+type Sandbox struct {
+	Allow []string
+	Deny  []string
+}
+
+type notPermitted string
+
+// NewSandboxRuntime returns a runtime that only registers values permitted by s.
+// Its LoadPath is empty - namespaces cannot be loaded from files unless it is set explicitly.
+func NewSandboxRuntime(s Sandbox) *Runtime {
+	r := newRuntime(&s)
+	r.LoadPath = nil
+	return r
+}
+
+// Permits reports whether the value v may be registered under name.
+func (s Sandbox) Permits(name string, v Any) bool {
+	if matchesAny(name, s.Deny) {
+		return false
+	}
+	_, isBuiltin := values[name]
+	return len(s.Allow) == 0 || isBuiltin || matchesAny(name, s.Allow)
+}
+
+func matchesAny(name string, entries []string) bool {
+	for _, entry := range entries {
+		if entry == name || (strings.HasSuffix(entry, "/") && strings.HasPrefix(name, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPermitted(n Node) {
+	if ln, ok := n.(LiteralNode); ok {
+		if name, ok := ln.Value.(notPermitted); ok {
+			panic(errorf("%s is not permitted in sandbox", string(name)))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package gowen_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/niklasfasching/gowen"
+	"github.com/niklasfasching/gowen/lib/core"
+)
+
+var sandboxTests = []struct {
+	name     string
+	sandbox  gowen.Sandbox
+	input    string
+	expected string
+}{
+	{"permitted", gowen.Sandbox{Deny: core.Unsafe}, `(reduce + 0 (map count (strings/split "a bc" " ")))`, `3`},
+	{"denied package", gowen.Sandbox{Deny: core.Unsafe}, `(os/getenv "HOME")`, `os/getenv is not permitted in sandbox`},
+	{"denied symbol", gowen.Sandbox{Deny: core.Unsafe}, `(slurp "/etc/passwd")`, `slurp is not permitted in sandbox`},
+	{"denied value", gowen.Sandbox{Deny: core.Unsafe}, `[exec/command]`, `exec/command is not permitted in sandbox`},
+	{"alias", gowen.Sandbox{Deny: core.Unsafe}, `(require '[os :as o]) (o/exit 1)`, `os/exit is not permitted in sandbox`},
+	{"eval & parse", gowen.Sandbox{Deny: core.Unsafe}, `(eval (first (parse "(os/exit 1)")))`, `os/exit is not permitted in sandbox`},
+	{"apply", gowen.Sandbox{Deny: core.Unsafe}, `(apply os/exit [1])`, `os/exit is not permitted in sandbox`},
+	{"env", gowen.Sandbox{Deny: core.Unsafe}, `((get (env) "os/exit") 1)`, `os/exit is not permitted in sandbox`},
+	{"go block", gowen.Sandbox{Deny: core.Unsafe}, `(go (loop [] (recur)))`, `go* is not permitted in sandbox`},
+	{"pipeline", gowen.Sandbox{Deny: core.Unsafe}, `(pipeline 1 (chan) identity (chan))`, `pipeline is not permitted in sandbox`},
+	{"timer", gowen.Sandbox{Deny: core.Unsafe}, `(time/after-func 1 (fn [] (loop [] (recur))))`, `time/after-func is not permitted in sandbox`},
+	{"sleep", gowen.Sandbox{Deny: core.Unsafe}, `(time/sleep 1e12)`, `time/sleep is not permitted in sandbox`},
+	{"ticker", gowen.Sandbox{Deny: core.Unsafe}, `(time/tick 1)`, `time/tick is not permitted in sandbox`},
+	{"deny eval", gowen.Sandbox{Deny: []string{"eval"}}, `(eval 1)`, `eval is not permitted in sandbox`},
+	{"allow", gowen.Sandbox{Allow: []string{"+", "list", "strings/"}}, `(if true (+ 1 2))`, `3`},
+	{"allow package", gowen.Sandbox{Allow: []string{"+", "list", "strings/"}}, `(strings/to-upper "a")`, `"A"`},
+	{"not allowed", gowen.Sandbox{Allow: []string{"+", "list", "strings/"}}, `(- 1 2)`, `- is not permitted in sandbox`},
+}
+
+func TestSandbox(t *testing.T) {
+	for _, test := range sandboxTests {
+		r := gowen.NewSandboxRuntime(test.sandbox)
+		if err := r.LoadLibrary("core"); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		actual := ""
+		nodes, err := gowen.EvalMultiple(mustParse(t, test.input), r.NewEnv(false))
+		if gerr, ok := err.(gowen.Error); ok {
+			actual = gerr.Err.Error()
+		} else if err != nil {
+			actual = err.Error()
+		} else {
+			actual = nodes[len(nodes)-1].String()
+		}
+		if actual != test.expected {
+			t.Errorf("%s: got\n\t%s\nexpected\n\t%s", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestSandboxResources(t *testing.T) {
+	r := gowen.NewSandboxRuntime(gowen.Sandbox{Deny: core.Unsafe})
+	if err := r.LoadLibrary("core"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		budget  gowen.Budget
+		timeout time.Duration
+		input   string
+		err     error
+	}{
+		{"loop", gowen.Budget{Steps: 1e5}, 10 * time.Second, `(loop [] (recur))`, gowen.BudgetError{Resource: "steps", Limit: 1e5}},
+		{"infinite seq", gowen.Budget{Steps: 1e3}, 10 * time.Second, `(count (range))`, gowen.BudgetError{Resource: "steps", Limit: 1e3}},
+		{"recursion", gowen.Budget{}, 10 * time.Second, `((fn f [] (+ 1 (f))))`, gowen.BudgetError{Resource: "depth", Limit: gowen.DefaultDepth}},
+		{"deadline", gowen.Budget{}, 100 * time.Millisecond, `(loop [] (recur))`, context.DeadlineExceeded},
+	} {
+		ctx, cancel := context.WithTimeout(gowen.WithBudget(context.Background(), test.budget), test.timeout)
+		_, err := gowen.ParseAndEvalContext(ctx, test.input, r.NewEnv(false))
+		cancel()
+		if !gowen.IsAborted(err) || !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v expected %v", test.name, err, test.err)
+		}
+	}
+}
+
+func mustParse(t *testing.T, input string) []gowen.Node {
+	nodes, err := gowen.Parse(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
@@ -10,6 +10,10 @@ func EvalTopological(nodes []Node, env *Env) (_ Node, err error) {
 }
 
 func evalTopological(nodes []Node, env *Env) Node {
+	for len(nodes) != 0 && callTo(nodes[0]) == "ns" {
+		eval(nodes[0], env)
+		nodes = nodes[1:]
+	}
 	if len(nodes) == 0 {
 		return LiteralNode{nil}
 	}
@@ -43,7 +47,7 @@ func evalTopological(nodes []Node, env *Env) Node {
 	for i := 0; i < len(defNodes); i++ {
 		def := defNodes[i]
 		if len(def.deps) == 0 {
-			Eval(def.node, env)
+			eval(def.node, env)
 			defNodes[i] = defNodes[len(defNodes)-1]
 			defNodes = defNodes[:len(defNodes)-1]
 			i = 0
@@ -65,6 +69,47 @@ func evalTopological(nodes []Node, env *Env) Node {
 	return evalTopological(bodyNodes, env)
 }
 
+func expandMacro(f MacroFn, n ListNode, env *Env) Node {
+	defer func() {
+		if err := recover(); err != nil {
+			panic(wrapError(err, n, newFrame(n, f)))
+		}
+	}()
+	return f(n.Nodes[1:], env)
+}
+
+func lookupMacro(n ListNode, env *Env) (MacroFn, bool) {
+	vn, _ := env.Get(callTo(n))
+	ln, _ := vn.(LiteralNode)
+	f, isMacro := ln.Value.(MacroFn)
+	return f, isMacro
+}
+
+// paramEnv returns an env containing the name and the params (of all arities) of the fn or macro form n.
+func paramEnv(n ListNode, env *Env) *Env {
+	name, arities := parseFn(n.Nodes[1:])
+	env = ChildEnv(env)
+	env.Set(name, nil)
+	for _, a := range arities {
+		env = ChildEnv(env)
+		destructure(a.params, VectorNode{}, env)
+	}
+	return env
+}
+
+// bindingEnv returns an env containing the symbols bound by the let or loop form n.
+func bindingEnv(n ListNode, env *Env) *Env {
+	if len(n.Nodes) < 2 {
+		return env
+	}
+	bindings, _ := n.Nodes[1].(VectorNode)
+	for bs := bindings.Seq(); len(bs) >= 2; bs = bs[2:] {
+		env = ChildEnv(env)
+		destructure(bs[0], VectorNode{}, env)
+	}
+	return env
+}
+
 func getDependencies(nodes []Node) []string {
 	deps := []string{}
 	for _, n := range nodes {
@@ -74,10 +119,16 @@ func getDependencies(nodes []Node) []string {
 			case "quote":
 				continue
 			case "fn", "macro":
-				env := NewEnv(false)
-				destructure(n.Nodes[1], VectorNode{}, env)
-				for _, dep := range getDependencies(n.Nodes[2:]) {
-					if _, ok := env.values[dep]; !ok {
+				env := paramEnv(n, &Env{scope: &scope{}})
+				for _, dep := range getDependencies(n.Nodes[1:]) {
+					if _, ok := env.Get(dep); !ok {
+						deps = append(deps, dep)
+					}
+				}
+			case "let", "loop":
+				env := bindingEnv(n, &Env{scope: &scope{}})
+				for _, dep := range getDependencies(n.Nodes[1:]) {
+					if _, ok := env.Get(dep); !ok {
 						deps = append(deps, dep)
 					}
 				}
@@ -86,11 +137,11 @@ func getDependencies(nodes []Node) []string {
 			default:
 				deps = append(deps, getDependencies(n.Nodes)...)
 			}
-		case VectorNode:
-			deps = append(deps, getDependencies(n.Nodes)...)
+		case VectorNode, SetNode:
+			deps = append(deps, getDependencies(n.Seq())...)
 		case SymbolNode:
 			deps = append(deps, n.Value)
-		case LiteralNode, KeywordNode: // ignore
+		case LiteralNode, KeywordNode, AtomNode: // ignore
 		default:
 			panic(errorf("bad node (get deps): %s", n))
 		}
@@ -107,37 +158,36 @@ func expand(nodes []Node, env *Env) []Node {
 	for i := 0; i < len(nodes); i++ {
 		switch n := nodes[i].(type) {
 		case VectorNode:
-			n.Nodes = expand(n.Nodes, env)
+			nodes[i] = VectorNode{vector: newVector(expand(n.Seq(), env)), Pos: n.Pos}
+		case SetNode:
+			nodes[i] = NewSet(expand(n.Seq(), env)...)
 		case ArrayMapNode:
 			for i := range n.Nodes {
 				n.Nodes[i] = expand([]Node{n.Nodes[i]}, env)[0]
 			}
 		case MapNode:
-			en := MapNode{map[Node]Node{}}
+			en := MapNode{}
+			n.each(func(k, v Node) { en = en.Assoc(expand([]Node{k}, env)[0], expand([]Node{v}, env)[0]) })
 			nodes[i] = en
-			for k, v := range n.Nodes {
-				k = expand([]Node{k}, env)[0]
-				v = expand([]Node{v}, env)[0]
-				en.Nodes[k] = v
-			}
 		case ListNode:
-			vn, _ := env.Get(callTo(n))
-			ln, _ := vn.(LiteralNode)
-			f, isMacro := ln.Value.(MacroFn)
+			f, isMacro := lookupMacro(n, env)
 			switch {
 			case isMacro:
-				nodes[i] = f(n.Nodes[1:], env)
+				nodes[i] = expandMacro(f, n, env)
 				i--
 			case callTo(n) == "fn" || callTo(n) == "macro":
-				fnEnv := ChildEnv(env)
-				destructure(n.Nodes[1], VectorNode{}, fnEnv)
-				n.Nodes = expand(n.Nodes, fnEnv)
+				n.Nodes = expand(n.Nodes, paramEnv(n, env))
+			case callTo(n) == "let" || callTo(n) == "loop":
+				n.Nodes = expand(n.Nodes, bindingEnv(n, env))
 			case callTo(n) == "quote":
 				continue
 			default:
 				n.Nodes = expand(n.Nodes, env)
 			}
-		case SymbolNode, LiteralNode, KeywordNode:
+		case LazySeqNode:
+			nodes[i] = ListNode{Nodes: n.Seq()}
+			i--
+		case SymbolNode, LiteralNode, KeywordNode, AtomNode:
 			continue
 		default:
 			panic(errorf("bad node (expand): %s", n))
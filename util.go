@@ -2,25 +2,156 @@ package gowen
 
 import (
 	"fmt"
+	"reflect"
+	"runtime"
 )
 
+// Error is the error returned by Parse and Eval. It reports the source position of the nearest
+// form that was read by the parser as well as the innermost form that failed.
+// Stack holds the calls that were being evaluated when the error occurred - innermost first.
 type Error struct {
-	context Any
-	error   error
+	Pos   Position
+	Form  Node
+	Err   error
+	Stack []Frame
+}
+
+// Frame is a call that was being evaluated when an error occurred.
+type Frame struct {
+	Name string
+	Kind FrameKind
+	Form Node
+	Pos  Position
+}
+
+type FrameKind int
+
+const (
+	FrameFn FrameKind = iota
+	FrameMacro
+	FrameSpecial
+	FrameInterop
+)
+
+var frameKindStrings = map[FrameKind]string{
+	FrameFn:      "fn",
+	FrameMacro:   "macro",
+	FrameSpecial: "special",
+	FrameInterop: "interop",
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("%s: %s", e.error, e.context)
+	s := e.Err.Error()
+	if e.Pos.IsValid() {
+		s = e.Pos.String() + ": " + s
+	}
+	if e.Form != nil {
+		s += ": " + e.Form.String()
+	}
+	return s
+}
+
+func (e Error) Unwrap() error { return e.Err }
+
+// StackTrace returns the stack as a multi-line string (one frame per line, innermost first).
+func (e Error) StackTrace() string {
+	s := ""
+	for _, f := range e.Stack {
+		s += f.String() + "\n"
+	}
+	return s
+}
+
+func (f Frame) String() string {
+	s := fmt.Sprintf("%s [%s]", f.Name, f.Kind)
+	if f.Pos.IsValid() {
+		s += " " + f.Pos.String()
+	}
+	return s + ": " + f.Form.String()
+}
+
+func (k FrameKind) String() string { return frameKindStrings[k] }
+
+// toNode returns f as a map of :name, :kind, :form & :pos - see ex-stack.
+func (f Frame) toNode() Node {
+	pos := Node(LiteralNode{nil})
+	if f.Pos.IsValid() {
+		pos = LiteralNode{f.Pos.String()}
+	}
+	return NewMap(KeywordNode{Value: "name"}, LiteralNode{f.Name}, KeywordNode{Value: "kind"}, KeywordNode{Value: f.Kind.String()},
+		KeywordNode{Value: "form"}, f.Form, KeywordNode{Value: "pos"}, pos)
+}
+
+// caughtError returns the error n holds as an Error - see ex-message & co.
+func caughtError(n Node) Error {
+	err, ok := n.ToGo().(error)
+	assert(ok, "%s is not an error", n)
+	return asError(err)
+}
+
+func newFrame(form ListNode, fn Any) Frame {
+	f := Frame{Name: callTo(form), Form: form, Pos: form.Pos}
+	switch fn := fn.(type) {
+	case SpecialFn:
+		f.Kind = FrameSpecial
+	case MacroFn:
+		f.Kind = FrameMacro
+	case Closure:
+		f.Kind, f.Name = FrameFn, fn.Name
+	case Fn, BorrowingFn, ComplexFn:
+		f.Kind = FrameFn
+	default:
+		f.Kind = FrameInterop
+		if v := reflect.ValueOf(fn); f.Name == "" && v.Kind() == reflect.Func {
+			f.Name = runtime.FuncForPC(v.Pointer()).Name()
+		}
+	}
+	return f
 }
 
 func errorf(format string, vs ...Any) error { return fmt.Errorf(format, vs...) }
 
+func toError(x Any) error {
+	if err, ok := x.(error); ok {
+		return err
+	}
+	return errorf("%v", x)
+}
+
+func asError(x Any) Error {
+	if err, ok := x.(Error); ok {
+		return err
+	}
+	return Error{Err: toError(x)}
+}
+
+// wrapError attaches node and frames (outermost first) to the recovered panic x. The first (innermost) node
+// becomes the offending form, the first node with a valid position determines the reported position.
+func wrapError(x Any, node Node, frames ...Frame) Error {
+	err := asError(x)
+	if err.Form == nil {
+		err.Form = node
+	}
+	if !err.Pos.IsValid() {
+		err.Pos = position(node)
+	}
+	for i := len(frames) - 1; i >= 0; i-- {
+		err.Stack = append(err.Stack, frames[i])
+	}
+	return err
+}
+
 func handleError(err *error) {
 	if e := recover(); e != nil {
-		*err = Error{e, errorf("gowen")}
+		*err = asError(e)
 	}
 }
 
+func isNil(n Node) bool {
+	ln, ok := n.(LiteralNode)
+	return ok && ln.Value == nil
+}
+
 func callTo(n Node) string {
 	ln, _ := n.(ListNode)
 	if len(ln.Nodes) == 0 {
@@ -37,7 +168,11 @@ func assert(assertion bool, format string, vs ...Any) {
 }
 
 func wrapInCall(symbol string, ns []Node) ListNode {
-	return ListNode{append([]Node{SymbolNode{symbol}}, ns...)}
+	return wrapInCallAt(symbol, ns, Position{})
+}
+
+func wrapInCallAt(symbol string, ns []Node, pos Position) ListNode {
+	return ListNode{Nodes: append([]Node{SymbolNode{Value: symbol, Pos: pos}}, ns...), Pos: pos}
 }
 
 func copyAppendNodes(ns1 []Node, ns2 ...Node) []Node {
@@ -0,0 +1,145 @@
+package gowen
+
+// vector is a persistent vector (see clojure.lang.PersistentVector): a trie with a branching factor of 32 plus a tail
+// of up to 32 nodes that is pushed into the trie once it is full. Updates copy only the path to the changed leaf
+// (or the tail) and share everything else - conj, assoc and nth are O(log32 n).
+// The shape of the trie only depends on the number of nodes - equal vectors are structurally equal.
+type vector struct {
+	count int
+	shift uint
+	root  *vectorNode
+	tail  []Node
+}
+
+type vectorNode struct {
+	children []*vectorNode // inner nodes
+	values   []Node        // leaves
+}
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+func newVector(ns []Node) *vector {
+	if len(ns) == 0 {
+		return nil
+	}
+	v := &vector{shift: vectorBits, root: &vectorNode{}}
+	for _, n := range ns {
+		v.push(n)
+	}
+	return v
+}
+
+func (v *vector) tailOffset() int {
+	if v.count < vectorWidth {
+		return 0
+	}
+	return ((v.count - 1) >> vectorBits) << vectorBits
+}
+
+func (v *vector) nth(i int) Node {
+	if i >= v.tailOffset() {
+		return v.tail[i-v.tailOffset()]
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(i>>level)&vectorMask]
+	}
+	return node.values[i&vectorMask]
+}
+
+func (v *vector) nodes() []Node {
+	ns := make([]Node, 0, v.count)
+	var walk func(*vectorNode)
+	walk = func(node *vectorNode) {
+		for _, child := range node.children {
+			walk(child)
+		}
+		ns = append(ns, node.values...)
+	}
+	walk(v.root)
+	return append(ns, v.tail...)
+}
+
+// conj returns a copy of v with n appended.
+func (v *vector) conj(n Node) *vector {
+	if v == nil {
+		return newVector([]Node{n})
+	}
+	v2 := *v
+	v2.tail = v.tail[:len(v.tail):len(v.tail)] // force a copy on append - the tail is shared with v
+	v2.push(n)
+	return &v2
+}
+
+// push appends n to v in place. Only the tail and the path to the new leaf are modified - the trie is still shared.
+func (v *vector) push(n Node) {
+	if len(v.tail) < vectorWidth {
+		v.tail = append(v.tail, n)
+		v.count++
+		return
+	}
+	leaf := &vectorNode{values: v.tail}
+	if (v.count >> vectorBits) > (1 << v.shift) {
+		v.root = &vectorNode{children: []*vectorNode{v.root, newVectorPath(v.shift, leaf)}}
+		v.shift += vectorBits
+	} else {
+		v.root = v.pushTail(v.shift, v.root, leaf)
+	}
+	v.tail = []Node{n}
+	v.count++
+}
+
+func (v *vector) pushTail(level uint, parent, leaf *vectorNode) *vectorNode {
+	i := ((v.count - 1) >> level) & vectorMask
+	children := append([]*vectorNode(nil), parent.children...)
+	child := leaf
+	if level > vectorBits && i < len(children) {
+		child = v.pushTail(level-vectorBits, children[i], leaf)
+	} else if level > vectorBits {
+		child = newVectorPath(level-vectorBits, leaf)
+	}
+	if i < len(children) {
+		children[i] = child
+	} else {
+		children = append(children, child)
+	}
+	return &vectorNode{children: children}
+}
+
+func newVectorPath(level uint, leaf *vectorNode) *vectorNode {
+	if level == 0 {
+		return leaf
+	}
+	return &vectorNode{children: []*vectorNode{newVectorPath(level-vectorBits, leaf)}}
+}
+
+// assoc returns a copy of v with the node at index i replaced by n. i == count appends n.
+func (v *vector) assoc(i int, n Node) *vector {
+	if i == v.count {
+		return v.conj(n)
+	}
+	v2 := *v
+	if i >= v.tailOffset() {
+		v2.tail = append([]Node(nil), v.tail...)
+		v2.tail[i-v.tailOffset()] = n
+		return &v2
+	}
+	v2.root = assocVectorNode(v.shift, v.root, i, n)
+	return &v2
+}
+
+func assocVectorNode(level uint, node *vectorNode, i int, n Node) *vectorNode {
+	if level == 0 {
+		values := append([]Node(nil), node.values...)
+		values[i&vectorMask] = n
+		return &vectorNode{values: values}
+	}
+	children := append([]*vectorNode(nil), node.children...)
+	j := (i >> level) & vectorMask
+	children[j] = assocVectorNode(level-vectorBits, children[j], i, n)
+	return &vectorNode{children: children}
+}
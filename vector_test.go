@@ -0,0 +1,40 @@
+package gowen
+
+import (
+	"reflect"
+	"testing"
+)
+
+var vectorSizes = []int{0, 1, 32, 33, 64, 1024, 1056, 1057, 32*32*32 + 33}
+
+func TestVector(t *testing.T) {
+	for _, size := range vectorSizes {
+		ns := make([]Node, size)
+		v := VectorNode{}
+		for i := range ns {
+			ns[i] = LiteralNode{int64(i)}
+			v = v.Conj(ns[i]).(VectorNode)
+		}
+		if !reflect.DeepEqual(v, NewVector(ns...)) {
+			t.Errorf("%d: conj and NewVector differ", size)
+		}
+		if v.Count() != size || !reflect.DeepEqual(v.Seq(), ns) {
+			t.Errorf("%d: got %d nodes", size, v.Count())
+		}
+		for i, n := range ns {
+			if v.Nth(i) != n {
+				t.Errorf("%d: nth %d: got %v", size, i, v.Nth(i))
+			}
+		}
+		if size < 2 {
+			continue
+		}
+		v2 := v.Assoc(0, KeywordNode{"first"}).Assoc(size-1, KeywordNode{"last"}).Conj(KeywordNode{"new"}).(VectorNode)
+		if v2.Nth(0) != (KeywordNode{"first"}) || v2.Nth(size-1) != (KeywordNode{"last"}) || v2.Nth(size) != (KeywordNode{"new"}) {
+			t.Errorf("%d: assoc: got %v %v %v", size, v2.Nth(0), v2.Nth(size-1), v2.Nth(size))
+		}
+		if !reflect.DeepEqual(v.Seq(), ns) {
+			t.Errorf("%d: assoc & conj modified original vector", size)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package gowen
+
+// The vm evaluates the bytecode of a proto (see bytecode.go) with an operand stack per evaluation.
+// Calls to closures in tail position are returned to run like those of compiled closures - other calls are
+// evaluated by a nested run. recur in loops and fns continues in place.
+
+// exec evaluates the code of p in l - starting at pc.
+func (p *proto) exec(l *locals, pc int) (Node, *tailCall) {
+	buffer := [8]Node{}
+	stack := buffer[:]
+	if p.stack > len(buffer) {
+		stack = make([]Node, p.stack)
+	}
+	code, consts, sp := p.code, p.consts, 0
+	for {
+		in := code[pc]
+		pc++
+		switch in.op() {
+		case opConst:
+			stack[sp], sp = consts[in.arg()], sp+1
+		case opLocal:
+			stack[sp], sp = l.slots[in.arg()], sp+1
+		case opOuter:
+			o := l.parent
+			for i := in.arg()>>16 - 1; i > 0; i-- {
+				o = o.parent
+			}
+			stack[sp], sp = o.slots[in.arg()&0xffff], sp+1
+		case opGlobal:
+			stack[sp], sp = p.globals[in.arg()].lookup(l.env), sp+1
+		case opStore:
+			sp--
+			l.slots[in.arg()], stack[sp] = stack[sp], nil
+		case opBind:
+			sp--
+			p.binders[in.arg()].bind(l, stack[sp])
+			stack[sp] = nil
+		case opPop:
+			sp--
+			stack[sp] = nil
+		case opJump:
+			pc = in.arg()
+		case opJumpIfNot:
+			if sp--; !isTruthy(stack[sp]) {
+				pc = in.arg()
+			}
+			stack[sp] = nil
+		case opCheck:
+			if fln, ok := stack[sp-1].(LiteralNode); ok {
+				switch f := fln.Value.(type) {
+				case MacroFn, SpecialFn:
+					late := p.lates[in.arg()]
+					l.site = late.site
+					stack[sp-1], pc = late.eval(f, l), late.end
+					l.site = p.calls[late.call].restore
+				}
+			}
+		case opCall, opTailCall:
+			c := &p.calls[in.arg()]
+			base := sp - c.argc - 1
+			fln, ok := stack[base].(LiteralNode)
+			if !ok {
+				l.site = &site{node: c.site.node, path: c.site.path}
+				panic(errorf("cannot use %s as a function", c.site.node.(ListNode).Nodes[0]))
+			}
+			l.site, l.callee = c.site, fln.Value
+			var v Node
+			args := stack[base+1 : sp]
+			if cl, ok := fln.Value.(Closure); ok && cl.fn != nil {
+				tc := l.call(cl.fn, args, c.site)
+				if in.op() == opTailCall {
+					return nil, tc
+				}
+				l.site = c.restore
+				v = resume(nil, tc, l)
+			} else {
+				if _, ok := fln.Value.(BorrowingFn); !ok {
+					args = append([]Node(nil), args...) // the stack is reused - other fns may keep their args
+				}
+				if v = Apply(stack[base], args, l.env); in.op() == opTailCall {
+					return v, nil
+				}
+			}
+			clear(stack[base+1 : sp])
+			stack[base], sp = v, base+1
+			l.site = c.restore
+		case opReturn, opLeave:
+			return stack[sp-1], nil
+		case opRecur:
+			r := &p.recurs[in.arg()]
+			l.site = r.site
+			values := stack[sp-r.count : sp]
+			if r.variadic {
+				values = append(values[:r.count-1:r.count-1], values[r.count-1].Seq()...)
+			}
+			if p.level.captures.Load() {
+				l = l.clone()
+			}
+			if r.fn {
+				p.arity.bindArgs(l, values)
+			} else {
+				for i, b := range r.binders {
+					p.binders[b].bind(l, values[i])
+				}
+			}
+			clear(stack[r.sp:sp])
+			sp, pc = r.sp, r.pc
+			l.env.state.step()
+		case opFn:
+			stack[sp], sp = p.fns[in.arg()].instantiate(l), sp+1
+		case opLazySeq:
+			stack[sp], sp = p.protos[in.arg()].lazySeq(l), sp+1
+		case opVector, opSet, opMap:
+			n := in.arg()
+			v := newCollection(in.op(), stack[sp-n:sp])
+			l.env.state.alloc(v)
+			clear(stack[sp-n : sp])
+			sp -= n
+			stack[sp], sp = v, sp+1
+		case opDef:
+			sn := consts[in.arg()].(SymbolNode)
+			l.env.define(sn.Value, stack[sp-1], definition{meta: sn.Meta, form: l.site.topLevelForm()})
+			stack[sp-1] = LiteralNode{nil}
+		case opTry:
+			t := &p.tries[in.arg()]
+			if v, err := p.try(l, pc, t.site); err == nil {
+				stack[sp], sp, pc = v, sp+1, t.end
+			} else {
+				if t.slot >= 0 {
+					l.slots[t.slot] = ToNode(*err)
+				}
+				l.site, pc = t.site, t.catch
+			}
+		case opLate:
+			late := p.lates[in.arg()]
+			l.site = late.site
+			stack[sp], sp = late.eval(nil, l), sp+1
+		case opSite:
+			l.site = p.sites[in.arg()]
+		case opFail:
+			f := p.fails[in.arg()]
+			failed(f.err, f.site)(l)
+		}
+	}
+}
+
+// try evaluates the body of a try starting at pc - and returns the error it failed with.
+func (p *proto) try(l *locals, pc int, s *site) (n Node, err *Error) {
+	defer func() {
+		if x := recover(); x != nil {
+			n, err = nil, caught(x, l, s)
+		}
+	}()
+	n, _ = p.exec(l, pc)
+	return n, nil
+}
+
+func (p *proto) lazySeq(parent *locals) Node {
+	return NewLazySeq(parent.env, func() Node { return run(p.eval, newActivation(p.level, parent, parent.env)) })
+}
+
+func newCollection(o op, ns []Node) Node {
+	switch o {
+	case opVector:
+		return NewVector(ns...)
+	case opSet:
+		return NewSet(ns...)
+	default:
+		return NewMap(ns...)
+	}
+}
+
+// eval evaluates the call to the macro or special form f (nil: the special form the head of the form refers to)
+// in an env that holds the locals of l.
+func (c *lateCall) eval(f Any, l *locals) Node {
+	env := l.env
+	if len(c.locals) != 0 || !c.topLevel {
+		env = ChildEnv(l.env)
+		for _, local := range c.locals {
+			o := l
+			for i := 0; i < local.depth; i++ {
+				o = o.parent
+			}
+			env.Set(local.name, o.slots[local.slot])
+		}
+	}
+	if f == nil {
+		v, _ := env.Get(c.form.Nodes[0].(SymbolNode).Value)
+		ln, _ := v.(LiteralNode)
+		f = ln.Value
+	}
+	switch f := f.(type) {
+	case MacroFn:
+		return eval(f(c.form.Nodes[1:], env), env)
+	case SpecialFn:
+		n, env, isFinal := f(c.form.Nodes[1:], env)
+		if !isFinal {
+			n = eval(n, env)
+		}
+		return n
+	}
+	return eval(c.form, env)
+}
+
+// instantiate returns a closure of f that closes over l.
+func (f *fnProto) instantiate(l *locals) Node {
+	t := f.template.Load()
+	if t == nil || t.env != l.env {
+		t = &fnTemplate{name: f.name, env: l.env, isMacro: f.isMacro, arities: f.arities}
+		f.template.Store(t)
+	}
+	return newFunction(t, l).self
+}